@@ -4,12 +4,10 @@ go 1.23.6
 
 require (
 	github.com/google/flatbuffers v25.1.24+incompatible
-	github.com/xtaci/kcp-go v5.4.20+incompatible
 )
 
 require (
 	fyne.io/systray v1.11.0 // indirect
-	github.com/BurntSushi/toml v1.4.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/fredbi/uri v1.1.0 // indirect
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
@@ -43,13 +41,14 @@ require (
 	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/mobile v0.0.0-20231127183840-76ac6878050a // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
 	fyne.io/fyne/v2 v2.5.4
+	github.com/BurntSushi/toml v1.4.0
 	github.com/xtaci/kcp-go/v5 v5.6.18
+	golang.org/x/net v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -2,12 +2,14 @@ package backend
 
 import (
 	"gameproject/fb"
+	"gameproject/source/framing"
 	"gameproject/source/gametypes"
+	"gameproject/source/logging"
 	"gameproject/source/serialization"
-	"log"
+	"gameproject/source/transport"
+	"log/slog"
 
 	flatbuffers "github.com/google/flatbuffers/go"
-	"github.com/xtaci/kcp-go"
 )
 
 func createC2SCommand(command fb.ClientCommand, body []byte) []byte {
@@ -32,47 +34,206 @@ func createC2SCommand(command fb.ClientCommand, body []byte) []byte {
 	return builder.FinishedBytes()
 }
 
-func sendPing(conn *kcp.UDPSession) error {
+func sendPing(conn transport.Conn) error {
 	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_PING, nil)
 
-	_, err := conn.Write(data)
+	err := framing.WriteFrame(conn, data)
 	if err != nil {
-		log.Printf("Failed to send ping message: %v", err)
+		slog.Warn("Failed to send ping message", logging.Err(err))
 		return err
 	}
 	return nil
 }
 
-func sendRequestTime(conn *kcp.UDPSession) error {
+func sendRequestTime(conn transport.Conn) error {
 	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_REQUESTTIME, nil)
 
-	_, err := conn.Write(data)
+	err := framing.WriteFrame(conn, data)
 	if err != nil {
-		log.Printf("Failed to send request time message: %v", err)
+		slog.Warn("Failed to send request time message", logging.Err(err))
 		return err
 	}
 	return nil
 }
 
-func sendGameLoaded(conn *kcp.UDPSession) error {
+func sendGameLoaded(conn transport.Conn) error {
 	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_GAMELOADED, nil)
 
-	_, err := conn.Write(data)
+	err := framing.WriteFrame(conn, data)
 	if err != nil {
-		log.Printf("Failed to send game loaded message: %v", err)
+		slog.Warn("Failed to send game loaded message", logging.Err(err))
 		return err
 	}
 	return nil
 }
 
-func sendPlayerInput(conn *kcp.UDPSession, playerInput *gametypes.PlayerInput) error {
+func sendPlayerInfo(conn transport.Conn, nickname string) error {
+	builder := flatbuffers.NewBuilder(256)
+	nicknameOffset := builder.CreateString(nickname)
+	fb.C2SPlayerInfoStart(builder)
+	fb.C2SPlayerInfoAddNickname(builder, nicknameOffset)
+	builder.Finish(fb.C2SPlayerInfoEnd(builder))
+
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_PLAYERINFO, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send player info message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendPlayerInput(conn transport.Conn, playerInput *gametypes.PlayerInput) error {
 	bodyBytes := serialization.SerializePlayerInput(playerInput)
 
 	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_PLAYERINPUT, bodyBytes)
 
-	_, err := conn.Write(data)
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send movement message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendListRooms(conn transport.Conn) error {
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_LISTROOMS, nil)
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send list rooms message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendCreateRoom(conn transport.Conn, maxPlayers int) error {
+	builder := flatbuffers.NewBuilder(1024)
+	fb.C2SCreateRoomStart(builder)
+	fb.C2SCreateRoomAddMaxPlayers(builder, int32(maxPlayers))
+	builder.Finish(fb.C2SCreateRoomEnd(builder))
+
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_CREATEROOM, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send create room message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendJoinRoom(conn transport.Conn, roomID int) error {
+	builder := flatbuffers.NewBuilder(1024)
+	fb.C2SJoinRoomStart(builder)
+	fb.C2SJoinRoomAddRoomId(builder, int32(roomID))
+	builder.Finish(fb.C2SJoinRoomEnd(builder))
+
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_JOINROOM, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send join room message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendLeaveRoom(conn transport.Conn) error {
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_LEAVEROOM, nil)
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send leave room message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendSpectate(conn transport.Conn, roomID int) error {
+	builder := flatbuffers.NewBuilder(64)
+	fb.C2SSpectateStart(builder)
+	fb.C2SSpectateAddRoomId(builder, int32(roomID))
+	builder.Finish(fb.C2SSpectateEnd(builder))
+
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_SPECTATE, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send spectate message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendQuickMatch(conn transport.Conn, skillRating, partyID int) error {
+	builder := flatbuffers.NewBuilder(1024)
+	fb.C2SQuickMatchStart(builder)
+	fb.C2SQuickMatchAddSkillRating(builder, int32(skillRating))
+	fb.C2SQuickMatchAddPartyId(builder, int32(partyID))
+	builder.Finish(fb.C2SQuickMatchEnd(builder))
+
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_QUICKMATCH, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send quick match message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendStateHash(conn transport.Conn, logicFrame int, hash uint64) error {
+	builder := flatbuffers.NewBuilder(64)
+	fb.C2SStateHashStart(builder)
+	fb.C2SStateHashAddLogicFrame(builder, int32(logicFrame))
+	fb.C2SStateHashAddHash(builder, hash)
+	builder.Finish(fb.C2SStateHashEnd(builder))
+
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_STATEHASH, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send state hash message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+// sendReconnect 请求将当前连接绑定到原有的玩家
+func sendReconnect(conn transport.Conn, playerID int, sessionToken string) error {
+	builder := flatbuffers.NewBuilder(1024)
+	sessionTokenOffset := builder.CreateString(sessionToken)
+
+	fb.C2SReconnectStart(builder)
+	fb.C2SReconnectAddPlayerId(builder, int32(playerID))
+	fb.C2SReconnectAddSessionToken(builder, sessionTokenOffset)
+	reconnectOffset := fb.C2SReconnectEnd(builder)
+
+	builder.Finish(reconnectOffset)
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_RECONNECT, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
+	if err != nil {
+		slog.Warn("Failed to send reconnect message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+// sendLatencyProbe 原样回复服务端的RTT探测
+func sendLatencyProbe(conn transport.Conn, id int32) error {
+	builder := flatbuffers.NewBuilder(64)
+	fb.C2SLatencyProbeStart(builder)
+	fb.C2SLatencyProbeAddId(builder, id)
+	builder.Finish(fb.C2SLatencyProbeEnd(builder))
+
+	data := createC2SCommand(fb.ClientCommandC2S_COMMAND_LATENCYPROBE, builder.FinishedBytes())
+
+	err := framing.WriteFrame(conn, data)
 	if err != nil {
-		log.Printf("Failed to send movement message: %v", err)
+		slog.Warn("Failed to send latency probe reply", logging.Err(err))
 		return err
 	}
 	return nil
@@ -1,23 +1,50 @@
 package backend
 
 import (
+	"errors"
 	"fmt"
 	"gameproject/fb"
+	"gameproject/source/clock"
+	"gameproject/source/clocksync"
+	"gameproject/source/dispatch"
+	"gameproject/source/framing"
 	"gameproject/source/gametypes"
-	"gameproject/source/serialization"
-	"log"
-	"runtime/debug"
+	"gameproject/source/logging"
+	"gameproject/source/simulation"
+	"gameproject/source/transport"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"math/rand/v2"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// DefaultServerAddress 默认连接本地服务器
+const DefaultServerAddress = "127.0.0.1:12345"
 
-	"github.com/xtaci/kcp-go"
+// 断线重连相关配置
+const (
+	readTimeout          = 10 * time.Second // 超过该时间没有收到服务端的消息则认为连接已断开
+	reconnectInterval    = 1 * time.Second  // 第一次重连前的等待， 之后每次失败加倍
+	maxReconnectInterval = 5 * time.Second
+	maxReconnectAttempts = 10
 )
 
+// 交给主循环执行、尚未执行的操作的上限， 超过时丢弃新的操作
+const maxPendingRequests = 64
+
+// 游戏中重新校时的间隔
+const clockResyncInterval = 10 * time.Second
+
+// 校时请求超过该时间没有回复时视为丢失， 重新发送
+const timeSyncTimeout = 3 * time.Second
+
 type GameState int
 
 const (
 	Invalid GameState = iota
+	Lobby
 	Room
 	GameCountDown // 游戏开始前倒计时阶段
 	Game
@@ -25,7 +52,7 @@ const (
 )
 
 func (s GameState) String() string {
-	return [...]string{"Invalid", "Room", "GameCountDown", "Game", "GameOver"}[s]
+	return [...]string{"Invalid", "Lobby", "Room", "GameCountDown", "Game", "GameOver"}[s]
 }
 
 type Player struct {
@@ -33,289 +60,599 @@ type Player struct {
 	Position gametypes.Vector2Int
 }
 
+// frameSync 一次world sync， 以及在它之前收到的所有玩家输入
+// 服务端与客户端都只在world sync时执行输入， 保证各端执行的输入集合与顺序完全一致
+type frameSync struct {
+	logicFrame int
+	inputs     []gametypes.PlayerInput
+}
+
 type GameClient struct {
-	conn *kcp.UDPSession
+	conn       transport.Conn
+	transport  transport.Transport // 默认使用KCP， 需要与服务器相同
+	serverAddr string              // Connect 时使用的地址， 重连时复用
+	nickname   string
+
+	// 带有昵称、玩家ID与房间ID字段的日志， 接收消息的goroutine中也会使用
+	currentLogger atomic.Pointer[slog.Logger]
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	// 其他goroutine（界面、机器人）发起的操作， 交给主循环执行， 不在调用者的goroutine中访问客户端的状态
+	requests chan func()
 
 	heartbeatInterval time.Duration
 
-	timeSyncedTimes          int
-	systemTimeDiffWithServer int64
-	alreadyTimeSyncTimes     int
-	lastSendSyncTime         time.Time
+	timeSyncedTimes      int
+	alreadyTimeSyncTimes int
+	lastSendSyncTime     time.Time
+	timeSyncPending      bool                 // 已发送校时请求， 尚未收到回复
+	clock                *clocksync.Estimator // 与服务器时钟的偏差， 游戏中持续更新
+	timeSource           clock.Clock          // 本地时间， 测试中替换为可控的时钟
 
-	rtt time.Duration
+	maxFrameSize int // 单条消息的最大字节数
 
 	gameState GameState
 
 	playerID int
+	roomID   int
 	players  map[int]*Player
 
-	desiredGameStartTime int64
-	gameStartTime        time.Time
-
-	gameMap           *gametypes.GameMap
-	logicFrame        int
-	bUpdateLogicFrame bool
-	desiredLogicFrame int
-	lastPlayInput     *gametypes.PlayerInput
-	lastSendInputTime time.Time
-
-	syncInputQueue []gametypes.PlayerInput
+	sessionToken      string // 进入房间时服务端下发， 用于断线重连
+	reconnecting      bool   // 新连接进入大厅后需要发送重连请求
+	reconnectAttempts int
+
+	gameLoadedAt        time.Time // 模拟加载完成、发送GAMELOADED的时间， 零值表示没有在加载
+	gameStartServerTime int64     // 约定的开始时间， 服务器的Unix毫秒时间
+	gameStartTime       time.Time
+
+	gameMap             *gametypes.GameMap
+	world               *simulation.World
+	logicFrame          int
+	frameSyncs          []frameSync // 收到但尚未执行的world sync
+	lastSyncFrame       int         // 最后收到的world sync的逻辑帧， 不大于它的world sync是重复的消息
+	lastPlayInput       *gametypes.PlayerInput
+	lastInputFrame      int           // 上一次发送的输入或服务端代替的空输入的帧号， 之后的输入帧号必须更大
+	nextInputServerTime int64         // 下一次发送输入的服务器时间， 按服务器时钟对齐， 不随本地Tick的误差累积
+	sendInputInterval   time.Duration // 每个输入窗口发送一次输入， 由服务端在进入房间时下发
+	sendInputNow        bool          // 输入被服务端判定超时或重连后， 需要立即发送输入
+	serverTickRate      int           // 服务端每秒的逻辑帧数， 由服务端在进入房间时下发
+	inputLeadFrames     int           // 服务端根据RTT计算的输入提前量， 游戏中会调整
+	serverRTT           time.Duration // 服务端测得的RTT
+
+	receivedInputs []gametypes.PlayerInput // 上一次world sync之后收到的输入
+	relayedFrames  map[int]int             // 每个玩家最后收到的转发输入的帧号， 用于丢弃重复的消息
+	syncInputQueue []gametypes.PlayerInput // 帧号大于当前逻辑帧、尚未执行的输入
+
+	// 客户端预测， 本地输入立即在预测的世界上执行， 收到服务端转发的输入后回滚并重新模拟
+	predicted       *simulation.World
+	stateHistory    *simulation.StateBuffer // 已确认的世界状态， 按逻辑帧保存
+	pendingInputs   []gametypes.PlayerInput // 已发送、尚未被服务端转发的本地输入
+	predictedOrigin simulation.PlayerState  // 执行本窗口的输入之前本地玩家的预测状态
+	predictionDirty bool
+	inputConfirmed  bool // 上次预测之后收到了服务端转发的输入
+	rollbacks       int
+
+	// 播放录像时没有连接， 用录像中的服务端哈希代替上报
+	replaying       bool
+	replayChecksums map[int]uint64
+	replayErr       error
+
+	// 观战时只接收房间的广播， 不校时、不发送输入与状态哈希， 也不做预测
+	spectating bool
 
 	// 回调函数
 	bindLocalPlayer func(localID int)
 	onPlayerUpdate  func(players *Player)
+	onEnterLobby    func()
+	onRoomList      func(roomList gametypes.RoomList)
+	onGameTick      func(tickTime time.Time)
 }
 
 func NewGameClient() *GameClient {
 	client := &GameClient{
 		gameState:            Invalid,
 		alreadyTimeSyncTimes: 0,
+		clock:                clocksync.NewEstimator(clocksync.DefaultWindow),
+		timeSource:           clock.Real,
 		logicFrame:           0,
+		lastInputFrame:       -1,
+		lastSyncFrame:        -1,
+		sendInputInterval:    2 * time.Second,
+		serverTickRate:       20,
+		maxFrameSize:         framing.DefaultMaxFrameSize,
 		players:              make(map[int]*Player),
+		relayedFrames:        make(map[int]int),
 		syncInputQueue:       make([]gametypes.PlayerInput, 0),
+		stopCh:               make(chan struct{}),
+		requests:             make(chan func(), maxPendingRequests),
 	}
+	client.transport, _ = transport.New(transport.KCP)
 
 	client.gameMap = gametypes.NewGameMap(10, 10)
+	client.updateLogger()
 	return client
 }
 
-func (c *GameClient) Connect() error {
-	conn, err := kcp.DialWithOptions("127.0.0.1:12345", nil, 0, 0)
+// Connect 连接到指定的服务器， addr 为 "host:port"
+func (c *GameClient) Connect(addr string) error {
+	conn, err := c.transport.Dial(addr)
 	if err != nil {
 		return err
 	}
+	c.serverAddr = addr
 	c.conn = conn
 	return nil
 }
 
-func (c *GameClient) Start() {
-	// 创建错误通道
-	errChan := make(chan error, 1)
+// inbound 接收goroutine读到的一条消息或连接的错误， 交给主循环处理
+// 重连后旧连接上剩余的消息与错误按 conn 区分后丢弃
+type inbound struct {
+	conn  transport.Conn
+	frame []byte
+	err   error
+}
 
-	// 启动接收消息的goroutine
-	go func() {
-		if err := c.receiveMessages(); err != nil {
-			errChan <- err
-		}
-	}()
+// Start 运行客户端主循环， 调用 Stop 后返回nil， 连接断开且无法重连时返回错误
+// 收到的消息与tick都在主循环中处理， 客户端的状态只在这一个goroutine中访问
+func (c *GameClient) Start() error {
+	// 接收消息的goroutine把消息按顺序交给主循环， Start 返回后不再投递
+	inbox := make(chan inbound, 256)
+	done := make(chan struct{})
+	defer close(done)
+	c.startReceiving(inbox, done)
 
 	// 定期发送心跳
-	heartbeatTicker := time.NewTicker(1 * time.Second)
+	heartbeatTicker := c.timeSource.NewTicker(1 * time.Second)
 	defer heartbeatTicker.Stop()
 
 	// 游戏主循环ticker
-	gameTicker := time.NewTicker(time.Second / 60)
+	gameTicker := c.timeSource.NewTicker(time.Second / 60)
 	defer gameTicker.Stop()
 
+	// 等待重连时不为nil， 断线期间不发送心跳与输入
+	var reconnectTimer <-chan time.Time
+
 	for {
 		select {
-		case err := <-errChan:
-			log.Printf("Error in receive messages: %v", err)
+		case in := <-inbox:
+			if in.conn != c.conn {
+				continue
+			}
+			err := in.err
+			if err == nil {
+				if err = c.handleFrame(in.frame); err == nil {
+					continue
+				}
+			}
+			c.logger().Warn("Error in receive messages", logging.Err(err))
+			// 游戏中断线时使用新的连接重连， 服务端会保留玩家一段时间
+			if !c.canReconnect() {
+				c.Close()
+				return err
+			}
+			c.conn.Close()
+			reconnectTimer = c.timeSource.After(c.reconnectDelay())
+		case <-reconnectTimer:
+			reconnectTimer = nil
+			err := c.reconnect()
+			if err == nil {
+				c.startReceiving(inbox, done)
+				continue
+			}
+			if !c.canReconnect() {
+				c.Close()
+				return err
+			}
+			reconnectTimer = c.timeSource.After(c.reconnectDelay())
+		case <-c.stopCh:
 			c.Close()
-			fmt.Println("\n程序发生错误, 按回车键退出...")
-			fmt.Scanln()
-			return
-		case <-heartbeatTicker.C:
-			sendPing(c.conn)
-		case tickTime := <-gameTicker.C:
-			c.tick(tickTime)
+			return nil
+		case request := <-c.requests:
+			request()
+		case <-heartbeatTicker.C():
+			if reconnectTimer == nil {
+				sendPing(c.conn)
+			}
+		case tickTime := <-gameTicker.C():
+			if reconnectTimer == nil {
+				c.tick(tickTime)
+			}
 		}
 	}
 }
 
-func (c *GameClient) receiveMessages() error {
-	buffer := make([]byte, 1024)
+func (c *GameClient) startReceiving(inbox chan<- inbound, done <-chan struct{}) {
+	conn := c.conn
+	go func() {
+		err := c.receiveMessages(conn, inbox, done)
+		select {
+		case inbox <- inbound{conn: conn, err: err}:
+		case <-done:
+		}
+	}()
+}
+
+// receiveMessages 读取消息交给主循环， 读取出错或主循环退出时返回
+func (c *GameClient) receiveMessages(conn transport.Conn, inbox chan<- inbound, done <-chan struct{}) error {
+	reader := framing.NewReader(conn, c.maxFrameSize)
 	for {
-		n, err := c.conn.Read(buffer)
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		frame, err := reader.ReadFrame()
 		if err != nil {
-			log.Println("Read error:", err)
+			c.logger().Debug("Read error", logging.Err(err))
 			return fmt.Errorf("connection read error: %w", err)
 		}
-
-		// 解析接收到的消息
-		s2cCommand := fb.GetRootAsS2CCommand(buffer[:n], 0)
-		if s2cCommand == nil {
-			return fmt.Errorf("failed to parse S2CCommand")
-		}
-
-		// 根据消息类型处理
-		if err := c.handleMessage(s2cCommand); err != nil {
-			return fmt.Errorf("message handling error: %w", err)
+		select {
+		case inbox <- inbound{conn: conn, frame: frame}:
+		case <-done:
+			return nil
 		}
 	}
 }
 
-// 新增处理消息的方法
-func (c *GameClient) handleMessage(s2cCommand *fb.S2CCommand) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			stack := debug.Stack()
-			err = fmt.Errorf("panic in handleMessage:%v\nStack trace:\n%s", r, stack)
-		}
-
-	}()
-	// 根据消息类型处理
-	switch s2cCommand.Command() {
-	default:
-		log.Println("Unknown command from server:", s2cCommand.Command())
-	case fb.ServerCommandS2C_COMMAND_PONG:
-	case fb.ServerCommandS2C_COMMAND_ENTERROOM:
-		enterRoom := fb.GetRootAsS2CEnterRoom(s2cCommand.BodyBytes(), 0)
-		c.playerID = int(enterRoom.PlayerId())
-		c.bindLocalPlayer(c.playerID)
-		heartbeatInterval := float32(enterRoom.HeartbeatInterval()) / 2 // 这里用一般的时间发送Ping
-		c.heartbeatInterval = time.Duration(heartbeatInterval) * time.Second
-		c.timeSyncedTimes = int(enterRoom.TimeSyncTimes()) // 首次请求看起来会存在冷启动的问题， 首次不计入平均值
-		log.Printf("Enter room, player id: %d, heartbeat interval: %v, time sync times: %d", c.playerID, c.heartbeatInterval, c.timeSyncedTimes)
-		c.gameState = Room
+// handleFrame 解析并处理一条消息， 在主循环中调用
+func (c *GameClient) handleFrame(frame []byte) error {
+	if len(frame) < flatbuffers.SizeUOffsetT {
+		return fmt.Errorf("invalid message of %d bytes", len(frame))
+	}
 
-	case fb.ServerCommandS2C_COMMAND_STARTENTERGAME:
-		startEntetGame := serialization.DeserializeS2CStartEnterGame(s2cCommand.BodyBytes())
-		log.Printf("[StartEnterGame] players: %v", startEntetGame.Players)
+	// 解析接收到的消息
+	s2cCommand := fb.GetRootAsS2CCommand(frame, 0)
+	if s2cCommand == nil {
+		return fmt.Errorf("failed to parse S2CCommand")
+	}
 
-		// 创建客户端本地角色
-		for _, player := range startEntetGame.Players {
-			// 检查是否已经存在， 存在的话存在逻辑错误， 抛出错误
-			if _, ok := c.players[player.ID]; ok {
-				return fmt.Errorf("Player %d already exists, current players: %v", player.ID, c.players)
-			}
-			c.players[player.ID] = &Player{
-				ID:       player.ID,
-				Position: player.Position,
-			}
+	// 根据消息类型处理
+	if err := c.handleMessage(s2cCommand); err != nil {
+		return fmt.Errorf("message handling error: %w", err)
+	}
+	return nil
+}
 
-			// 通知UI更新玩家
-			if c.onPlayerUpdate != nil {
-				c.onPlayerUpdate(c.players[player.ID])
+// 新增处理消息的方法
+func (c *GameClient) handleMessage(s2cCommand *fb.S2CCommand) error {
+	// 请求失败的回复没有消息体
+	if s2cCommand.Status() == fb.S2CStatusS2C_STATUS_FAIL {
+		c.logger().Warn("Command failed",
+			logging.KeyCommand, s2cCommand.Command().String(),
+			"code", s2cCommand.Code(),
+			"message", string(s2cCommand.Message()))
+		switch s2cCommand.Command() {
+		case fb.ServerCommandS2C_COMMAND_CATCHUP:
+			c.abandonSession()
+		case fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC:
+			// 重复发送的输入被拒绝时， 之前的那一条已经被接受
+			if s2cCommand.Code() == gametypes.ErrCodeDuplicateInput {
+				break
 			}
+			// 本地输入被拒绝， 从预测中移除
+			c.rejectLocalInput()
 		}
-
-		// 模拟加载， 随机延迟后发送消息
-		go func() {
-			time.Sleep(time.Duration(0.5+float64(rand.IntN(2))) * time.Second)
-			sendGameLoaded(c.conn)
-		}()
-	case fb.ServerCommandS2C_COMMAND_STARTGAME:
-		startGame := fb.GetRootAsS2CStartGame(s2cCommand.BodyBytes(), 0)
-		c.desiredGameStartTime = startGame.AppointedServerTime() + c.systemTimeDiffWithServer
-		c.gameState = GameCountDown
-	case fb.ServerCommandS2C_COMMAND_WORLDSYNC:
-		worldSync := serialization.DeserializeWorldSync(s2cCommand.BodyBytes())
-		c.bUpdateLogicFrame = true
-		c.desiredLogicFrame = int(worldSync.LogicFrame)
-	case fb.ServerCommandS2C_COMMAND_RESPONSETIME:
-		c.alreadyTimeSyncTimes++
-		responseTime := fb.GetRootAsS2CResponseTime(s2cCommand.BodyBytes(), 0)
-		thzTimeRTT := time.Since(c.lastSendSyncTime)
-		serverTime := responseTime.ServerTime()
-		// int64
-		thzTimeSystemTimeDiffWithServer := time.Now().UnixMilli() - serverTime
-
-		log.Printf("Response time, rtt: %d ms, server time: %v ms, system time diff with server: %v ms", thzTimeRTT.Milliseconds(), serverTime, thzTimeSystemTimeDiffWithServer)
-		if c.alreadyTimeSyncTimes > 1 {
-			// rtt记录为平均值
-			c.rtt = (c.rtt*time.Duration(c.alreadyTimeSyncTimes-2) + thzTimeRTT) / time.Duration(c.alreadyTimeSyncTimes-1)
-			// 系统时间与服务器时间的差值记录为平均值
-			c.systemTimeDiffWithServer = (c.systemTimeDiffWithServer*int64(c.alreadyTimeSyncTimes-2) + thzTimeSystemTimeDiffWithServer) / int64(c.alreadyTimeSyncTimes-1)
-			log.Printf("AVG, rtt: %d ms, system time diff with server: %v ms", c.rtt.Milliseconds(), c.systemTimeDiffWithServer)
-		}
-	case fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC:
-		playerInput := serialization.DeserializePlayerInput(s2cCommand.BodyBytes())
-		// 打印收到的输入
-		log.Printf("Player %d input sync, logic frame: %d", playerInput.ID, playerInput.LogicFrame)
-		c.syncInputQueue = append(c.syncInputQueue, playerInput)
+		return nil
 	}
 
-	return nil
+	// 根据消息类型处理
+	err := dispatcher.Dispatch(c, s2cCommand.Command(), s2cCommand.BodyBytes())
+	if errors.Is(err, dispatch.ErrUnknownCommand) {
+		c.logger().Warn("Unknown command from server", logging.KeyCommand, s2cCommand.Command().String())
+		return nil
+	}
+	return err
 }
 
 func (c *GameClient) tick(tickTime time.Time) {
 	switch c.gameState {
 	case Invalid:
+	case Lobby:
 	case Room:
-		if c.alreadyTimeSyncTimes < c.timeSyncedTimes {
-			sendRequestTime(c.conn)
-			c.lastSendSyncTime = time.Now()
+		// 回复丢失时不会再收到， 超时后重新请求， 否则一直停在房间中
+		if c.timeSyncPending && c.timeSource.Since(c.lastSendSyncTime) >= timeSyncTimeout {
+			c.logger().Warn("Time sync request timed out, retrying", "timeout", timeSyncTimeout)
+			c.timeSyncPending = false
+		}
+		// 同一时间只有一个校时请求， 回复与发送时间一一对应
+		if c.alreadyTimeSyncTimes < c.timeSyncedTimes && !c.timeSyncPending {
+			c.requestTime()
+		}
+		if !c.gameLoadedAt.IsZero() && !tickTime.Before(c.gameLoadedAt) {
+			c.gameLoadedAt = time.Time{}
+			sendGameLoaded(c.conn)
 		}
 	case GameCountDown:
-		if time.Now().UnixMilli() >= c.desiredGameStartTime {
-			c.gameStartTime = time.Now()
+		if c.clock.ServerTime(c.timeSource.Now()) >= c.gameStartServerTime {
+			c.gameStartTime = c.timeSource.Now()
 			c.gameState = Game
-			c.lastSendInputTime = tickTime
+			c.nextInputServerTime = c.gameStartServerTime + c.sendInputInterval.Milliseconds()
 		}
 	case Game:
-		// Todo: 在UE中实现时， 使用游戏时间累加计算， 在服务端使用系统时间
-		// log.Printf("[%v]Game running...", tickTime.UnixMilli()-c.gameStartTime.UnixMilli())
-		// 每2秒发送自己的输入
-		if tickTime.Sub(c.lastSendInputTime) >= 2*time.Second && c.lastPlayInput != nil {
-			sendPlayerInput(c.conn, c.lastPlayInput)
-			c.lastPlayInput = nil
-			c.lastSendInputTime = tickTime
+		// 游戏中定期校时， 与world sync的单向样本一起修正时钟的漂移
+		if !c.replaying && !c.spectating && c.timeSource.Since(c.lastSendSyncTime) >= clockResyncInterval {
+			c.requestTime()
 		}
 
-		if c.bUpdateLogicFrame {
-			c.logicFrame = c.desiredLogicFrame
-			c.bUpdateLogicFrame = false
+		// Todo: 在UE中实现时， 使用游戏时间累加计算， 在服务端使用系统时间
+		// c.logger().Debug("Game running", "elapsed", tickTime.UnixMilli()-c.gameStartTime.UnixMilli())
+		// 按顺序处理收到的world sync， 执行帧号小于等于该逻辑帧的输入
+		frameSyncs := c.frameSyncs
+		c.frameSyncs = nil
+		for _, frame := range frameSyncs {
+			c.logicFrame = frame.logicFrame
+			c.syncInputQueue = append(c.syncInputQueue, frame.inputs...)
+
+			var dueInputs []gametypes.PlayerInput
+			dueInputs, c.syncInputQueue = simulation.SplitDueInputs(c.syncInputQueue, c.logicFrame)
+			for _, err := range c.world.Step(c.logicFrame, dueInputs) {
+				c.logger().Warn("Skipped command", logging.KeyLogicFrame, c.logicFrame, logging.Err(err))
+			}
+			c.syncPlayersFromWorld()
+
+			// 上报本帧的状态哈希， 由服务端检测是否不同步
+			if c.replaying {
+				c.checkReplayChecksum()
+			} else if !c.spectating {
+				sendStateHash(c.conn, c.logicFrame, c.world.Checksum())
+				c.confirmState()
+			}
 		}
 
-		// 处理收到的玩家输入，按顺序执行那些帧号小于当前逻辑帧的输入
-		if len(c.syncInputQueue) > 0 {
-			var remainingInputs []gametypes.PlayerInput
-			for _, input := range c.syncInputQueue {
-				if int(input.LogicFrame) <= c.logicFrame {
-					// 执行输入
-					if player, ok := c.players[input.ID]; ok {
-						// 计算新位置
-						newPos := player.Position
-						// switch input.CommandType {
-						// case gametypes.MoveTopLeft:
-						// 	newPos.X--
-						// case gametypes.MoveTopRight:
-						// 	newPos.X++
-						// case gametypes.MoveTop:
-						// 	newPos.Y--
-						// case gametypes.MoveDown:
-						// 	newPos.Y++
-						// }
-
-						// 检查新位置是否在地图范围内
-						if newPos.X >= 0 && newPos.X < c.gameMap.MapData.Width &&
-							newPos.Y >= 0 && newPos.Y < c.gameMap.MapData.Height {
-							// 更新位置
-							player.Position = newPos
-
-							// 通知UI更新玩家位置
-							if c.onPlayerUpdate != nil {
-								c.onPlayerUpdate(player)
-							}
-						}
-					} else {
-						log.Printf("[Error]tick Player %d not found", input.ID)
-					}
-				} else {
-					// 将未处理的输入保存回队列
-					remainingInputs = append(remainingInputs, input)
-				}
+		if c.onGameTick != nil {
+			c.onGameTick(tickTime)
+		}
+		// 回调中发起的操作在本tick发送输入之前执行
+		c.runRequests()
+
+		// 每个发送间隔发送一次自己的输入， 没有操作时发送空输入， 输入超时后立即补发
+		// 在执行完收到的world sync之后发送， 帧号不会落后于已收到的逻辑帧
+		// 发送时间按服务器时钟对齐到开始时间之后的整数个间隔， 各端的发送时间不会逐渐错开
+		// 高延迟的玩家按服务端下发的提前量提早发送， 输入在窗口开始时到达服务端
+		serverNow := c.clock.ServerTime(tickTime) + c.inputLead().Milliseconds()
+		inputDue := serverNow >= c.nextInputServerTime
+		if !c.replaying && !c.spectating && (inputDue || c.sendInputNow) {
+			input := c.lastPlayInput
+			if input == nil {
+				input = &gametypes.PlayerInput{ID: c.playerID}
 			}
-
-			// 更新输入队列，只保留未处理的输入
-			c.syncInputQueue = remainingInputs
+			input.LogicFrame = c.nextInputFrame()
+			sendPlayerInput(c.conn, input)
+			if len(input.Commands) > 0 {
+				c.pendingInputs = append(c.pendingInputs, *input)
+			}
+			c.lastPlayInput = nil
+			c.sendInputNow = false
 		}
+		if inputDue {
+			c.nextInputServerTime = nextInputTime(c.nextInputServerTime, serverNow, c.sendInputInterval.Milliseconds())
+		}
+
+		// 本地输入与服务端转发的输入都在预测的世界上立即执行
+		c.predict()
+		c.syncPlayersFromWorld()
 	case GameOver:
 	default:
-		log.Println("未处理的 game state")
+		c.logger().Warn("未处理的 game state", "state", c.gameState)
 	}
 }
 
+// Stop 通知 Start 退出主循环并关闭连接
+func (c *GameClient) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+}
+
 func (c *GameClient) Close() {
 	if c.conn != nil {
-		log.Println("Closing client connection...")
+		c.logger().Info("Closing client connection")
 		c.conn.Close()
 		c.conn = nil
 	}
 }
 
+func (c *GameClient) canReconnect() bool {
+	return c.sessionToken != "" && c.world != nil && c.reconnectAttempts < maxReconnectAttempts
+}
+
+// reconnectDelay 下一次重连前的等待时间， 从 reconnectInterval 开始每次失败加倍， 不超过 maxReconnectInterval
+func (c *GameClient) reconnectDelay() time.Duration {
+	delay := reconnectInterval
+	for i := 0; i < c.reconnectAttempts && delay < maxReconnectInterval; i++ {
+		delay *= 2
+	}
+	return min(delay, maxReconnectInterval)
+}
+
+// reconnect 建立新的连接， 进入大厅后再发送重连请求
+func (c *GameClient) reconnect() error {
+	c.reconnectAttempts++
+	c.logger().Info("Connection lost, reconnecting", "attempt", c.reconnectAttempts, "max_attempts", maxReconnectAttempts)
+	if err := c.Connect(c.serverAddr); err != nil {
+		c.logger().Warn("Reconnect failed", "attempt", c.reconnectAttempts, logging.Err(err))
+		return err
+	}
+	c.reconnecting = true
+	return nil
+}
+
+// applyCatchUp 从服务端的快照重建世界， 之后在下一次world sync时执行快照之后的所有输入追上当前逻辑帧
+func (c *GameClient) applyCatchUp(catchUp gametypes.CatchUp) {
+	c.logger().Info("Catch up",
+		"room_state", catchUp.GameState,
+		"snapshot_frame", catchUp.SnapshotFrame,
+		logging.KeyLogicFrame, catchUp.LogicFrame,
+		"pending_inputs", len(catchUp.PendingInputs),
+		"input_log", len(catchUp.InputLog))
+	c.reconnecting = false
+	c.reconnectAttempts = 0
+
+	c.world = simulation.NewWorld(c.gameMap)
+	for _, player := range catchUp.Players {
+		c.world.AddPlayer(player.ID, player.Position)
+		if _, ok := c.players[player.ID]; ok {
+			continue
+		}
+		// 中途加入时本地还没有该玩家
+		c.players[player.ID] = &Player{
+			ID:       player.ID,
+			Position: player.Position,
+		}
+		if c.onPlayerUpdate != nil {
+			c.onPlayerUpdate(c.players[player.ID])
+		}
+	}
+	c.world.Step(catchUp.SnapshotFrame, nil)
+	c.resetPrediction()
+	c.syncPlayersFromWorld()
+
+	c.logicFrame = catchUp.SnapshotFrame
+	c.lastSyncFrame = catchUp.SnapshotFrame
+	c.frameSyncs = nil
+	c.syncInputQueue = catchUp.PendingInputs
+	c.receivedInputs = catchUp.InputLog
+	c.relayedFrames = make(map[int]int)
+	for _, inputs := range [][]gametypes.PlayerInput{catchUp.PendingInputs, catchUp.InputLog} {
+		for _, input := range inputs {
+			c.relayedFrames[input.ID] = max(c.relayedFrames[input.ID], input.LogicFrame)
+		}
+	}
+
+	switch catchUp.GameState {
+	case gametypes.RoomStateWaitPlayersReady:
+		c.gameState = Room
+		sendGameLoaded(c.conn)
+	case gametypes.RoomStateGameCountDown:
+		c.gameStartServerTime = catchUp.AppointedServerTime
+		c.gameState = GameCountDown
+	case gametypes.RoomStateGame:
+		c.gameStartServerTime = catchUp.AppointedServerTime
+		c.nextInputServerTime = nextInputTime(catchUp.AppointedServerTime, c.clock.ServerTime(c.timeSource.Now()), c.sendInputInterval.Milliseconds())
+		c.gameState = Game
+		c.sendInputNow = true
+	default:
+		c.logger().Warn("Unexpected room state in catch up", "room_state", catchUp.GameState)
+	}
+}
+
+// inputLead 输入提前发送的时间
+func (c *GameClient) inputLead() time.Duration {
+	return time.Duration(c.inputLeadFrames) * time.Second / time.Duration(c.serverTickRate)
+}
+
+// InputDelay 返回服务端下发的输入提前量（逻辑帧数）与服务端测得的RTT
+func (c *GameClient) InputDelay() (frames int, rtt time.Duration) {
+	return c.inputLeadFrames, c.serverRTT
+}
+
+// requestTime 发送校时请求， 先记录状态再发送， 低延迟的连接上回复可能在发送返回之前就被处理
+func (c *GameClient) requestTime() {
+	c.lastSendSyncTime = c.timeSource.Now()
+	c.timeSyncPending = true
+	sendRequestTime(c.conn)
+}
+
+// nextInputFrame 输入的帧号， 通常为最近一次world sync的逻辑帧
+// 两次world sync之间发送多次输入（超时补发、发送间隔短于world sync间隔）时依次加一， 服务端只接受帧号大于上一次输入的输入，
+// 这些帧号仍不超过下一次world sync的逻辑帧， 与第一次输入在同一次world sync时执行
+func (c *GameClient) nextInputFrame() int {
+	frame := max(c.logicFrame, c.lastInputFrame+1)
+	c.lastInputFrame = frame
+	return frame
+}
+
+// nextInputTime 返回 from 之后按 interval 对齐、晚于 now 的第一个发送时间
+func nextInputTime(from, now, interval int64) int64 {
+	if interval <= 0 || from > now {
+		return from
+	}
+	return from + ((now-from)/interval+1)*interval
+}
+
+// abandonSession 重连失败， 放弃原来的对局， 新连接作为新玩家留在大厅
+func (c *GameClient) abandonSession() {
+	c.logger().Warn("Reconnect rejected, back to lobby")
+	c.reconnecting = false
+	c.reconnectAttempts = 0
+	c.resetMatch()
+	if c.onEnterLobby != nil {
+		c.onEnterLobby()
+	}
+}
+
+// resetMatch 离开房间回到大厅， 清除上一局的玩家、世界、收到的输入与预测， 下一局从头开始
+func (c *GameClient) resetMatch() {
+	c.sessionToken = ""
+	c.roomID = 0
+	c.spectating = false
+	c.gameLoadedAt = time.Time{}
+	c.world = nil
+	c.players = make(map[int]*Player)
+	c.logicFrame = 0
+	c.lastSyncFrame = -1
+	c.frameSyncs = nil
+	c.receivedInputs = nil
+	c.relayedFrames = make(map[int]int)
+	c.syncInputQueue = nil
+	c.lastPlayInput = nil
+	c.lastInputFrame = -1
+	c.sendInputNow = false
+	c.predicted = nil
+	c.stateHistory = nil
+	c.pendingInputs = nil
+	c.predictionDirty = false
+	c.inputConfirmed = false
+	c.gameState = Lobby
+	c.updateLogger()
+}
+
+// Resume 使用之前保存的会话加入正在进行的对局， 需要在Start之前调用
+// 连接进入大厅后会发送重连请求， 由追帧数据恢复到当前的逻辑帧
+func (c *GameClient) Resume(playerID int, sessionToken string) {
+	c.playerID = playerID
+	c.sessionToken = sessionToken
+	c.reconnecting = true
+}
+
+func (c *GameClient) State() GameState {
+	return c.gameState
+}
+
+func (c *GameClient) PlayerID() int {
+	return c.playerID
+}
+
+// ClockStatus 返回与服务器时钟的偏差、漂移与置信度
+func (c *GameClient) ClockStatus() clocksync.Status {
+	return c.clock.Status()
+}
+
+// SessionToken 返回当前对局的会话令牌， 可以保存下来用于 Resume
+func (c *GameClient) SessionToken() string {
+	return c.sessionToken
+}
+
+// syncPlayersFromWorld 将世界状态（有预测时为预测的状态）同步到玩家表， 并通知UI更新位置发生变化的玩家
+func (c *GameClient) syncPlayersFromWorld() {
+	for _, state := range c.currentWorld().Players() {
+		player, ok := c.players[state.ID]
+		if !ok {
+			c.logger().Error("Player not found", logging.KeyPlayerID, state.ID)
+			continue
+		}
+		if player.Position.Equal(&state.Position) {
+			continue
+		}
+		player.Position = state.Position
+
+		// 通知UI更新玩家位置
+		if c.onPlayerUpdate != nil {
+			c.onPlayerUpdate(player)
+		}
+	}
+}
+
+// SendMovement 记录一次移动输入， 在下一次发送输入时发送给服务端
+// 可以在任意goroutine中调用， 输入交给主循环基于预测的位置生成命令
 func (c *GameClient) SendMovement(dx, dy int) error {
 	if dx == 0 && dy == 0 {
 		// 错误的输入
@@ -323,26 +660,181 @@ func (c *GameClient) SendMovement(dx, dy int) error {
 		return err
 	}
 
-	// var inputType gametypes.PlayerCommandType
-
-	// if dx > 0 {
-	// 	inputType = gametypes.MoveTopRight
-	// } else if dx < 0 {
-	// 	inputType = gametypes.MoveTopLeft
-	// } else if dy > 0 {
-	// 	inputType = gametypes.MoveTop
-	// } else if dy < 0 {
-	// 	inputType = gametypes.MoveDown
-	// }
-	// c.lastPlayInput = &gametypes.PlayerInput{
-	// 	ID:          c.playerID,
-	// 	LogicFrame:  c.logicFrame,
-	// 	CommandType: inputType,
-	// }
+	return c.post(func() {
+		if err := c.applyMovement(dx, dy); err != nil {
+			c.logger().Warn("Movement ignored", "dx", dx, "dy", dy, logging.Err(err))
+		}
+	})
+}
+
+// applyMovement 把移动输入记录为下一次发送的输入， 在主循环中调用
+func (c *GameClient) applyMovement(dx, dy int) error {
+	if c.world == nil {
+		return fmt.Errorf("游戏尚未开始")
+	}
+
+	if c.replaying {
+		return fmt.Errorf("正在播放录像")
+	}
+
+	if c.spectating {
+		return fmt.Errorf("正在观战")
+	}
+
+	// 基于已发送的输入预测执行后的位置， 不需要等待服务端转发
+	player, ok := c.world.Player(c.playerID)
+	if c.predicted != nil {
+		player, ok = c.predictedOrigin, c.predictedOrigin.ID == c.playerID
+	}
+	if !ok {
+		return fmt.Errorf("本地玩家 %d 不存在", c.playerID)
+	}
+
+	// 每个发送间隔只发送最后一次输入
+	c.lastPlayInput = &gametypes.PlayerInput{
+		ID: c.playerID,
+		Commands: []gametypes.PlayerCommand{
+			{
+				CommandType: gametypes.UseAbility,
+				AbilityID:   simulation.AbilityMove,
+				Position:    *player.Position.Add(&gametypes.Vector2Int{X: dx, Y: dy}),
+			},
+		},
+	}
+	c.predictionDirty = true
 
 	return nil
 }
 
+// send 在主循环中使用当前的连接发送请求， 重连会在主循环中替换连接
+func (c *GameClient) send(name string, request func(conn transport.Conn) error) error {
+	return c.post(func() {
+		if err := request(c.conn); err != nil {
+			c.logger().Warn("Failed to send request", "request", name, logging.Err(err))
+		}
+	})
+}
+
+// post 把操作交给主循环执行， 不会阻塞， 主循环中的回调也可以调用
+func (c *GameClient) post(request func()) error {
+	select {
+	case c.requests <- request:
+		return nil
+	default:
+		return fmt.Errorf("待处理的操作过多")
+	}
+}
+
+// runRequests 执行已经提交的操作， 在主循环中调用
+func (c *GameClient) runRequests() {
+	for {
+		select {
+		case request := <-c.requests:
+			request()
+		default:
+			return
+		}
+	}
+}
+
+// ListRooms 请求大厅中的房间列表， 结果通过 SetOnRoomList 设置的回调返回
+// 请求类的方法可以在任意goroutine中调用， 请求在主循环中发送
+func (c *GameClient) ListRooms() error {
+	return c.send("ListRooms", func(conn transport.Conn) error {
+		return sendListRooms(conn)
+	})
+}
+
+// CreateRoom 创建房间并加入， maxPlayers 为0时使用服务器配置的人数
+func (c *GameClient) CreateRoom(maxPlayers int) error {
+	return c.send("CreateRoom", func(conn transport.Conn) error {
+		return sendCreateRoom(conn, maxPlayers)
+	})
+}
+
+func (c *GameClient) JoinRoom(roomID int) error {
+	return c.send("JoinRoom", func(conn transport.Conn) error {
+		return sendJoinRoom(conn, roomID)
+	})
+}
+
+// LeaveRoom 离开房间回到大厅， 仅在游戏开始前有效
+func (c *GameClient) LeaveRoom() error {
+	return c.send("LeaveRoom", func(conn transport.Conn) error {
+		return sendLeaveRoom(conn)
+	})
+}
+
+// Spectate 观战指定房间， roomID 为0时由服务端选择一个房间
+// 观战者按服务端的观战延迟收到房间的广播， 不能发送输入
+func (c *GameClient) Spectate(roomID int) error {
+	return c.send("Spectate", func(conn transport.Conn) error {
+		return sendSpectate(conn, roomID)
+	})
+}
+
+// QuickMatch 进入匹配队列， partyID 为0表示单排
+func (c *GameClient) QuickMatch(skillRating, partyID int) error {
+	return c.send("QuickMatch", func(conn transport.Conn) error {
+		return sendQuickMatch(conn, skillRating, partyID)
+	})
+}
+
+// SetMaxFrameSize 设置允许接收的最大消息长度， 需要在Start之前调用
+func (c *GameClient) SetMaxFrameSize(size int) {
+	c.maxFrameSize = size
+}
+
+// SetTransport 设置连接服务器使用的传输方式， 需要在 Connect 之前调用
+func (c *GameClient) SetTransport(t transport.Transport) {
+	c.transport = t
+}
+
+// SetClock 设置游戏逻辑使用的时钟， 需要在 Start 之前调用， 默认使用系统时间
+// 重连的等待同样使用该时钟， 连接的读超时仍然使用系统时间
+func (c *GameClient) SetClock(t clock.Clock) {
+	c.timeSource = t
+}
+
+// SetNickname 设置昵称， 进入房间后上报给服务端， 需要在Connect之前调用
+func (c *GameClient) SetNickname(nickname string) {
+	c.nickname = nickname
+	c.updateLogger()
+}
+
+// updateLogger 昵称、玩家ID或房间变化后更新日志的字段
+func (c *GameClient) updateLogger() {
+	var attrs []any
+	if c.nickname != "" {
+		attrs = append(attrs, logging.KeyClient, c.nickname)
+	}
+	if c.playerID != 0 {
+		attrs = append(attrs, logging.KeyPlayerID, c.playerID)
+	}
+	if c.roomID != 0 {
+		attrs = append(attrs, logging.KeyRoomID, c.roomID)
+	}
+	c.currentLogger.Store(slog.Default().With(attrs...))
+}
+
+func (c *GameClient) logger() *slog.Logger {
+	return c.currentLogger.Load()
+}
+
+// SetOnGameTick 游戏进行中每个tick执行完world sync之后、发送输入之前调用
+// 与 Start 在同一个goroutine中执行， 回调中调用的 SendMovement 在本tick发送输入之前生效
+func (c *GameClient) SetOnGameTick(callback func(tickTime time.Time)) {
+	c.onGameTick = callback
+}
+
+func (c *GameClient) SetOnEnterLobby(callback func()) {
+	c.onEnterLobby = callback
+}
+
+func (c *GameClient) SetOnRoomList(callback func(roomList gametypes.RoomList)) {
+	c.onRoomList = callback
+}
+
 func (c *GameClient) SetOnPlayersUpdate(callback func(player *Player)) {
 	c.onPlayerUpdate = callback
 }
@@ -0,0 +1,283 @@
+package backend
+
+import (
+	"fmt"
+	"gameproject/fb"
+	"gameproject/source/dispatch"
+	"gameproject/source/gametypes"
+	"gameproject/source/logging"
+	"gameproject/source/serialization"
+	"gameproject/source/simulation"
+	"math/rand/v2"
+	"time"
+)
+
+// dispatcher 服务端命令的处理函数， 所有客户端共用
+var dispatcher = newDispatcher()
+
+func newDispatcher() *dispatch.Registry[fb.ServerCommand, *GameClient] {
+	r := dispatch.NewRegistry[fb.ServerCommand, *GameClient]()
+	r.Use(dispatch.Recover[fb.ServerCommand, *GameClient]())
+
+	dispatch.RegisterEmpty(r, fb.ServerCommandS2C_COMMAND_PONG, (*GameClient).handlePong)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_ENTERLOBBY, dispatch.Table(fb.GetRootAsS2CEnterLobby), (*GameClient).handleEnterLobby)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_ROOMLIST, dispatch.Decode(serialization.DeserializeS2CRoomList), (*GameClient).handleRoomList)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_QUICKMATCH, dispatch.Table(fb.GetRootAsS2CQuickMatch), (*GameClient).handleQuickMatch)
+	dispatch.RegisterEmpty(r, fb.ServerCommandS2C_COMMAND_LEAVEROOM, (*GameClient).handleLeaveRoom)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_SPECTATE, dispatch.Table(fb.GetRootAsS2CSpectate), (*GameClient).handleSpectate)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_ENTERROOM, dispatch.Table(fb.GetRootAsS2CEnterRoom), (*GameClient).handleEnterRoom)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_STARTENTERGAME, dispatch.Decode(serialization.DeserializeS2CStartEnterGame), (*GameClient).handleStartEnterGame)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_STARTGAME, dispatch.Table(fb.GetRootAsS2CStartGame), (*GameClient).handleStartGame)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_CATCHUP, dispatch.Decode(serialization.DeserializeS2CCatchUp), (*GameClient).handleCatchUp)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_WORLDSYNC, dispatch.Decode(serialization.DeserializeWorldSync), (*GameClient).handleWorldSync)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_RESPONSETIME, dispatch.Table(fb.GetRootAsS2CResponseTime), (*GameClient).handleResponseTime)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_LATENCYPROBE, dispatch.Table(fb.GetRootAsS2CLatencyProbe), (*GameClient).handleLatencyProbe)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_INPUTDELAY, dispatch.Table(fb.GetRootAsS2CInputDelay), (*GameClient).handleInputDelay)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC, dispatch.Decode(serialization.DeserializePlayerInput), (*GameClient).handlePlayerInputSync)
+	return r
+}
+
+func (c *GameClient) handlePong() error {
+	return nil
+}
+
+func (c *GameClient) handleEnterLobby(enterLobby *fb.S2CEnterLobby) error {
+	if c.reconnecting {
+		// 新连接先以新玩家的身份进入大厅， 请求绑定回原有的玩家
+		c.logger().Info("Reconnecting", "temporary_player_id", enterLobby.PlayerId())
+		sendReconnect(c.conn, c.playerID, c.sessionToken)
+		return nil
+	}
+	c.playerID = int(enterLobby.PlayerId())
+	c.gameState = Lobby
+	c.updateLogger()
+	c.logger().Info("Enter lobby")
+	if c.onEnterLobby != nil {
+		c.onEnterLobby()
+	}
+	return nil
+}
+
+func (c *GameClient) handleRoomList(roomList gametypes.RoomList) error {
+	c.logger().Debug("Room list", "rooms", roomList.Rooms)
+	if c.onRoomList != nil {
+		c.onRoomList(roomList)
+	}
+	return nil
+}
+
+func (c *GameClient) handleQuickMatch(quickMatch *fb.S2CQuickMatch) error {
+	c.logger().Info("Waiting for quick match", "queue_size", quickMatch.QueueSize())
+	return nil
+}
+
+func (c *GameClient) handleLeaveRoom() error {
+	c.logger().Info("Left room, back to lobby")
+	c.resetMatch()
+	return nil
+}
+
+func (c *GameClient) handleSpectate(spectate *fb.S2CSpectate) error {
+	if c.spectating && c.roomID == int(spectate.RoomId()) {
+		c.logger().Debug("Ignoring duplicate spectate")
+		return nil
+	}
+	c.roomID = int(spectate.RoomId())
+	c.spectating = true
+	c.playerID = 0
+	c.updateLogger()
+	c.logger().Info("Spectating room", "max_players", spectate.MaxPlayers(), "delay", time.Duration(spectate.Delay())*time.Millisecond)
+	c.gameState = Room
+	return nil
+}
+
+func (c *GameClient) handleEnterRoom(enterRoom *fb.S2CEnterRoom) error {
+	// 重复的消息带有相同的会话凭证， 重连时的回复由下面的分支处理
+	if !c.reconnecting && c.sessionToken != "" && string(enterRoom.SessionToken()) == c.sessionToken {
+		c.logger().Debug("Ignoring duplicate enter room")
+		return nil
+	}
+	c.playerID = int(enterRoom.PlayerId())
+	c.roomID = int(enterRoom.RoomId())
+	c.sessionToken = string(enterRoom.SessionToken())
+	c.updateLogger()
+	if c.bindLocalPlayer != nil {
+		c.bindLocalPlayer(c.playerID)
+	}
+	heartbeatInterval := float32(enterRoom.HeartbeatInterval()) / 2 // 这里用一般的时间发送Ping
+	c.heartbeatInterval = time.Duration(heartbeatInterval) * time.Second
+	c.timeSyncedTimes = int(enterRoom.TimeSyncTimes()) // 首次请求可能存在冷启动的问题， 由选取RTT最小的样本过滤
+	if interval := enterRoom.SendInputInterval(); interval > 0 {
+		c.sendInputInterval = time.Duration(float64(interval) * float64(time.Second))
+	}
+	if tickRate := enterRoom.TickRate(); tickRate > 0 {
+		c.serverTickRate = int(tickRate)
+	}
+	c.timeSyncPending = false
+	if c.reconnecting {
+		// 重连时沿用之前的校时结果， 房间状态由随后的追帧数据决定
+		c.logger().Info("Reconnected to room")
+		return nil
+	}
+	c.alreadyTimeSyncTimes = 0
+	c.clock.Reset()
+	c.inputLeadFrames = 0
+	c.logger().Info("Enter room",
+		"max_players", enterRoom.MaxPlayers(),
+		"heartbeat_interval", c.heartbeatInterval,
+		"time_sync_times", c.timeSyncedTimes)
+	c.gameState = Room
+	if c.nickname != "" {
+		sendPlayerInfo(c.conn, c.nickname)
+	}
+	return nil
+}
+
+func (c *GameClient) handleStartEnterGame(startEnterGame gametypes.StartEnterGame) error {
+	// 本局已经创建了世界， 是重复的消息
+	if c.world != nil {
+		c.logger().Debug("Ignoring duplicate start enter game")
+		return nil
+	}
+	c.logger().Info("Start enter game", "players", startEnterGame.Players)
+
+	// 创建客户端本地角色
+	c.world = simulation.NewWorld(c.gameMap)
+	for _, player := range startEnterGame.Players {
+		// 检查是否已经存在， 存在的话存在逻辑错误， 抛出错误
+		if _, ok := c.players[player.ID]; ok {
+			return fmt.Errorf("Player %d already exists, current players: %v", player.ID, c.players)
+		}
+		c.players[player.ID] = &Player{
+			ID:       player.ID,
+			Position: player.Position,
+		}
+		c.world.AddPlayer(player.ID, player.Position)
+
+		// 通知UI更新玩家
+		if c.onPlayerUpdate != nil {
+			c.onPlayerUpdate(c.players[player.ID])
+		}
+	}
+
+	c.resetPrediction()
+
+	// 播放录像与观战时不参与加载， 直接进入游戏
+	if c.replaying || c.spectating {
+		c.gameState = Game
+		return nil
+	}
+
+	// 模拟加载， 随机延迟后在tick中发送消息
+	c.gameLoadedAt = c.timeSource.Now().Add(time.Duration(0.5+float64(rand.IntN(2))) * time.Second)
+	return nil
+}
+
+func (c *GameClient) handleStartGame(startGame *fb.S2CStartGame) error {
+	if c.gameState == GameCountDown || c.gameState == Game {
+		c.logger().Debug("Ignoring duplicate start game")
+		return nil
+	}
+	c.gameStartServerTime = startGame.AppointedServerTime()
+	c.lastInputFrame = -1
+	c.logger().Info("Game starts", "server_time", c.gameStartServerTime, "local_time", c.clock.LocalTime(c.gameStartServerTime).Format("15:04:05.000"))
+	c.gameState = GameCountDown
+	return nil
+}
+
+func (c *GameClient) handleCatchUp(catchUp gametypes.CatchUp) error {
+	// 追帧数据只在重连或开始观战时发送一次， 已经执行到该帧之后再收到的是重复的消息
+	if !c.reconnecting && c.world != nil && catchUp.SnapshotFrame <= c.lastSyncFrame {
+		c.logger().Debug("Ignoring duplicate catch up", "snapshot_frame", catchUp.SnapshotFrame)
+		return nil
+	}
+	c.applyCatchUp(catchUp)
+	return nil
+}
+
+func (c *GameClient) handleWorldSync(worldSync gametypes.WorldSync) error {
+	// world sync带有服务器的发送时间， 作为单向样本持续校时
+	// 观战者收到的是延迟转发的消息， 不能用于校时
+	// 重复的world sync不能再执行一次
+	if int(worldSync.LogicFrame) <= c.lastSyncFrame {
+		c.logger().Debug("Ignoring duplicate world sync", logging.KeyLogicFrame, worldSync.LogicFrame)
+		return nil
+	}
+	c.lastSyncFrame = int(worldSync.LogicFrame)
+	if !c.replaying && !c.spectating {
+		c.clock.AddOneWay(c.timeSource.Now(), worldSync.ServerTime)
+	}
+	c.frameSyncs = append(c.frameSyncs, frameSync{
+		logicFrame: int(worldSync.LogicFrame),
+		inputs:     c.receivedInputs,
+	})
+	c.receivedInputs = nil
+	return nil
+}
+
+func (c *GameClient) handleResponseTime(responseTime *fb.S2CResponseTime) error {
+	// 超时或重连之后才到达的回复无法对应发送时间， 丢弃
+	if !c.timeSyncPending {
+		c.logger().Debug("Ignoring late time sync response")
+		return nil
+	}
+	c.alreadyTimeSyncTimes++
+	c.timeSyncPending = false
+	received := c.timeSource.Now()
+	serverTime := responseTime.ServerTime()
+	accepted := c.clock.AddRoundTrip(c.lastSendSyncTime, received, serverTime)
+
+	status := c.clock.Status()
+	c.logger().Debug("Response time", "rtt", received.Sub(c.lastSendSyncTime), "server_time", serverTime, "accepted", accepted)
+	c.logger().Info("Clock status",
+		"offset", status.Offset,
+		"drift_ppm", status.Drift,
+		"min_rtt", status.RTT,
+		"jitter", status.Jitter,
+		"confidence", status.Confidence)
+	return nil
+}
+
+func (c *GameClient) handleLatencyProbe(probe *fb.S2CLatencyProbe) error {
+	sendLatencyProbe(c.conn, probe.Id())
+	return nil
+}
+
+func (c *GameClient) handleInputDelay(inputDelay *fb.S2CInputDelay) error {
+	c.inputLeadFrames = int(inputDelay.InputDelay())
+	c.serverRTT = time.Duration(inputDelay.Rtt()) * time.Millisecond
+	c.logger().Info("Input delay", "input_lead", c.inputLeadFrames, "lead_time", c.inputLead(), "server_rtt", c.serverRTT)
+	return nil
+}
+
+func (c *GameClient) handlePlayerInputSync(playerInput gametypes.PlayerInput) error {
+	// 打印收到的输入
+	c.logger().Debug("Player input sync", "input_player_id", playerInput.ID, logging.KeyLogicFrame, playerInput.LogicFrame)
+	if c.duplicateInput(playerInput) {
+		c.logger().Debug("Ignoring duplicate input", "input_player_id", playerInput.ID, logging.KeyLogicFrame, playerInput.LogicFrame)
+		return nil
+	}
+	if playerInput.Substituted && playerInput.ID == c.playerID {
+		// 本地输入没有在截止时间前到达服务端， 已执行的world sync会在下一次tick中一并追上
+		c.logger().Warn("Local input timed out, server substituted an empty input", logging.KeyLogicFrame, playerInput.LogicFrame)
+		c.sendInputNow = true
+		// 服务端不再接受帧号不大于空输入的输入， 补发的输入使用之后的帧号
+		c.lastInputFrame = max(c.lastInputFrame, playerInput.LogicFrame)
+	}
+	if playerInput.ID == c.playerID && !playerInput.Substituted {
+		c.confirmLocalInput(playerInput)
+	}
+	c.receivedInputs = append(c.receivedInputs, playerInput)
+	c.inputConfirmed = true
+	c.predictionDirty = true
+	return nil
+}
+
+// duplicateInput 服务端按帧号递增的顺序转发每个玩家的输入， 帧号不大于该玩家上一次转发的输入是重复的消息
+func (c *GameClient) duplicateInput(playerInput gametypes.PlayerInput) bool {
+	if last, ok := c.relayedFrames[playerInput.ID]; ok && playerInput.LogicFrame <= last {
+		return true
+	}
+	c.relayedFrames[playerInput.ID] = playerInput.LogicFrame
+	return false
+}
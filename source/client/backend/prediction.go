@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"gameproject/source/gametypes"
+	"gameproject/source/logging"
+	"gameproject/source/simulation"
+)
+
+// 保存的已确认状态数， 每个world sync保存一个
+const stateHistorySize = 32
+
+// resetPrediction 重建已确认的世界后（进入游戏、追帧）丢弃之前的预测
+func (c *GameClient) resetPrediction() {
+	c.stateHistory = simulation.NewStateBuffer(stateHistorySize)
+	c.stateHistory.Save(c.world.SaveState())
+	c.predicted = nil
+	c.pendingInputs = nil
+	c.predictionDirty = true
+}
+
+// confirmState 保存执行完一个world sync之后的权威状态， 预测从这里重新开始
+func (c *GameClient) confirmState() {
+	c.stateHistory.Save(c.world.SaveState())
+	c.predictionDirty = true
+}
+
+// predict 回滚到最近的已确认状态， 依次重新执行服务端已转发但尚未执行的输入、
+// 已发送但尚未被转发的本地输入以及本窗口还未发送的输入
+// 服务端转发的输入与预测不一致时， 重新模拟的结果会覆盖之前的预测
+func (c *GameClient) predict() {
+	if !c.predictionDirty || c.world == nil || c.replaying || c.spectating {
+		return
+	}
+	c.predictionDirty = false
+
+	state, ok := c.stateHistory.Latest(c.logicFrame)
+	if !ok {
+		state = c.world.SaveState()
+	}
+	previous := c.predicted
+	c.predicted = simulation.NewWorld(c.gameMap)
+	c.predicted.RestoreState(state)
+
+	// 执行顺序与服务端相同： 先执行已转发的输入， 本地输入在它们之后到达服务端
+	for _, input := range c.syncInputQueue {
+		c.predicted.ApplyInput(input)
+	}
+	for _, input := range c.receivedInputs {
+		c.predicted.ApplyInput(input)
+	}
+	for _, input := range c.pendingInputs {
+		c.predicted.ApplyInput(input)
+	}
+	// 新的本地输入基于已发送的输入执行后的位置， 本窗口内的输入只保留最后一个
+	c.predictedOrigin, _ = c.predicted.Player(c.playerID)
+	if c.lastPlayInput != nil {
+		c.predicted.ApplyInput(*c.lastPlayInput)
+	}
+
+	if previous != nil && c.inputConfirmed && previous.Checksum() != c.predicted.Checksum() {
+		c.rollbacks++
+		c.logger().Debug("Prediction corrected by server inputs", logging.KeyLogicFrame, c.logicFrame, "rollbacks", c.rollbacks)
+	}
+	c.inputConfirmed = false
+}
+
+// confirmLocalInput 本地输入已被服务端转发， 之后作为已转发的输入执行
+func (c *GameClient) confirmLocalInput(input gametypes.PlayerInput) {
+	for i, pending := range c.pendingInputs {
+		if pending.LogicFrame == input.LogicFrame {
+			c.pendingInputs = append(c.pendingInputs[:i], c.pendingInputs[i+1:]...)
+			return
+		}
+	}
+}
+
+// rejectLocalInput 服务端按顺序处理输入， 被拒绝的是最早发送的本地输入
+func (c *GameClient) rejectLocalInput() {
+	if len(c.pendingInputs) == 0 {
+		return
+	}
+	c.pendingInputs = c.pendingInputs[1:]
+	c.inputConfirmed = true
+	c.predictionDirty = true
+}
+
+// currentWorld 有预测时返回预测的世界， 否则返回已确认的世界
+func (c *GameClient) currentWorld() *simulation.World {
+	if c.predicted != nil {
+		return c.predicted
+	}
+	return c.world
+}
+
+// Rollbacks 返回服务端的输入与本地预测不一致、重新模拟后状态发生变化的次数
+func (c *GameClient) Rollbacks() int {
+	return c.rollbacks
+}
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"fmt"
+	"gameproject/fb"
+	"gameproject/source/logging"
+	"gameproject/source/replay"
+	"io"
+	"time"
+)
+
+// PlayReplay 按录制时的节奏把录像中的消息交给客户端处理， speed 为播放倍速， 小于等于0时不等待
+// 每个world sync执行后与录像中服务端的状态哈希比较， 返回第一个不一致的逻辑帧， 用于离线复现不同步
+func (c *GameClient) PlayReplay(path string, speed float64) error {
+	reader, err := replay.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	c.logger().Info("Playing replay", "path", path, "version", reader.Version(), "recorded_at", reader.StartTime().Format(time.DateTime), "speed", speed)
+	c.replaying = true
+	c.replayChecksums = make(map[int]uint64)
+	c.replayErr = nil
+	defer func() {
+		c.replaying = false
+	}()
+
+	start := time.Now()
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if speed > 0 {
+			if wait := time.Duration(float64(record.Elapsed)/speed) - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		switch record.Kind {
+		case replay.RecordMessage:
+			if err := c.handleMessage(fb.GetRootAsS2CCommand(record.Data, 0)); err != nil {
+				return err
+			}
+		case replay.RecordChecksum:
+			logicFrame, hash, err := replay.DecodeChecksum(record.Data)
+			if err != nil {
+				return err
+			}
+			c.replayChecksums[logicFrame] = hash
+		default:
+			c.logger().Warn("Unknown replay record kind", "kind", record.Kind)
+		}
+
+		// 立即执行收到的world sync
+		c.tick(time.Now())
+	}
+
+	c.logger().Info("Replay finished", logging.KeyLogicFrame, c.logicFrame)
+	return c.replayErr
+}
+
+// checkReplayChecksum 比较本地状态与录像中服务端在同一逻辑帧的哈希
+func (c *GameClient) checkReplayChecksum() {
+	expected, ok := c.replayChecksums[c.logicFrame]
+	if !ok {
+		return
+	}
+	delete(c.replayChecksums, c.logicFrame)
+
+	if hash := c.world.Checksum(); hash != expected {
+		c.logger().Error("Replay desync",
+			logging.KeyLogicFrame, c.logicFrame,
+			"server_hash", fmt.Sprintf("%x", expected),
+			"local_hash", fmt.Sprintf("%x", hash),
+			"state_dump", c.world.Dump())
+		if c.replayErr == nil {
+			c.replayErr = fmt.Errorf("replay desync at logic frame %d", c.logicFrame)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package bot 无界面客户端的行为脚本
+//
+// 脚本每行一条指令， # 之后为注释：
+//
+//	move dx dy   记录一次移动输入
+//	random       随机向上下左右移动一格
+//	wait 2s      等待一段时间后再执行下一条指令
+//	repeat       回到第一条指令重新执行
+//
+// 客户端每个输入发送间隔只发送最后一次移动， 两次移动之间应等待至少一个发送间隔。
+package bot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 内置脚本， 可以直接通过名字使用
+var builtinScripts = map[string]string{
+	"idle": "",
+	"random": `
+random
+wait 2s
+repeat
+`,
+	"patrol": `
+move 1 0
+wait 2s
+move -1 0
+wait 2s
+repeat
+`,
+}
+
+type opcode int
+
+const (
+	opMove opcode = iota
+	opRandom
+	opWait
+	opRepeat
+)
+
+type instruction struct {
+	op     opcode
+	dx, dy int
+	wait   time.Duration
+}
+
+// Script 解析后的行为脚本
+type Script struct {
+	name         string
+	instructions []instruction
+}
+
+// Load 按名字加载内置脚本， 不是内置脚本时作为文件路径读取
+func Load(nameOrPath string) (*Script, error) {
+	if source, ok := builtinScripts[nameOrPath]; ok {
+		return Parse(nameOrPath, strings.NewReader(source))
+	}
+	file, err := os.Open(nameOrPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(nameOrPath, file)
+}
+
+// Parse 解析脚本， 出错时返回带行号的错误
+func Parse(name string, r io.Reader) (*Script, error) {
+	script := &Script{name: name}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		inst, err := parseInstruction(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		script.instructions = append(script.instructions, inst)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+func parseInstruction(fields []string) (instruction, error) {
+	args := fields[1:]
+	switch fields[0] {
+	case "move":
+		if len(args) != 2 {
+			return instruction{}, fmt.Errorf("usage: move dx dy")
+		}
+		dx, err := strconv.Atoi(args[0])
+		if err != nil {
+			return instruction{}, fmt.Errorf("invalid dx %q", args[0])
+		}
+		dy, err := strconv.Atoi(args[1])
+		if err != nil {
+			return instruction{}, fmt.Errorf("invalid dy %q", args[1])
+		}
+		if dx == 0 && dy == 0 {
+			return instruction{}, fmt.Errorf("move must not be zero")
+		}
+		return instruction{op: opMove, dx: dx, dy: dy}, nil
+	case "random":
+		if len(args) != 0 {
+			return instruction{}, fmt.Errorf("usage: random")
+		}
+		return instruction{op: opRandom}, nil
+	case "wait":
+		if len(args) != 1 {
+			return instruction{}, fmt.Errorf("usage: wait <duration>")
+		}
+		wait, err := time.ParseDuration(args[0])
+		if err != nil || wait < 0 {
+			return instruction{}, fmt.Errorf("invalid duration %q", args[0])
+		}
+		return instruction{op: opWait, wait: wait}, nil
+	case "repeat":
+		if len(args) != 0 {
+			return instruction{}, fmt.Errorf("usage: repeat")
+		}
+		return instruction{op: opRepeat}, nil
+	default:
+		return instruction{}, fmt.Errorf("unknown instruction %q", fields[0])
+	}
+}
+
+func (s *Script) Name() string {
+	return s.name
+}
+
+// Mover 接收脚本产生的移动， 由 backend.GameClient 实现
+type Mover interface {
+	SendMovement(dx, dy int) error
+}
+
+// Runner 按时间执行脚本， 每个客户端使用一个Runner
+type Runner struct {
+	script    *Script
+	pc        int
+	waitUntil time.Time
+	done      bool
+}
+
+func NewRunner(script *Script) *Runner {
+	return &Runner{script: script}
+}
+
+// Done 脚本已执行完最后一条指令
+func (r *Runner) Done() bool {
+	return r.done
+}
+
+// Tick 执行到下一条wait指令为止， 在客户端的游戏tick中调用
+func (r *Runner) Tick(now time.Time, mover Mover) {
+	if r.done || now.Before(r.waitUntil) {
+		return
+	}
+
+	// 每个tick最多执行一遍脚本， 没有wait的repeat不会卡住客户端
+	for steps := 0; steps < len(r.script.instructions); steps++ {
+		if r.pc >= len(r.script.instructions) {
+			r.done = true
+			return
+		}
+		inst := r.script.instructions[r.pc]
+		r.pc++
+
+		switch inst.op {
+		// 游戏尚未开始等情况下移动会被拒绝， 直接跳过
+		case opMove:
+			mover.SendMovement(inst.dx, inst.dy)
+		case opRandom:
+			directions := [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+			direction := directions[rand.IntN(len(directions))]
+			mover.SendMovement(direction[0], direction[1])
+		case opWait:
+			r.waitUntil = now.Add(inst.wait)
+			return
+		case opRepeat:
+			r.pc = 0
+		}
+	}
+	if r.pc >= len(r.script.instructions) {
+		r.done = true
+	}
+}
@@ -2,7 +2,8 @@ package gui
 
 import (
 	"gameproject/source/client/backend"
-	"log"
+	"gameproject/source/logging"
+	"log/slog"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -62,7 +63,7 @@ func NewGameWindow() *GameWindow {
 	startBtn := widget.NewButton("Start Game", func() {
 		if gw.onConnect != nil {
 			if err := gw.onConnect(); err != nil {
-				log.Printf("Failed to connect: %v", err)
+				slog.Error("Failed to connect", logging.Err(err))
 				return
 			}
 			if gw.onStart != nil {
@@ -211,16 +212,24 @@ func (gw *GameWindow) GetNickname() string {
 	return gw.nickname.Text
 }
 
+func (gw *GameWindow) GetServerIP() string {
+	return gw.ip.Text
+}
+
 func (gw *GameWindow) BindLocalPlayer(localID int) {
 	gw.gameMap.LocalID = localID
 }
 
 func (gw *GameWindow) UpdatePlayers(player *backend.Player) {
+	// 游戏坐标以地图中心为原点、Y轴向上， 转换为从左上角开始的行列
+	x := player.Position.X + gw.gameMap.Width/2
+	y := gw.gameMap.Height - 1 - (player.Position.Y + gw.gameMap.Height/2)
+
 	// 检查gw.gameMap.Players中是否已经存在该玩家
 	if _, ok := gw.gameMap.Players[player.ID]; !ok {
-		gw.gameMap.Players[player.ID] = NewGUIPlayer(player.ID, player.Position.X, player.Position.Y)
+		gw.gameMap.Players[player.ID] = NewGUIPlayer(player.ID, x, y)
 	} else {
-		gw.gameMap.Players[player.ID].MoveTo(player.Position.X, player.Position.Y, gw.gameMap.Width, gw.gameMap.Height)
+		gw.gameMap.Players[player.ID].MoveTo(x, y, gw.gameMap.Width, gw.gameMap.Height)
 	}
 
 	gw.updateMap()
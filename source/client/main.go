@@ -1,14 +1,77 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"gameproject/source/client/backend"
+	"gameproject/source/client/bot"
 	"gameproject/source/client/gui"
+	"gameproject/source/clock"
+	"gameproject/source/logging"
+	"gameproject/source/netsim"
+	"gameproject/source/transport"
 )
 
 func main() {
+	replayPath := flag.String("replay", "", "播放录像文件， 不连接服务器")
+	replaySpeed := flag.Float64("speed", 1, "录像播放倍速， 0表示不等待")
+	headless := flag.Bool("headless", false, "不启动GUI， 由行为脚本控制客户端")
+	serverAddr := flag.String("server", backend.DefaultServerAddress, "服务器地址， 省略端口时使用默认端口")
+	transportName := flag.String("transport", transport.KCP, "传输方式： kcp、tcp 或 websocket， 需要与服务器相同")
+	nickname := flag.String("nickname", "bot", "无界面模式下的昵称， 多个客户端时自动加上序号")
+	scriptName := flag.String("script", "random", "行为脚本： idle、random、patrol 或脚本文件路径")
+	clients := flag.Int("clients", 1, "无界面模式下启动的客户端数量")
+	duration := flag.Duration("duration", 0, "无界面模式下运行多久后退出， 0表示一直运行")
+	spectate := flag.Int("spectate", -1, "进入大厅后观战指定房间而不是匹配， 0表示任意一个房间")
+	logLevel := flag.String("log-level", "info", "日志级别： debug、info、warn、error")
+	logFormat := flag.String("log-format", "", "日志格式： text 或 json， 默认无界面模式使用json， 否则使用text")
+	logFile := flag.String("log-file", "", "同时写入的日志文件（JSON）")
+	netsimSpec := flag.String("netsim", "", "模拟的网络条件： 内置场景名、场景文件路径或 \"latency=100ms loss=0.01\" 这样的条件")
+	netsimSeed := flag.Uint64("netsim-seed", 0, "网络模拟的随机种子， 0表示随机选择")
+	flag.Parse()
+
+	logOptions := logging.Options{Level: *logLevel, Format: *logFormat, File: *logFile, MaxSizeMB: 100, MaxBackups: 5}
+	if *headless && logOptions.Format == "" {
+		logOptions.Format = logging.FormatJSON
+	}
+	logCloser, err := logging.Setup(logOptions)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer logCloser.Close()
+
+	clientTransport, err := transport.New(*transportName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *netsimSpec != "" {
+		clientTransport, err = simulateNetwork(clientTransport, *netsimSpec, *netsimSeed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if *headless {
+		if err := runHeadless(clientTransport, serverAddress(*serverAddr), *nickname, *scriptName, *clients, *duration, *spectate); err != nil {
+			slog.Error("Headless clients failed", logging.Err(err))
+			logCloser.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Println("\n程序发生错误,按回车键退出...")
@@ -19,11 +82,18 @@ func main() {
 	var client *backend.GameClient
 	mainWindow := gui.NewGameWindow()
 
+	if *replayPath != "" {
+		playReplay(mainWindow, *replayPath, *replaySpeed)
+		return
+	}
+
 	// Set up callbacks
 	mainWindow.SetCallbacks(
 		// Connect callback
 		func() error {
 			client = backend.NewGameClient()
+			client.SetTransport(clientTransport)
+			client.SetNickname(mainWindow.GetNickname())
 			client.SetOnPlayersUpdate(func(player *backend.Player) {
 				mainWindow.UpdatePlayers(player)
 			})
@@ -31,15 +101,25 @@ func main() {
 			client.SetBindLocalPlayer(func(localID int) {
 				mainWindow.BindLocalPlayer(localID)
 			})
-			if err := client.Connect(); err != nil {
+
+			client.SetOnEnterLobby(func() {
+				enterGame(client, *spectate)
+			})
+			if err := client.Connect(serverAddress(mainWindow.GetServerIP())); err != nil {
 				return err
 			}
-			log.Println("Connected to server")
+			slog.Info("Connected to server")
 			return nil
 		},
 		// Start callback
 		func() {
-			go client.Start()
+			go func() {
+				if err := client.Start(); err != nil {
+					slog.Error("Client stopped", logging.Err(err))
+					fmt.Println("\n程序发生错误, 按回车键退出...")
+					fmt.Scanln()
+				}
+			}()
 		},
 		// Movement callback
 		func(dx, dy int) {
@@ -51,3 +131,129 @@ func main() {
 
 	mainWindow.Show()
 }
+
+// simulateNetwork 按场景模拟网络条件， 日志中记录随机种子， 用同样的种子可以复现
+func simulateNetwork(inner transport.Transport, spec string, seed uint64) (transport.Transport, error) {
+	scenario, err := netsim.Load(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load network scenario: %w", err)
+	}
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	slog.Info("Simulating network conditions", "scenario", scenario.Name(), "seed", seed)
+	return netsim.Wrap(inner, scenario, clock.Real, seed), nil
+}
+
+// serverAddress 没有指定端口时使用默认端口
+func serverAddress(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	_, port, _ := net.SplitHostPort(backend.DefaultServerAddress)
+	return net.JoinHostPort(addr, port)
+}
+
+// enterGame 进入大厅后直接开始匹配， spectate 不小于0时改为观战该房间
+func enterGame(client *backend.GameClient, spectate int) {
+	if spectate >= 0 {
+		client.Spectate(spectate)
+		return
+	}
+	client.QuickMatch(0, 0)
+}
+
+// runHeadless 启动若干个由脚本控制的客户端， 任意一个客户端出错时返回错误
+// 收到 SIGINT / SIGTERM 或运行时间到达 duration 后停止所有客户端
+func runHeadless(clientTransport transport.Transport, addr, nickname, scriptName string, count int, duration time.Duration, spectate int) error {
+	script, err := bot.Load(scriptName)
+	if err != nil {
+		return fmt.Errorf("failed to load script: %w", err)
+	}
+
+	clients := make([]*backend.GameClient, 0, count)
+	errs := make(chan error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		client := backend.NewGameClient()
+		client.SetTransport(clientTransport)
+		name := nickname
+		if count > 1 {
+			name = fmt.Sprintf("%s%d", nickname, i+1)
+		}
+		client.SetNickname(name)
+
+		runner := bot.NewRunner(script)
+		client.SetOnGameTick(func(tickTime time.Time) {
+			runner.Tick(tickTime, client)
+		})
+		client.SetOnEnterLobby(func() {
+			enterGame(client, spectate)
+		})
+
+		if err := client.Connect(addr); err != nil {
+			for _, started := range clients {
+				started.Stop()
+			}
+			wg.Wait()
+			return fmt.Errorf("client %d failed to connect to %s: %w", i+1, addr, err)
+		}
+		clients = append(clients, client)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Start(); err != nil {
+				errs <- fmt.Errorf("client %d (player %d): %w", i+1, client.PlayerID(), err)
+			}
+		}()
+	}
+	slog.Info("Started headless clients", "clients", count, logging.KeyAddr, addr, "transport", clientTransport.Name(), "script", script.Name())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timeout = time.After(duration)
+	}
+
+	select {
+	case err = <-errs:
+	case sig := <-signals:
+		slog.Info("Received signal", "signal", sig.String())
+	case <-timeout:
+		slog.Info("Finished", "duration", duration)
+	}
+
+	for _, client := range clients {
+		client.Stop()
+	}
+	wg.Wait()
+	return err
+}
+
+// playReplay 点击开始后播放录像
+func playReplay(mainWindow *gui.GameWindow, path string, speed float64) {
+	client := backend.NewGameClient()
+	client.SetOnPlayersUpdate(func(player *backend.Player) {
+		mainWindow.UpdatePlayers(player)
+	})
+
+	mainWindow.SetCallbacks(
+		func() error {
+			return nil
+		},
+		func() {
+			go func() {
+				if err := client.PlayReplay(path, speed); err != nil {
+					slog.Error("Replay failed", logging.Err(err))
+					return
+				}
+				slog.Info("Replay finished")
+			}()
+		},
+		nil,
+	)
+
+	mainWindow.Show()
+}
@@ -0,0 +1,56 @@
+// Package clock 服务器与客户端读取时间、创建定时器的接口
+//
+// 游戏逻辑中的时间都通过 Clock 获取， 测试中使用 Fake 控制时间的流逝， 不依赖真实时间。
+// 网络连接的超时、重连的等待等与对端无关的时间仍然使用真实时间。
+package clock
+
+import "time"
+
+// Clock 时间来源， 可以在多个goroutine中使用
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTicker(d time.Duration) Ticker
+	Sleep(d time.Duration)
+	// After 经过 d 后向返回的channel发送一次当前时间， 不需要接收
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker 周期性地发送当前时间， 与 time.Ticker 相同
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real 使用系统时间
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,159 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake 只在调用 Advance 时前进的时钟， 用于测试
+//
+// 与真实的Ticker不同， Fake 的Ticker不会丢弃时间： Advance 按时间顺序逐个发送， 每次都等待接收方取走，
+// 所以 Advance 返回时所有到期的Tick都已经被接收， 下一次接收之前上一个Tick的处理已经完成。
+// 因此拥有Ticker的goroutine不能在 Fake 上 Sleep， 否则 Advance 会一直等待。
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	seq     int // 同一时间到期的定时器按创建顺序触发
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter 一个Ticker或一次Sleep
+type fakeWaiter struct {
+	at     time.Time
+	seq    int
+	period time.Duration // 为0时是Sleep或After， 触发一次后移除
+	c      chan time.Time
+	stop   chan struct{}
+}
+
+// NewFake 创建从 start 开始的时钟
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := f.addLocked(f.now.Add(d), d)
+	return &fakeTicker{clock: f, waiter: w}
+}
+
+// Sleep 等待时钟前进 d， d 不大于0时立即返回
+func (f *Fake) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-f.After(d)
+}
+
+// After 的channel带缓冲， 没有被接收也不会阻塞 Advance， d 不大于0时立即发送
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d <= 0 {
+		c := make(chan time.Time, 1)
+		c <- f.now
+		return c
+	}
+	return f.addLocked(f.now.Add(d), 0).c
+}
+
+func (f *Fake) addLocked(at time.Time, period time.Duration) *fakeWaiter {
+	f.seq++
+	w := &fakeWaiter{
+		at:     at,
+		seq:    f.seq,
+		period: period,
+		c:      make(chan time.Time, 1),
+		stop:   make(chan struct{}),
+	}
+	if period > 0 {
+		// Ticker的channel不带缓冲， 发送成功即表示接收方已经取走
+		w.c = make(chan time.Time)
+	}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+func (f *Fake) removeLocked(w *fakeWaiter) {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance 使时钟前进 d， 按时间顺序触发期间到期的Ticker与Sleep， 不能并发调用
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+	for {
+		w := f.nextLocked(target)
+		if w == nil {
+			break
+		}
+		f.now = w.at
+		now := f.now
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			f.removeLocked(w)
+		}
+		f.mu.Unlock()
+		// 不持有锁， 接收方在处理时可以调用 Now
+		select {
+		case w.c <- now:
+		case <-w.stop:
+		}
+		f.mu.Lock()
+	}
+	f.now = target
+	f.mu.Unlock()
+}
+
+// nextLocked 返回最早到期且不晚于 target 的定时器
+func (f *Fake) nextLocked(target time.Time) *fakeWaiter {
+	var next *fakeWaiter
+	for _, w := range f.waiters {
+		if w.at.After(target) {
+			continue
+		}
+		if next == nil || w.at.Before(next.at) || (w.at.Equal(next.at) && w.seq < next.seq) {
+			next = w
+		}
+	}
+	return next
+}
+
+type fakeTicker struct {
+	clock    *Fake
+	waiter   *fakeWaiter
+	stopOnce sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.stopOnce.Do(func() {
+		t.clock.mu.Lock()
+		t.clock.removeLocked(t.waiter)
+		t.clock.mu.Unlock()
+		close(t.waiter.stop)
+	})
+}
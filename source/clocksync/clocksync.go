@@ -0,0 +1,263 @@
+// Package clocksync 估计本地时钟与服务器时钟的偏差
+//
+// 与NTP相同， 一次往返测量得到 offset = server_time - (t0+t1)/2， 误差不超过 RTT/2，
+// 因此在最近的样本中选取RTT最小的样本作为当前的偏差， 并剔除与估计值不一致的异常样本。
+// 游戏中服务端的world sync带有发送时的服务器时间， 按最小RTT的一半估计单程延迟后作为单向样本，
+// 保证整局游戏中持续校时， 偏差随时间的变化（漂移）由选取的样本线性拟合得到。
+package clocksync
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWindow = 16 // 参与选取的最近样本数
+
+	historySize      = 64               // 用于拟合漂移的历史估计数
+	minDriftSpan     = 10 * time.Second // 历史估计跨越的时间不足时不计算漂移
+	minOutlierSpread = 5 * time.Millisecond
+	outlierJitters   = 3 // 偏离估计值超过该倍数的抖动（以及样本自身的误差范围）时视为异常样本
+	maxRejections    = 4 // 连续的异常样本说明时钟发生了跳变， 丢弃之前的样本重新估计
+
+	confidenceSamples   = 4                     // 样本数达到该值后置信度不再受样本数限制
+	confidenceReference = 20 * time.Millisecond // 误差范围为该值时置信度为0.5
+)
+
+// Sample 一次测量的结果
+type Sample struct {
+	Offset time.Duration // 服务器时间减去本地时间
+	RTT    time.Duration // 往返时间， 单向样本为按延迟估计的值
+	At     time.Time     // 测量时的本地时间
+	OneWay bool
+}
+
+// Status 当前的估计结果
+type Status struct {
+	Offset      time.Duration // 服务器时间减去本地时间
+	Drift       float64       // 偏差每秒的变化量， 单位ppm（百万分之一）
+	RTT         time.Duration // 窗口中最小的往返时间
+	Jitter      time.Duration // 样本相对估计值的均方根偏差
+	Uncertainty time.Duration // 估计的误差范围， RTT/2 加上抖动
+	Confidence  float64       // 0~1， 没有样本时为0
+	Samples     int
+	Rejected    int // 累计剔除的异常样本数
+}
+
+type point struct {
+	at     time.Time
+	offset time.Duration
+}
+
+// Estimator 时钟偏差估计器， 可以在多个goroutine中使用，
+// 例如接收消息的goroutine添加样本、主循环换算时间
+type Estimator struct {
+	mu       sync.Mutex
+	window   int
+	samples  []Sample // 按测量时间排序
+	selected Sample   // 窗口中RTT最小的样本
+	history  []point
+	drift    float64 // 秒/秒
+	jitter   time.Duration
+
+	rejections int // 连续的异常样本数
+	rejected   int
+}
+
+func NewEstimator(window int) *Estimator {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Estimator{window: window}
+}
+
+// Reset 丢弃所有样本， 例如进入新的房间时
+func (e *Estimator) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reset()
+}
+
+func (e *Estimator) reset() {
+	e.samples = nil
+	e.selected = Sample{}
+	e.history = nil
+	e.drift = 0
+	e.jitter = 0
+	e.rejections = 0
+}
+
+// AddRoundTrip 添加一次往返测量， t0 为发送请求的本地时间， t1 为收到回复的本地时间，
+// serverTime 为服务器回复时的Unix毫秒时间， 样本被视为异常而剔除时返回false
+func (e *Estimator) AddRoundTrip(t0, t1 time.Time, serverTime int64) bool {
+	rtt := t1.Sub(t0)
+	if rtt < 0 {
+		return false
+	}
+	mid := t0.Add(rtt / 2)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.add(Sample{
+		Offset: unixMilli(serverTime) - unixNano(mid),
+		RTT:    rtt,
+		At:     mid,
+	})
+}
+
+// AddOneWay 添加服务器单向发送的时间， received 为收到消息的本地时间
+// 单程延迟按最小RTT的一半估计， 没有往返样本时无法估计延迟， 返回false
+func (e *Estimator) AddOneWay(received time.Time, serverTime int64) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.samples) == 0 {
+		return false
+	}
+	minRTT := e.selected.RTT
+	observed := unixMilli(serverTime) - unixNano(received) // 偏差减去实际的单程延迟
+	// 排队等原因多出的延迟计入该样本的RTT， 选取时优先使用延迟小的样本
+	rtt := 2 * (e.offsetAt(received) - observed)
+	if rtt < minRTT {
+		rtt = minRTT
+	}
+	return e.add(Sample{
+		Offset: observed + minRTT/2,
+		RTT:    rtt,
+		At:     received,
+		OneWay: true,
+	})
+}
+
+func (e *Estimator) add(sample Sample) bool {
+	if len(e.samples) >= confidenceSamples && !e.consistent(sample) {
+		e.rejected++
+		e.rejections++
+		if e.rejections < maxRejections {
+			return false
+		}
+		// 连续的样本都与估计值不一致， 认为本地或服务器的时钟被调整过
+		e.reset()
+	}
+	e.rejections = 0
+
+	e.samples = append(e.samples, sample)
+	if len(e.samples) > e.window {
+		e.samples = e.samples[len(e.samples)-e.window:]
+	}
+	e.update()
+	return true
+}
+
+// consistent 样本与当前估计值的差距是否在双方的误差范围之内
+func (e *Estimator) consistent(sample Sample) bool {
+	spread := time.Duration(outlierJitters) * e.jitter
+	if spread < minOutlierSpread {
+		spread = minOutlierSpread
+	}
+	limit := (sample.RTT+e.selected.RTT)/2 + spread
+	diff := sample.Offset - e.offsetAt(sample.At)
+	return diff <= limit && diff >= -limit
+}
+
+func (e *Estimator) update() {
+	selected := e.samples[0]
+	for _, sample := range e.samples[1:] {
+		// RTT相同时使用较新的样本
+		if sample.RTT <= selected.RTT {
+			selected = sample
+		}
+	}
+	if !selected.At.Equal(e.selected.At) || len(e.history) == 0 {
+		e.history = append(e.history, point{at: selected.At, offset: selected.Offset})
+		if len(e.history) > historySize {
+			e.history = e.history[len(e.history)-historySize:]
+		}
+	}
+	e.selected = selected
+	e.drift = fitDrift(e.history)
+
+	var sum float64
+	for _, sample := range e.samples {
+		diff := float64(sample.Offset - e.offsetAt(sample.At))
+		sum += diff * diff
+	}
+	e.jitter = time.Duration(math.Sqrt(sum / float64(len(e.samples))))
+}
+
+// fitDrift 以最小二乘法拟合偏差随时间的变化率
+func fitDrift(history []point) float64 {
+	if len(history) < 3 || history[len(history)-1].at.Sub(history[0].at) < minDriftSpan {
+		return 0
+	}
+	base := history[0]
+	var sumX, sumY, sumXX, sumXY float64
+	for _, p := range history {
+		x := p.at.Sub(base.at).Seconds()
+		y := (p.offset - base.offset).Seconds()
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	n := float64(len(history))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+// OffsetAt 估计指定本地时间的偏差， 按漂移从选取的样本外推
+func (e *Estimator) OffsetAt(local time.Time) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.offsetAt(local)
+}
+
+func (e *Estimator) offsetAt(local time.Time) time.Duration {
+	if len(e.samples) == 0 {
+		return 0
+	}
+	elapsed := local.Sub(e.selected.At).Seconds()
+	return e.selected.Offset + time.Duration(e.drift*elapsed*float64(time.Second))
+}
+
+// ServerTime 把本地时间换算为服务器的Unix毫秒时间
+func (e *Estimator) ServerTime(local time.Time) int64 {
+	return (unixNano(local) + e.OffsetAt(local)).Milliseconds()
+}
+
+// LocalTime 把服务器的Unix毫秒时间换算为本地时间
+func (e *Estimator) LocalTime(serverTime int64) time.Time {
+	now := time.Now()
+	return now.Add(unixMilli(serverTime) - unixNano(now) - e.OffsetAt(now))
+}
+
+func (e *Estimator) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := Status{
+		Samples:  len(e.samples),
+		Rejected: e.rejected,
+	}
+	if len(e.samples) == 0 {
+		return status
+	}
+	status.Offset = e.offsetAt(time.Now())
+	status.Drift = e.drift * 1e6
+	status.RTT = e.selected.RTT
+	status.Jitter = e.jitter
+	status.Uncertainty = e.selected.RTT/2 + e.jitter
+
+	fill := math.Min(float64(len(e.samples))/confidenceSamples, 1)
+	status.Confidence = fill * float64(confidenceReference) / float64(confidenceReference+status.Uncertainty)
+	return status
+}
+
+func unixMilli(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+func unixNano(t time.Time) time.Duration {
+	return time.Duration(t.UnixNano())
+}
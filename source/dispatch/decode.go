@@ -0,0 +1,32 @@
+package dispatch
+
+import (
+	"errors"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// ErrEmptyBody 命令需要消息体但消息体为空
+var ErrEmptyBody = errors.New("dispatch: empty body")
+
+// Table 使用 flatbuffers 生成的 GetRootAs 函数解析消息体
+func Table[T any](getRoot func(buf []byte, offset flatbuffers.UOffsetT) T) Decoder[T] {
+	return func(body []byte) (T, error) {
+		if len(body) < flatbuffers.SizeUOffsetT {
+			var zero T
+			return zero, ErrEmptyBody
+		}
+		return getRoot(body, 0), nil
+	}
+}
+
+// Decode 使用不返回错误的解析函数（例如 serialization 包中的函数）解析消息体
+func Decode[T any](deserialize func(body []byte) T) Decoder[T] {
+	return func(body []byte) (T, error) {
+		if len(body) < flatbuffers.SizeUOffsetT {
+			var zero T
+			return zero, ErrEmptyBody
+		}
+		return deserialize(body), nil
+	}
+}
@@ -0,0 +1,122 @@
+// Package dispatch 按命令分发消息， 客户端与服务端共用
+//
+// 每个命令注册一个处理函数， 以及把消息体解析为具体类型的函数和前置条件，
+// 中间件（日志、统计、限流、panic恢复）包裹所有命令的处理过程。
+// 增加新命令时只需要注册， 不需要修改分发逻辑。
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Command 命令类型， 例如 fb.ClientCommand、fb.ServerCommand
+type Command interface {
+	comparable
+	String() string
+}
+
+// Message 一条待处理的消息
+type Message[K Command] struct {
+	Command K
+	Body    []byte
+}
+
+// HandlerFunc 处理一条消息， S 为会话类型（服务端的玩家、客户端本身）
+type HandlerFunc[K Command, S any] func(session S, message Message[K]) error
+
+// Middleware 包裹处理函数， 在处理前后执行额外的逻辑
+type Middleware[K Command, S any] func(next HandlerFunc[K, S]) HandlerFunc[K, S]
+
+// Precondition 处理前检查会话状态， 返回错误时不处理该消息
+type Precondition[S any] func(session S) error
+
+// Decoder 把消息体解析为具体的类型
+type Decoder[T any] func(body []byte) (T, error)
+
+var ErrUnknownCommand = errors.New("dispatch: unknown command")
+
+// Registry 命令到处理函数的注册表
+// 注册与 Use 应在开始分发之前完成， Dispatch 本身不加锁
+type Registry[K Command, S any] struct {
+	routes     map[K]HandlerFunc[K, S]
+	middleware []Middleware[K, S]
+	chain      HandlerFunc[K, S]
+}
+
+func NewRegistry[K Command, S any]() *Registry[K, S] {
+	r := &Registry[K, S]{
+		routes: make(map[K]HandlerFunc[K, S]),
+	}
+	r.chain = r.route
+	return r
+}
+
+// Use 添加中间件， 先添加的中间件在最外层
+func (r *Registry[K, S]) Use(middleware ...Middleware[K, S]) {
+	r.middleware = append(r.middleware, middleware...)
+	r.chain = r.route
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		r.chain = r.middleware[i](r.chain)
+	}
+}
+
+// Handle 注册未经解析的处理函数， 同一个命令重复注册时panic
+func (r *Registry[K, S]) Handle(command K, handler HandlerFunc[K, S]) {
+	if _, ok := r.routes[command]; ok {
+		panic(fmt.Sprintf("dispatch: command %v registered twice", command))
+	}
+	r.routes[command] = handler
+}
+
+// Has 命令是否已经注册
+func (r *Registry[K, S]) Has(command K) bool {
+	_, ok := r.routes[command]
+	return ok
+}
+
+// Dispatch 经过中间件处理一条消息， 命令未注册时返回 ErrUnknownCommand
+func (r *Registry[K, S]) Dispatch(session S, command K, body []byte) error {
+	return r.chain(session, Message[K]{Command: command, Body: body})
+}
+
+func (r *Registry[K, S]) route(session S, message Message[K]) error {
+	handler, ok := r.routes[message.Command]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUnknownCommand, message.Command)
+	}
+	return handler(session, message)
+}
+
+// Register 注册带消息体的命令， 依次检查前置条件、解析消息体后调用 handle
+func Register[K Command, S any, T any](r *Registry[K, S], command K, decode Decoder[T], handle func(session S, body T) error, preconditions ...Precondition[S]) {
+	r.Handle(command, func(session S, message Message[K]) error {
+		if err := checkPreconditions(session, preconditions); err != nil {
+			return err
+		}
+		body, err := decode(message.Body)
+		if err != nil {
+			return err
+		}
+		return handle(session, body)
+	})
+}
+
+// RegisterEmpty 注册没有消息体（或忽略消息体）的命令
+func RegisterEmpty[K Command, S any](r *Registry[K, S], command K, handle func(session S) error, preconditions ...Precondition[S]) {
+	r.Handle(command, func(session S, message Message[K]) error {
+		if err := checkPreconditions(session, preconditions); err != nil {
+			return err
+		}
+		return handle(session)
+	})
+}
+
+func checkPreconditions[S any](session S, preconditions []Precondition[S]) error {
+	for _, precondition := range preconditions {
+		if err := precondition(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"gameproject/source/logging"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited 会话发送消息的速度超过限制， 该消息被丢弃
+var ErrRateLimited = errors.New("dispatch: rate limited")
+
+// Recover 把处理过程中的panic转换为错误， 错误中带有调用栈
+func Recover[K Command, S any]() Middleware[K, S] {
+	return func(next HandlerFunc[K, S]) HandlerFunc[K, S] {
+		return func(session S, message Message[K]) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic while handling %v: %v\nStack trace:\n%s", message.Command, r, debug.Stack())
+				}
+			}()
+			return next(session, message)
+		}
+	}
+}
+
+// Logging 记录处理失败的命令， logger 返回会话自己的日志， 日志中带有会话的字段
+func Logging[K Command, S any](logger func(session S) *slog.Logger) Middleware[K, S] {
+	return func(next HandlerFunc[K, S]) HandlerFunc[K, S] {
+		return func(session S, message Message[K]) error {
+			err := next(session, message)
+			if err != nil {
+				logger(session).Warn("Command failed", logging.KeyCommand, message.Command.String(), logging.Err(err))
+			}
+			return err
+		}
+	}
+}
+
+// RateLimit 按会话限流， bucket 返回会话自己的令牌桶， 返回nil表示不限流
+func RateLimit[K Command, S any](bucket func(session S) *TokenBucket) Middleware[K, S] {
+	return func(next HandlerFunc[K, S]) HandlerFunc[K, S] {
+		return func(session S, message Message[K]) error {
+			if b := bucket(session); b != nil && !b.Allow(time.Now()) {
+				return fmt.Errorf("%w: %v", ErrRateLimited, message.Command)
+			}
+			return next(session, message)
+		}
+	}
+}
+
+// TokenBucket 令牌桶， 每秒补充rate个令牌， 最多积攒burst个， 不是并发安全的
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// Allow 取出一个令牌， 没有令牌时返回false
+func (b *TokenBucket) Allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// CommandStats 一个命令的处理统计
+type CommandStats struct {
+	Count    int64
+	Errors   int64
+	Duration time.Duration // 累计处理时间
+}
+
+// Stats 按命令统计处理次数、错误数与耗时， 可以在任意goroutine中读取
+type Stats[K Command] struct {
+	mu       sync.Mutex
+	commands map[K]*CommandStats
+}
+
+func NewStats[K Command]() *Stats[K] {
+	return &Stats[K]{
+		commands: make(map[K]*CommandStats),
+	}
+}
+
+// StatsMiddleware 返回记录统计的中间件
+func StatsMiddleware[K Command, S any](stats *Stats[K]) Middleware[K, S] {
+	return func(next HandlerFunc[K, S]) HandlerFunc[K, S] {
+		return func(session S, message Message[K]) error {
+			start := time.Now()
+			err := next(session, message)
+			stats.record(message.Command, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+func (s *Stats[K]) record(command K, duration time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	commandStats, ok := s.commands[command]
+	if !ok {
+		commandStats = &CommandStats{}
+		s.commands[command] = commandStats
+	}
+	commandStats.Count++
+	commandStats.Duration += duration
+	if err != nil {
+		commandStats.Errors++
+	}
+}
+
+// Snapshot 返回当前统计的副本
+func (s *Stats[K]) Snapshot() map[K]CommandStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[K]CommandStats, len(s.commands))
+	for command, commandStats := range s.commands {
+		snapshot[command] = *commandStats
+	}
+	return snapshot
+}
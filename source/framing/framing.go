@@ -0,0 +1,74 @@
+// Package framing 在可靠的字节流（KCP、TCP、WebSocket）上划分消息
+//
+// 每条消息前加4字节大端序的长度， 接收方按长度读取完整的消息，
+// 不依赖一次Read恰好对应一条消息， 消息大小不受读缓冲区限制。
+package framing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// HeaderSize 长度前缀的字节数
+const HeaderSize = 4
+
+// DefaultMaxFrameSize 默认允许的最大消息长度
+const DefaultMaxFrameSize = 1 << 20
+
+var ErrFrameTooLarge = errors.New("framing: frame too large")
+
+// WriteFrame 写入一条带长度前缀的消息
+// 长度与内容在一次Write中写入， 多个goroutine向同一个连接写入时消息不会交错
+func WriteFrame(w io.Writer, payload []byte) error {
+	if uint64(len(payload)) > math.MaxUint32 {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(payload))
+	}
+	frame := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[HeaderSize:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// Reader 从字节流中按顺序读取消息
+type Reader struct {
+	r            *bufio.Reader
+	maxFrameSize int
+	header       [HeaderSize]byte
+}
+
+// NewReader 创建Reader， maxFrameSize 小于等于0时使用 DefaultMaxFrameSize
+func NewReader(r io.Reader, maxFrameSize int) *Reader {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &Reader{
+		r:            bufio.NewReader(r),
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// ReadFrame 读取下一条完整的消息， 返回的切片归调用方所有
+// 消息长度超过上限时返回 ErrFrameTooLarge， 此后流已无法继续解析， 应关闭连接
+func (r *Reader) ReadFrame() ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(r.header[:])
+	if uint64(size) > uint64(r.maxFrameSize) {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrFrameTooLarge, size, r.maxFrameSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
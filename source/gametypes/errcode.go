@@ -0,0 +1,22 @@
+package gametypes
+
+// S2CCommand.code 中使用的错误码， 仅在 status 为 S2C_STATUS_FAIL 时有意义
+const (
+	ErrCodeNone int64 = 0
+
+	// 大厅相关
+	ErrCodeRoomNotFound    int64 = 1001 // 房间不存在
+	ErrCodeRoomNotJoinable int64 = 1002 // 房间已满或已开始游戏
+	ErrCodeAlreadyInRoom   int64 = 1003 // 已在房间中， 需先离开
+	ErrCodeNotInRoom       int64 = 1004 // 不在房间中
+	ErrCodeRoomStarted     int64 = 1005 // 游戏已开始， 无法离开
+	ErrCodeSessionNotFound int64 = 1006 // 重连的会话不存在或已过期
+	ErrCodeSpectatorsFull  int64 = 1007 // 观战人数已满
+
+	// 玩家输入相关
+	ErrCodeGameNotStarted  int64 = 2001 // 游戏未开始， 不接受输入
+	ErrCodeInvalidCommand  int64 = 2002 // 命令未通过服务端校验
+	ErrCodePlayerMismatch  int64 = 2003 // 输入中的玩家ID与连接的玩家不符
+	ErrCodeFrameOutOfRange int64 = 2004 // 输入的帧号超出服务端允许的范围
+	ErrCodeDuplicateInput  int64 = 2005 // 该帧或更早的帧已经收到过输入
+)
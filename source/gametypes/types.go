@@ -2,7 +2,7 @@ package gametypes
 
 import (
 	"gameproject/fb"
-	"log"
+	"log/slog"
 )
 
 type PlayerCommandType int
@@ -34,7 +34,7 @@ func ConvertFBPlayerCommandType(t fb.PlayerCommandType) PlayerCommandType {
 	if cmd, ok := fbToInternalCmd[t]; ok {
 		return cmd
 	}
-	log.Printf("未知的FB PlayerCommandType: %d", t)
+	slog.Warn("未知的FB PlayerCommandType", "type", int(t))
 	return Invalid // 默认值
 }
 
@@ -42,7 +42,7 @@ func ConvertPlayerCommandType(t PlayerCommandType) fb.PlayerCommandType {
 	if cmd, ok := internalToFBCmd[t]; ok {
 		return cmd
 	}
-	log.Printf("未知的PlayerCommandType: %d", t)
+	slog.Warn("未知的PlayerCommandType", "type", int(t))
 	return fb.PlayerCommandTypeInvalid // 默认值
 }
 
@@ -63,9 +63,31 @@ type PlayerCommand struct {
 }
 
 type PlayerInput struct {
-	ID         int
-	LogicFrame int
-	Commands   []PlayerCommand
+	ID          int
+	LogicFrame  int
+	Commands    []PlayerCommand
+	Substituted bool // 服务端在超时后代替玩家生成的空输入
+}
+
+// 服务端房间状态， 与服务端的 GameState 取值一致， 用于 RoomInfo 与 CatchUp
+const (
+	RoomStateRoom = iota
+	RoomStateWaitPlayersReady
+	RoomStateGameCountDown
+	RoomStateGame
+	RoomStateGameOver
+)
+
+// CatchUp 断线重连时下发的追帧数据
+// Players 为最近一次world sync时的快照， 客户端从快照开始依次处理 PendingInputs 与 InputLog 即可追上服务端
+type CatchUp struct {
+	GameState           int
+	LogicFrame          int
+	SnapshotFrame       int
+	Players             []SerializePlayer
+	PendingInputs       []PlayerInput
+	InputLog            []PlayerInput
+	AppointedServerTime int64
 }
 
 type WorldSync struct {
@@ -92,3 +114,22 @@ func NewGameMap(width, height int) *GameMap {
 		},
 	}
 }
+
+type RoomInfo struct {
+	RoomID      int
+	PlayerCount int
+	MaxPlayers  int
+	State       int
+}
+
+type RoomList struct {
+	Rooms []RoomInfo
+}
+
+// Contains 判断位置是否在地图内， 地图使用以(0,0)为中心的坐标系
+func (m *GameMap) Contains(pos Vector2Int) bool {
+	minX := -m.MapData.Width / 2
+	minY := -m.MapData.Height / 2
+	return pos.X >= minX && pos.X < minX+m.MapData.Width &&
+		pos.Y >= minY && pos.Y < minY+m.MapData.Height
+}
@@ -0,0 +1,10 @@
+// Package integration 服务器与客户端的集成测试
+//
+// 测试在同一个进程中启动 GameServer 与若干个 GameClient， 通过 transport.MemoryNetwork 连接，
+// 双方的游戏逻辑都使用同一个 clock.Fake。 测试每次把时钟推进一小段， 等待网络上没有在途的消息后再继续，
+// 所以同样的操作总是得到同样的消息顺序， 不依赖机器的速度。
+//
+// 服务器与客户端都在各自的goroutine中运行， 测试同时用于检查数据竞争， 运行时加上 -race：
+//
+//	go test -race ./source/integration
+package integration
@@ -0,0 +1,520 @@
+package integration
+
+import (
+	"gameproject/fb"
+	clientbackend "gameproject/source/client/backend"
+	"gameproject/source/gametypes"
+	serverbackend "gameproject/source/server/backend"
+	"gameproject/source/simulation"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 进入游戏流程中的消息， 心跳、RTT探测、输入与world sync等周期性的消息不在其中
+var (
+	lifecycleC2S = []fb.ClientCommand{
+		fb.ClientCommandC2S_COMMAND_QUICKMATCH,
+		fb.ClientCommandC2S_COMMAND_REQUESTTIME,
+		fb.ClientCommandC2S_COMMAND_GAMELOADED,
+	}
+	lifecycleS2C = []fb.ServerCommand{
+		fb.ServerCommandS2C_COMMAND_ENTERLOBBY,
+		fb.ServerCommandS2C_COMMAND_QUICKMATCH,
+		fb.ServerCommandS2C_COMMAND_ENTERROOM,
+		fb.ServerCommandS2C_COMMAND_RESPONSETIME,
+		fb.ServerCommandS2C_COMMAND_STARTENTERGAME,
+		fb.ServerCommandS2C_COMMAND_STARTGAME,
+	}
+)
+
+func TestQuickMatchStartsGame(t *testing.T) {
+	const players = 2
+	h := newHarness(t, harnessConfig{players: players})
+	timeSyncTimes := h.server.Config().TimeSyncTimes
+
+	h.runUntil("room to be created", 2*time.Second, func() bool {
+		_, ok := h.room()
+		return ok
+	})
+	h.runUntil("players to load", 5*time.Second, h.roomState("GameCountDown"))
+
+	room, _ := h.room()
+	if len(room.Players) != players {
+		t.Fatalf("room has %d players, want %d", len(room.Players), players)
+	}
+	for _, player := range room.Players {
+		if player.TimeSyncedTimes != timeSyncTimes {
+			t.Errorf("player %d synced time %d times, want %d", player.ID, player.TimeSyncedTimes, timeSyncTimes)
+		}
+		if !player.IsReady {
+			t.Errorf("player %d is not ready", player.ID)
+		}
+	}
+
+	// 约定的开始时间之后服务器开始推进逻辑帧
+	h.runUntil("game to start", h.server.Config().AppointedServerTimeDelay+time.Second, h.roomState("Game"))
+	// 推进几个world sync， 客户端执行后上报状态哈希
+	h.run(2 * time.Second)
+	if room, _ := h.room(); room.LogicFrame == 0 {
+		t.Fatalf("logic frame did not advance")
+	}
+	h.stop()
+
+	wantC2S := []fb.ClientCommand{fb.ClientCommandC2S_COMMAND_QUICKMATCH}
+	for i := 0; i < timeSyncTimes; i++ {
+		wantC2S = append(wantC2S, fb.ClientCommandC2S_COMMAND_REQUESTTIME)
+	}
+	wantC2S = append(wantC2S, fb.ClientCommandC2S_COMMAND_GAMELOADED)
+
+	wantS2C := []fb.ServerCommand{
+		fb.ServerCommandS2C_COMMAND_ENTERLOBBY,
+		fb.ServerCommandS2C_COMMAND_QUICKMATCH,
+		fb.ServerCommandS2C_COMMAND_ENTERROOM,
+	}
+	for i := 0; i < timeSyncTimes; i++ {
+		wantS2C = append(wantS2C, fb.ServerCommandS2C_COMMAND_RESPONSETIME)
+	}
+	wantS2C = append(wantS2C, fb.ServerCommandS2C_COMMAND_STARTENTERGAME, fb.ServerCommandS2C_COMMAND_STARTGAME)
+
+	for _, c := range h.clients {
+		if state := c.State(); state != clientbackend.Game {
+			t.Errorf("client %d state = %v, want %v", c.PlayerID(), state, clientbackend.Game)
+		}
+
+		sent := c.recorder.sentCommands()
+		if got := filter(sent, lifecycleC2S...); !slices.Equal(got, wantC2S) {
+			t.Errorf("client %d sent %v, want %v", c.PlayerID(), got, wantC2S)
+		}
+		received := c.recorder.receivedCommands()
+		if got := filter(received, lifecycleS2C...); !slices.Equal(got, wantS2C) {
+			t.Errorf("client %d received %v, want %v", c.PlayerID(), got, wantS2C)
+		}
+
+		// 游戏开始后每个客户端都在发送输入、上报状态哈希， 并收到world sync
+		if count(sent, fb.ClientCommandC2S_COMMAND_PLAYERINPUT) == 0 {
+			t.Errorf("client %d sent no input", c.PlayerID())
+		}
+		if count(sent, fb.ClientCommandC2S_COMMAND_STATEHASH) == 0 {
+			t.Errorf("client %d sent no state hash", c.PlayerID())
+		}
+		if count(received, fb.ServerCommandS2C_COMMAND_WORLDSYNC) == 0 {
+			t.Errorf("client %d received no world sync", c.PlayerID())
+		}
+	}
+}
+
+// TestTimeSyncRetry 校时请求丢失时客户端超时后重新请求， 仍然完成校时进入游戏
+func TestTimeSyncRetry(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 1, configure: func(config *serverbackend.ServerConfig) {
+		config.MaxPlayers = 2
+	}})
+	// 第一个客户端在匹配队列中等待， 凑满房间后的第一个校时请求丢失
+	lossy := h.clients[0]
+	lossy.recorder.dropNext(fb.ClientCommandC2S_COMMAND_REQUESTTIME, 1)
+	h.addClient("player2", nil)
+	h.runUntil("game to start", 15*time.Second, h.roomState("Game"))
+	h.stop()
+
+	timeSyncTimes := h.server.Config().TimeSyncTimes
+	if got := count(lossy.recorder.sentCommands(), fb.ClientCommandC2S_COMMAND_REQUESTTIME); got != timeSyncTimes+1 {
+		t.Errorf("client sent %d time sync requests, want %d including the retry", got, timeSyncTimes+1)
+	}
+}
+
+// TestShortInputInterval 发送间隔短于world sync间隔时， 两次world sync之间的多个输入都被服务端接受
+func TestShortInputInterval(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2, configure: func(config *serverbackend.ServerConfig) {
+		config.SendInputInterval = 0.1
+	}})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	h.run(3 * time.Second)
+	h.stop()
+
+	for _, c := range h.clients {
+		for _, f := range c.recorder.failedCommands() {
+			t.Errorf("client %d: %v", c.PlayerID(), f)
+		}
+		// 3秒内每个窗口一次输入， 允许开始与结束时少几个
+		if got := len(ownInputs(c, false)); got < 25 {
+			t.Errorf("server relayed %d inputs from client %d, want at least 25", got, c.PlayerID())
+		}
+	}
+	if events := h.desyncEvents(); len(events) > 0 {
+		t.Errorf("server detected %d desyncs, first at logic frame %d", len(events), events[0].LogicFrame)
+	}
+}
+
+// TestSubstitutedInputResend 输入丢失被服务端代替后， 客户端立即补发的输入与之后的输入都被服务端接受
+func TestSubstitutedInputResend(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+
+	lossy := h.clients[0]
+	lossy.recorder.dropNext(fb.ClientCommandC2S_COMMAND_PLAYERINPUT, 1)
+	h.runUntil("input to be substituted", 10*time.Second, func() bool {
+		return len(ownInputs(lossy, true)) > 0
+	})
+	substituted := ownInputs(lossy, true)[0].LogicFrame
+	// 补发的输入之后还有一个按时发送的输入
+	h.runUntil("inputs after the substitution", 10*time.Second, func() bool {
+		later := 0
+		for _, input := range ownInputs(lossy, false) {
+			if input.LogicFrame > substituted {
+				later++
+			}
+		}
+		return later >= 2
+	})
+	h.stop()
+
+	for _, f := range lossy.recorder.failedCommands() {
+		t.Errorf("client %d: %v", lossy.PlayerID(), f)
+	}
+	if events := h.desyncEvents(); len(events) > 0 {
+		t.Errorf("server detected %d desyncs, first at logic frame %d", len(events), events[0].LogicFrame)
+	}
+}
+
+// TestLateInputAfterSubstitution 服务端代替空输入之后才到达的本窗口输入按重复输入拒绝， 不再转发与执行
+func TestLateInputAfterSubstitution(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+
+	late := h.clients[0]
+	late.recorder.holdNext(fb.ClientCommandC2S_COMMAND_PLAYERINPUT, 1)
+	h.runUntil("input to be substituted", 10*time.Second, func() bool {
+		return len(ownInputs(late, true)) > 0
+	})
+	// 在客户端补发之前送达扣留的输入
+	if err := late.recorder.release(); err != nil {
+		t.Fatalf("failed to send the held input: %v", err)
+	}
+	substituted := ownInputs(late, true)[0].LogicFrame
+	h.runUntil("resent input", 5*time.Second, func() bool {
+		return len(ownInputs(late, false)) > 0
+	})
+	h.stop()
+
+	for _, input := range ownInputs(late, false) {
+		if input.LogicFrame <= substituted {
+			t.Errorf("server relayed late input at frame %d after substituting frame %d", input.LogicFrame, substituted)
+		}
+	}
+	var rejected int
+	for _, f := range late.recorder.failedCommands() {
+		if f.code == gametypes.ErrCodeDuplicateInput {
+			rejected++
+		} else {
+			t.Errorf("client %d: %v", late.PlayerID(), f)
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("server rejected %d inputs as duplicate, want the late input only", rejected)
+	}
+	if events := h.desyncEvents(); len(events) > 0 {
+		t.Errorf("server detected %d desyncs, first at logic frame %d", len(events), events[0].LogicFrame)
+	}
+}
+
+// TestQueuedInputsValidatedInOrder 同一次world sync之前的多个输入按执行顺序校验：
+// 从上一个输入的目标继续移动的输入被接受， 只与执行前的位置相邻的输入被拒绝
+func TestQueuedInputsValidatedInOrder(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	cheater := h.clients[0]
+	// 客户端刚发送过输入， 下一个输入窗口之前不会再发送
+	h.runUntil("client input", 5*time.Second, func() bool {
+		return len(ownInputs(cheater, false)) > 0
+	})
+
+	room, _ := h.room()
+	var start gametypes.Vector2Int
+	occupied := make(map[gametypes.Vector2Int]bool)
+	for _, player := range room.Players {
+		occupied[player.Position] = true
+		if player.ID == cheater.PlayerID() {
+			start = player.Position
+		}
+	}
+	// 选择一个方向， 向前两格与向后一格都在地图内且没有其他玩家
+	gameMap := gametypes.NewGameMap(10, 10)
+	free := func(p gametypes.Vector2Int) bool { return gameMap.Contains(p) && !occupied[p] }
+	var forward, twoForward, backward gametypes.Vector2Int
+	found := false
+	for _, d := range []gametypes.Vector2Int{{X: 1}, {X: -1}, {Y: 1}, {Y: -1}} {
+		forward, twoForward, backward = *start.Add(&d), *start.Add(d.Multiply(2)), *start.Sub(&d)
+		if free(forward) && free(twoForward) && free(backward) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Skipf("no free direction around %v", start)
+	}
+
+	move := func(frame int, target gametypes.Vector2Int) gametypes.PlayerInput {
+		return gametypes.PlayerInput{
+			ID:         cheater.PlayerID(),
+			LogicFrame: frame,
+			Commands: []gametypes.PlayerCommand{
+				{CommandType: gametypes.UseAbility, AbilityID: simulation.AbilityMove, Position: target},
+			},
+		}
+	}
+	frame := room.LogicFrame + 1
+	for _, input := range []gametypes.PlayerInput{
+		move(frame, forward),
+		move(frame+1, backward),   // 与出发点相邻， 但距离第一个输入的目标两格
+		move(frame+2, twoForward), // 从第一个输入的目标继续前进
+	} {
+		if err := cheater.recorder.sendInput(input); err != nil {
+			t.Fatalf("failed to send input: %v", err)
+		}
+	}
+	h.runUntil("inputs to be executed on the server", 5*time.Second, func() bool {
+		room, _ := h.room()
+		return room.LogicFrame >= frame+2
+	})
+	room, _ = h.room()
+	for _, player := range room.Players {
+		if player.ID == cheater.PlayerID() && player.Position != twoForward {
+			t.Errorf("player %d is at %v on the server, want %v", player.ID, player.Position, twoForward)
+		}
+	}
+	h.stop()
+
+	var relayed []gametypes.Vector2Int
+	for _, input := range ownInputs(cheater, false) {
+		if input.LogicFrame >= frame && len(input.Commands) > 0 {
+			relayed = append(relayed, input.Commands[0].Position)
+		}
+	}
+	if want := []gametypes.Vector2Int{forward, twoForward}; !slices.Equal(relayed, want) {
+		t.Errorf("server relayed moves to %v, want %v", relayed, want)
+	}
+	var rejected int
+	for _, f := range cheater.recorder.failedCommands() {
+		if f.code == gametypes.ErrCodeInvalidCommand {
+			rejected++
+		} else {
+			t.Errorf("client %d: %v", cheater.PlayerID(), f)
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("server rejected %d inputs as invalid, want 1", rejected)
+	}
+}
+
+// ownInputs 服务端转发给 c 的 c 自己的输入， substituted 选择服务端代替的空输入或客户端发送的输入
+func ownInputs(c *testClient, substituted bool) []gametypes.PlayerInput {
+	var inputs []gametypes.PlayerInput
+	for _, input := range c.recorder.relayedInputs() {
+		if input.ID == c.PlayerID() && input.Substituted == substituted {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+func TestInputExchange(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	checkInputExchange(t, h)
+}
+
+// TestSecondMatchAfterEndRoom 管理接口结束房间后玩家回到大厅， 再次快速匹配的第二局从头开始， 不残留上一局的状态
+func TestSecondMatchAfterEndRoom(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	h.run(2 * time.Second)
+	first, _ := h.room()
+	if err := h.server.EndRoom(first.ID); err != nil {
+		t.Fatalf("failed to end room: %v", err)
+	}
+	h.runUntil("players to leave the room", 5*time.Second, func() bool {
+		for _, c := range h.clients {
+			if count(c.recorder.receivedCommands(), fb.ServerCommandS2C_COMMAND_LEAVEROOM) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	for _, c := range h.clients {
+		if err := c.QuickMatch(0, 0); err != nil {
+			t.Fatalf("client %d failed to quick match again: %v", c.PlayerID(), err)
+		}
+	}
+	h.runUntil("second game to start", 10*time.Second, func() bool {
+		room, ok := h.room()
+		return ok && room.ID != first.ID && room.State == "Game"
+	})
+	checkInputExchange(t, h)
+	h.stop()
+
+	for _, c := range h.clients {
+		if got := count(c.recorder.receivedCommands(), fb.ServerCommandS2C_COMMAND_STARTGAME); got != 2 {
+			t.Errorf("client %d started %d games, want 2", c.PlayerID(), got)
+		}
+		// 上一局残留的状态会让客户端处理消息出错并断线重连
+		if got := count(c.recorder.sentCommands(), fb.ClientCommandC2S_COMMAND_RECONNECT); got != 0 {
+			t.Errorf("client %d reconnected %d times", c.PlayerID(), got)
+		}
+		if state := c.State(); state != clientbackend.Game {
+			t.Errorf("client %d state = %v, want %v", c.PlayerID(), state, clientbackend.Game)
+		}
+	}
+}
+
+// TestReconnectAfterFailedDial 断线后第一次重连失败时， 客户端等待更长时间后再次重连， 恢复后各端仍然一致
+func TestReconnectAfterFailedDial(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+
+	lost := h.clients[0]
+	lost.recorder.failNextDials(1)
+	if err := lost.recorder.disconnect(); err != nil {
+		t.Fatalf("failed to disconnect: %v", err)
+	}
+	h.runUntil("client to reconnect", 20*time.Second, func() bool {
+		return count(lost.recorder.receivedCommands(), fb.ServerCommandS2C_COMMAND_CATCHUP) > 0
+	})
+	select {
+	case err := <-lost.done:
+		t.Fatalf("client stopped after the failed dial: %v", err)
+	default:
+	}
+	checkInputExchange(t, h)
+	h.stop()
+
+	// 第一次连接、失败的重连与成功的重连
+	if got := lost.recorder.dialCount(); got != 3 {
+		t.Errorf("client dialed %d times, want 3", got)
+	}
+	if got := count(lost.recorder.sentCommands(), fb.ClientCommandC2S_COMMAND_RECONNECT); got != 1 {
+		t.Errorf("client sent %d reconnect requests, want 1", got)
+	}
+}
+
+// TestSpectatorJoinsLate 观战延迟之前的消息已经移除， 中途加入的观战者从快照开始， 看到的位置与服务端相同
+func TestSpectatorJoinsLate(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2, configure: func(config *serverbackend.ServerConfig) {
+		config.SpectatorDelay = time.Second
+	}})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	// 移动发生在观战者加入之前， 快照中的位置需要包含这次移动
+	checkInputExchange(t, h)
+
+	spectator := h.addSpectator("spectator", 0)
+	h.run(3 * time.Second)
+	room, _ := h.room()
+	for _, player := range room.Players {
+		position, ok := spectator.position(player.ID)
+		if !ok {
+			t.Errorf("spectator does not know player %d", player.ID)
+			continue
+		}
+		if position != player.Position {
+			t.Errorf("spectator sees player %d at %v, server has %v", player.ID, position, player.Position)
+		}
+	}
+	h.stop()
+
+	received := spectator.recorder.receivedCommands()
+	if count(received, fb.ServerCommandS2C_COMMAND_CATCHUP) != 1 {
+		t.Errorf("spectator received %d catch ups, want 1", count(received, fb.ServerCommandS2C_COMMAND_CATCHUP))
+	}
+	if count(received, fb.ServerCommandS2C_COMMAND_STARTENTERGAME) != 0 {
+		t.Errorf("spectator received the start of the game, which should have been trimmed from the feed")
+	}
+	if count(received, fb.ServerCommandS2C_COMMAND_WORLDSYNC) == 0 {
+		t.Errorf("spectator received no world sync after catching up")
+	}
+	if state := spectator.State(); state != clientbackend.Game {
+		t.Errorf("spectator state = %v, want %v", state, clientbackend.Game)
+	}
+}
+
+// checkInputExchange 第一个客户端移动一次， 服务端执行后所有客户端看到的位置与服务端相同， 且没有不同步
+func checkInputExchange(t *testing.T, h *harness) {
+	t.Helper()
+
+	// 第一个客户端向一个空着的相邻格子移动一次
+	mover := h.clients[0]
+	var (
+		mu     sync.Mutex
+		target *gametypes.Vector2Int
+	)
+	mover.setOnTick(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if target != nil {
+			return
+		}
+		position, ok := mover.position(mover.PlayerID())
+		if !ok {
+			return
+		}
+		next, ok := freeNeighbour(mover, position)
+		if !ok {
+			return
+		}
+		if err := mover.SendMovement(next.X-position.X, next.Y-position.Y); err != nil {
+			t.Errorf("failed to send movement: %v", err)
+			return
+		}
+		target = &next
+	})
+
+	serverPosition := func(playerID int) gametypes.Vector2Int {
+		room, _ := h.room()
+		for _, player := range room.Players {
+			if player.ID == playerID {
+				return player.Position
+			}
+		}
+		t.Fatalf("player %d is not in the room", playerID)
+		return gametypes.Vector2Int{}
+	}
+	h.runUntil("movement to be executed on the server", 10*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return target != nil && serverPosition(mover.PlayerID()) == *target
+	})
+	// 再推进几个发送间隔， 让所有客户端执行到同一逻辑帧并上报状态哈希
+	h.run(5 * time.Second)
+
+	room, _ := h.room()
+	for _, c := range h.clients {
+		for _, player := range room.Players {
+			position, ok := c.position(player.ID)
+			if !ok {
+				t.Errorf("client %d does not know player %d", c.PlayerID(), player.ID)
+				continue
+			}
+			if position != player.Position {
+				t.Errorf("client %d sees player %d at %v, server has %v", c.PlayerID(), player.ID, position, player.Position)
+			}
+		}
+		if count(c.recorder.receivedCommands(), fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC) == 0 {
+			t.Errorf("client %d received no inputs", c.PlayerID())
+		}
+	}
+	if events := h.desyncEvents(); len(events) > 0 {
+		t.Errorf("server detected %d desyncs, first at logic frame %d", len(events), events[0].LogicFrame)
+	}
+}
+
+// freeNeighbour 返回 c 看到的没有其他玩家的相邻格子， 出生位置不在地图边缘， 相邻的格子都在地图内
+// 在 c 的主循环中调用， 只读取 c 自己记录的位置
+func freeNeighbour(c *testClient, position gametypes.Vector2Int) (gametypes.Vector2Int, bool) {
+	for _, offset := range []gametypes.Vector2Int{{X: 1}, {X: -1}, {Y: 1}, {Y: -1}} {
+		next := *position.Add(&offset)
+		if !c.occupied(next) {
+			return next, true
+		}
+	}
+	return gametypes.Vector2Int{}, false
+}
@@ -0,0 +1,561 @@
+package integration
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"gameproject/fb"
+	clientbackend "gameproject/source/client/backend"
+	"gameproject/source/clock"
+	"gameproject/source/framing"
+	"gameproject/source/gametypes"
+	"gameproject/source/netsim"
+	"gameproject/source/serialization"
+	serverbackend "gameproject/source/server/backend"
+	"gameproject/source/transport"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+const (
+	serverAddr = "127.0.0.1:12345"
+	// stepDuration 每一步推进的时间， 与客户端的帧间隔相同
+	stepDuration = time.Second / 60
+	// settleTimeout 等待网络静止的真实时间上限
+	settleTimeout = 2 * time.Second
+)
+
+func TestMain(m *testing.M) {
+	// 只在 -v 时输出日志， 排查失败的测试时使用
+	flag.Parse()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+// harness 一个服务器与若干个客户端， 只能在测试的goroutine中使用
+type harness struct {
+	t       *testing.T
+	clock   *clock.Fake
+	started time.Time // 测试开始的时间， 网络模拟的场景从这时开始
+	network *transport.MemoryNetwork
+	server  *serverbackend.GameServer
+	clients []*testClient
+	netsims []*netsim.Transport
+
+	mu      sync.Mutex
+	desyncs []serverbackend.DesyncEvent
+}
+
+// testClient 客户端与它在连接上发送、收到的消息
+type testClient struct {
+	*clientbackend.GameClient
+	recorder *recorder
+	done     chan error
+
+	mu        sync.Mutex
+	positions map[int]gametypes.Vector2Int // 客户端看到的各个玩家的位置
+	onTick    func()                       // 游戏中每帧在客户端的主循环中调用
+}
+
+// harnessConfig 测试的服务器与客户端
+type harnessConfig struct {
+	players   int
+	configure func(config *serverbackend.ServerConfig) // 启动前修改服务器配置， 为nil时使用默认配置
+	network   *netsim.Scenario                         // 客户端连接上模拟的网络条件， 为nil时不模拟
+}
+
+// newHarness 启动服务器与客户端， 客户端进入大厅后立即快速匹配， 凑满一个房间
+func newHarness(t *testing.T, hc harnessConfig) *harness {
+	t.Helper()
+	h := &harness{
+		t:       t,
+		clock:   clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		network: transport.NewMemoryNetwork(),
+	}
+	h.started = h.clock.Now()
+
+	config := serverbackend.DefaultServerConfig()
+	config.MaxPlayers = hc.players
+	config.ReplayDir = ""
+	if hc.configure != nil {
+		hc.configure(config)
+	}
+	h.server = serverbackend.NewGameServer()
+	if err := h.server.ApplyConfig(config); err != nil {
+		t.Fatalf("invalid server config: %v", err)
+	}
+	h.server.SetTransport(h.network)
+	h.server.SetClock(h.clock)
+	h.server.SetOnDesync(func(event serverbackend.DesyncEvent) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.desyncs = append(h.desyncs, event)
+	})
+	if err := h.server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(h.stop)
+
+	for i := 0; i < hc.players; i++ {
+		h.addClient(fmt.Sprintf("player%d", i+1), hc.network)
+	}
+	h.settle()
+	return h
+}
+
+// addClient 连接一个客户端， 进入大厅后立即快速匹配， network 不为nil时在客户端的连接上模拟网络条件
+func (h *harness) addClient(nickname string, network *netsim.Scenario) *testClient {
+	h.t.Helper()
+	return h.connect(nickname, network, func(c *testClient) {
+		c.QuickMatch(0, 0)
+	})
+}
+
+// addSpectator 连接一个客户端， 进入大厅后观战 roomID， 0表示ID最小的房间
+func (h *harness) addSpectator(nickname string, roomID int) *testClient {
+	h.t.Helper()
+	return h.connect(nickname, nil, func(c *testClient) {
+		c.Spectate(roomID)
+	})
+}
+
+// connect 连接一个客户端， 进入大厅后调用 onEnterLobby
+func (h *harness) connect(nickname string, network *netsim.Scenario, onEnterLobby func(c *testClient)) *testClient {
+	h.t.Helper()
+	c := &testClient{
+		GameClient: clientbackend.NewGameClient(),
+		recorder:   &recorder{},
+		done:       make(chan error, 1),
+		positions:  make(map[int]gametypes.Vector2Int),
+	}
+	var clientTransport transport.Transport = h.network
+	if network != nil {
+		// 每个客户端使用不同的种子， 同一个测试每次运行的随机选择相同
+		sim := netsim.Wrap(h.network, network, h.clock, uint64(len(h.clients)+1))
+		h.netsims = append(h.netsims, sim)
+		clientTransport = sim
+	}
+	c.SetTransport(&recordingTransport{Transport: clientTransport, recorder: c.recorder})
+	c.SetClock(h.clock)
+	c.SetNickname(nickname)
+	c.SetOnEnterLobby(func() {
+		onEnterLobby(c)
+	})
+	c.SetOnGameTick(func(time.Time) {
+		c.mu.Lock()
+		onTick := c.onTick
+		c.mu.Unlock()
+		if onTick != nil {
+			onTick()
+		}
+	})
+	c.SetOnPlayersUpdate(func(player *clientbackend.Player) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.positions[player.ID] = player.Position
+	})
+	if err := c.Connect(serverAddr); err != nil {
+		h.t.Fatalf("client %s failed to connect: %v", nickname, err)
+	}
+	go func() {
+		c.done <- c.Start()
+	}()
+	h.clients = append(h.clients, c)
+	return c
+}
+
+// stop 停止所有客户端与服务器， 客户端的主循环退出后才能读取它的状态
+func (h *harness) stop() {
+	for _, c := range h.clients {
+		c.Stop()
+	}
+	for _, c := range h.clients {
+		select {
+		case err := <-c.done:
+			if err != nil {
+				h.t.Errorf("client %d stopped with error: %v", c.PlayerID(), err)
+			}
+			c.done <- err // 重复调用 stop 时不再等待
+		case <-time.After(settleTimeout):
+			h.t.Errorf("client %d did not stop", c.PlayerID())
+		}
+	}
+	h.server.Stop()
+}
+
+// settle 等待所有消息都已被读取、处理它们产生的回复也都已送达
+// 网络模拟中尚未到送达时间的消息不影响， 在之后推进时钟时送达
+func (h *harness) settle() {
+	h.t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	written := h.network.Written()
+	for stable := 0; stable < 3; {
+		time.Sleep(time.Millisecond)
+		if time.Now().After(deadline) {
+			h.t.Fatalf("network did not settle, %d bytes pending", h.network.Pending())
+		}
+		current := h.network.Written()
+		if h.network.Pending() == 0 && h.overdue() == 0 && current == written {
+			stable++
+		} else {
+			stable = 0
+			written = current
+		}
+	}
+}
+
+func (h *harness) overdue() int {
+	n := 0
+	for _, sim := range h.netsims {
+		n += sim.Overdue()
+	}
+	return n
+}
+
+// step 推进一帧并等待网络静止
+func (h *harness) step() {
+	h.t.Helper()
+	h.clock.Advance(stepDuration)
+	h.settle()
+}
+
+// run 推进 d 的游戏时间
+func (h *harness) run(d time.Duration) {
+	h.t.Helper()
+	for elapsed := time.Duration(0); elapsed < d; elapsed += stepDuration {
+		h.step()
+	}
+}
+
+// runTo 推进到测试开始后的 at， 已经超过时不推进
+func (h *harness) runTo(at time.Duration) {
+	h.t.Helper()
+	h.run(at - h.clock.Since(h.started))
+}
+
+// runUntil 逐帧推进直到 done 返回true， 超过 limit 的游戏时间时测试失败
+func (h *harness) runUntil(what string, limit time.Duration, done func() bool) {
+	h.t.Helper()
+	for elapsed := time.Duration(0); !done(); elapsed += stepDuration {
+		if elapsed > limit {
+			h.t.Fatalf("timed out after %v waiting for %s", limit, what)
+		}
+		h.step()
+	}
+}
+
+// room 返回唯一的房间， 没有房间时返回false
+func (h *harness) room() (serverbackend.RoomSnapshot, bool) {
+	h.t.Helper()
+	rooms, err := h.server.Rooms()
+	if err != nil {
+		h.t.Fatalf("failed to list rooms: %v", err)
+	}
+	if len(rooms) > 1 {
+		h.t.Fatalf("expected at most one room, got %d", len(rooms))
+	}
+	if len(rooms) == 0 {
+		return serverbackend.RoomSnapshot{}, false
+	}
+	return rooms[0], true
+}
+
+// roomState 房间处于 state 时返回true
+func (h *harness) roomState(state string) func() bool {
+	return func() bool {
+		room, ok := h.room()
+		return ok && room.State == state
+	}
+}
+
+func (h *harness) desyncEvents() []serverbackend.DesyncEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]serverbackend.DesyncEvent(nil), h.desyncs...)
+}
+
+// position 客户端看到的玩家位置
+func (c *testClient) position(playerID int) (gametypes.Vector2Int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	position, ok := c.positions[playerID]
+	return position, ok
+}
+
+// occupied 客户端看到的是否有玩家在该位置
+func (c *testClient) occupied(position gametypes.Vector2Int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.positions {
+		if p == position {
+			return true
+		}
+	}
+	return false
+}
+
+// setOnTick 设置游戏中每帧执行的操作， 客户端启动后也可以调用
+func (c *testClient) setOnTick(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onTick = f
+}
+
+// recorder 按顺序记录一个客户端连接上的消息类型
+type recorder struct {
+	mu       sync.Mutex
+	sent     []fb.ClientCommand
+	received []fb.ServerCommand
+	failures []failure                // 服务端回复失败的命令
+	inputs   []gametypes.PlayerInput  // 服务端转发的输入
+	partial  []byte                   // 尚未读完整的消息
+	drops    map[fb.ClientCommand]int // 接下来要丢弃的消息数
+	holds    map[fb.ClientCommand]int // 接下来要扣留的消息数
+	held     []heldMessage            // 扣留、尚未发出的消息
+	conn     transport.Conn           // 最近一次发送消息的连接
+	dials    int                      // 建立连接的次数， 包括失败的
+	failing  int                      // 接下来要失败的建立连接的次数
+}
+
+// heldMessage 扣留的消息与发送它的连接
+type heldMessage struct {
+	conn  transport.Conn
+	frame []byte
+}
+
+// dropNext 丢弃客户端接下来发出的 n 条 command， 模拟消息丢失， 丢弃的消息仍然记录为已发送
+func (r *recorder) dropNext(command fb.ClientCommand, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.drops == nil {
+		r.drops = make(map[fb.ClientCommand]int)
+	}
+	r.drops[command] += n
+}
+
+// holdNext 扣留客户端接下来发出的 n 条 command， 调用 release 时才发出， 模拟迟到的消息
+func (r *recorder) holdNext(command fb.ClientCommand, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.holds == nil {
+		r.holds = make(map[fb.ClientCommand]int)
+	}
+	r.holds[command] += n
+}
+
+// release 按顺序发出扣留的消息
+func (r *recorder) release() error {
+	r.mu.Lock()
+	held := r.held
+	r.held = nil
+	r.mu.Unlock()
+	for _, m := range held {
+		if _, err := m.conn.Write(m.frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failNextDials 客户端接下来 n 次建立连接都失败， 模拟断线后服务器暂时无法连接
+func (r *recorder) failNextDials(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing += n
+}
+
+// disconnect 关闭客户端最近使用的连接， 模拟断线
+func (r *recorder) disconnect() error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return errors.New("client has not sent anything yet")
+	}
+	return conn.Close()
+}
+
+// recordDial 记录一次建立连接， 返回这次是否应当失败
+func (r *recorder) recordDial() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dials++
+	if r.failing > 0 {
+		r.failing--
+		return errors.New("dial failed")
+	}
+	return nil
+}
+
+func (r *recorder) dialCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dials
+}
+
+// sendInput 绕过客户端的预测与帧号， 在客户端的连接上直接发送一个输入， 模拟作弊或有问题的客户端
+func (r *recorder) sendInput(input gametypes.PlayerInput) error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return errors.New("client has not sent anything yet")
+	}
+
+	body := serialization.SerializePlayerInput(&input)
+	builder := flatbuffers.NewBuilder(len(body) + 64)
+	bodyOffset := builder.CreateByteVector(body)
+	fb.C2SCommandStart(builder)
+	fb.C2SCommandAddCommand(builder, fb.ClientCommandC2S_COMMAND_PLAYERINPUT)
+	fb.C2SCommandAddBody(builder, bodyOffset)
+	builder.Finish(fb.C2SCommandEnd(builder))
+	return framing.WriteFrame(conn, builder.FinishedBytes())
+}
+
+// recordSent 客户端的每次Write恰好是一条带长度前缀的消息， 返回该消息是否应当发出， 丢弃或扣留的消息不发出
+func (r *recorder) recordSent(conn transport.Conn, frame []byte) bool {
+	if len(frame) <= framing.HeaderSize {
+		return true
+	}
+	command := fb.GetRootAsC2SCommand(frame[framing.HeaderSize:], 0).Command()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conn = conn
+	r.sent = append(r.sent, command)
+	switch {
+	case r.drops[command] > 0:
+		r.drops[command]--
+		return false
+	case r.holds[command] > 0:
+		r.holds[command]--
+		r.held = append(r.held, heldMessage{conn: conn, frame: append([]byte(nil), frame...)})
+		return false
+	}
+	return true
+}
+
+// recordReceived 读取的数据可能包含不完整的消息， 拼接后按长度前缀切分
+func (r *recorder) recordReceived(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partial = append(r.partial, data...)
+	for len(r.partial) >= framing.HeaderSize {
+		size := int(binary.BigEndian.Uint32(r.partial))
+		if len(r.partial) < framing.HeaderSize+size {
+			return
+		}
+		payload := r.partial[framing.HeaderSize : framing.HeaderSize+size]
+		command := fb.GetRootAsS2CCommand(payload, 0)
+		r.received = append(r.received, command.Command())
+		switch {
+		case command.Status() == fb.S2CStatusS2C_STATUS_FAIL:
+			r.failures = append(r.failures, failure{command: command.Command(), code: command.Code(), message: string(command.Message())})
+		case command.Command() == fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC:
+			r.inputs = append(r.inputs, serialization.DeserializePlayerInput(command.BodyBytes()))
+		}
+		r.partial = append([]byte(nil), r.partial[framing.HeaderSize+size:]...)
+	}
+}
+
+func (r *recorder) sentCommands() []fb.ClientCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]fb.ClientCommand(nil), r.sent...)
+}
+
+func (r *recorder) receivedCommands() []fb.ServerCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]fb.ServerCommand(nil), r.received...)
+}
+
+func (r *recorder) failedCommands() []failure {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]failure(nil), r.failures...)
+}
+
+func (r *recorder) relayedInputs() []gametypes.PlayerInput {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]gametypes.PlayerInput(nil), r.inputs...)
+}
+
+// failure 服务端回复的失败
+type failure struct {
+	command fb.ServerCommand
+	code    int64
+	message string
+}
+
+func (f failure) String() string {
+	return fmt.Sprintf("%v failed with code %d: %s", f.command, f.code, f.message)
+}
+
+// recordingTransport 记录经过的消息， 其余行为与被包装的传输方式相同
+type recordingTransport struct {
+	transport.Transport
+	recorder *recorder
+}
+
+func (t *recordingTransport) Dial(addr string) (transport.Conn, error) {
+	if err := t.recorder.recordDial(); err != nil {
+		return nil, err
+	}
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, recorder: t.recorder}, nil
+}
+
+type recordingConn struct {
+	transport.Conn
+	recorder *recorder
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	if !c.recorder.recordSent(c.Conn, b) {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.recorder.recordReceived(b[:n])
+	return n, err
+}
+
+// filter 按顺序保留属于 keep 的元素
+func filter[T comparable](items []T, keep ...T) []T {
+	var result []T
+	for _, item := range items {
+		for _, k := range keep {
+			if item == k {
+				result = append(result, item)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func count[T comparable](items []T, item T) int {
+	n := 0
+	for _, other := range items {
+		if other == item {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package integration
+
+import (
+	"gameproject/fb"
+	"gameproject/source/gametypes"
+	"gameproject/source/netsim"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInputExchangeWithLatency(t *testing.T) {
+	scenario, err := netsim.Load("latency=80ms jitter=20ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHarness(t, harnessConfig{players: 2, network: scenario})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	checkInputExchange(t, h)
+}
+
+// TestClientStall 客户端的上行中断几秒后恢复， 服务端代替它发送空输入， 恢复后各端仍然一致
+func TestClientStall(t *testing.T) {
+	stall, err := netsim.Parse("stall", strings.NewReader(`
+0s  both latency=30ms
+10s send latency=3s
+13s send latency=30ms
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHarness(t, harnessConfig{players: 2, network: stall})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	h.runTo(20 * time.Second)
+	if room, _ := h.room(); room.State != "Game" {
+		t.Fatalf("room state = %s after the stall, want Game", room.State)
+	}
+	checkInputExchange(t, h)
+}
+
+// TestDuplicateMessages 双向都有消息重复时， 客户端与服务端丢弃已处理过的消息， 各端的状态仍然一致
+func TestDuplicateMessages(t *testing.T) {
+	scenario, err := netsim.Load("latency=30ms jitter=10ms duplicate=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHarness(t, harnessConfig{players: 2, network: scenario})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+	h.run(5 * time.Second)
+	checkInputExchange(t, h)
+	h.stop()
+
+	duplicated := 0
+	for _, c := range h.clients {
+		seen := make(map[[2]int]bool)
+		for _, input := range c.recorder.relayedInputs() {
+			key := [2]int{input.ID, input.LogicFrame}
+			if seen[key] {
+				duplicated++
+			}
+			seen[key] = true
+		}
+		// 重复的消息不会被当作错误而断开重连， 服务端拒绝的只有重复发送的输入
+		if got := count(c.recorder.sentCommands(), fb.ClientCommandC2S_COMMAND_RECONNECT); got != 0 {
+			t.Errorf("client %d sent %d reconnect requests", c.PlayerID(), got)
+		}
+		for _, f := range c.recorder.failedCommands() {
+			if f.code != gametypes.ErrCodeDuplicateInput {
+				t.Errorf("client %d: %v", c.PlayerID(), f)
+			}
+		}
+	}
+	if duplicated == 0 {
+		t.Errorf("no relayed input was duplicated")
+	}
+}
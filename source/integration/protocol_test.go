@@ -0,0 +1,82 @@
+package integration
+
+import (
+	"fmt"
+	"gameproject/source/framing"
+	"gameproject/source/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMalformedCommand 外层无法解析的消息只断开发送它的连接， 服务器与进行中的游戏不受影响
+func TestMalformedCommand(t *testing.T) {
+	h := newHarness(t, harnessConfig{players: 2})
+	h.runUntil("game to start", 10*time.Second, h.roomState("Game"))
+
+	frames := map[string][]byte{
+		"root offset out of range": {0xff, 0xff, 0xff, 0x7f},
+		"vtable out of range":      {0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80},
+		"body out of range":        {0x0c, 0x00, 0x00, 0x00, 0x08, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x04, 0x00, 0x08, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00},
+	}
+	for name, frame := range frames {
+		conn, err := h.network.Dial(serverAddr)
+		if err != nil {
+			t.Fatalf("%s: failed to connect: %v", name, err)
+		}
+		// 一直读取到连接关闭， 服务器先发送进入大厅的消息
+		reader := framing.NewReader(conn, framing.DefaultMaxFrameSize)
+		closed := make(chan error, 1)
+		go func() {
+			for {
+				if _, err := reader.ReadFrame(); err != nil {
+					closed <- err
+					return
+				}
+			}
+		}()
+		h.settle()
+		if err := framing.WriteFrame(conn, frame); err != nil {
+			t.Fatalf("%s: failed to send: %v", name, err)
+		}
+		h.step()
+
+		select {
+		case <-closed:
+		case <-time.After(settleTimeout):
+			t.Errorf("%s: server did not close the connection", name)
+		}
+		conn.Close()
+	}
+
+	// 无法解析的消息单独计数， 不计入按命令统计的收到的消息
+	exposition := scrapeMetrics(t, h)
+	if want := fmt.Sprintf("gameserver_invalid_messages_total %d\n", len(frames)); !strings.Contains(exposition, want) {
+		t.Errorf("metrics do not contain %q", strings.TrimSpace(want))
+	}
+	if strings.Contains(exposition, "C2S_COMMAND_INVALID") {
+		t.Errorf("malformed messages were counted as received commands")
+	}
+
+	before, _ := h.room()
+	h.run(time.Second)
+	after, ok := h.room()
+	if !ok || after.LogicFrame <= before.LogicFrame {
+		t.Fatalf("game stopped after malformed commands: logic frame %d -> %d", before.LogicFrame, after.LogicFrame)
+	}
+	checkInputExchange(t, h)
+}
+
+// scrapeMetrics 返回服务器当前的Prometheus文本格式的指标
+func scrapeMetrics(t *testing.T, h *harness) string {
+	t.Helper()
+	var b strings.Builder
+	w := metrics.NewWriter(&b)
+	if err := h.server.WriteMetrics(w); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	return b.String()
+}
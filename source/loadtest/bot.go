@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"gameproject/fb"
+	"gameproject/source/clocksync"
+	"gameproject/source/dispatch"
+	"gameproject/source/framing"
+	"gameproject/source/gametypes"
+	"gameproject/source/logging"
+	"gameproject/source/serialization"
+	"gameproject/source/transport"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+const (
+	botTickInterval     = 10 * time.Millisecond // 检查定时任务的间隔， 决定发送时间的精度
+	pingInterval        = 1 * time.Second       // 与客户端相同的心跳间隔
+	clockResyncInterval = 10 * time.Second      // 游戏中重新校时的间隔， 与客户端相同
+	readTimeout         = 10 * time.Second
+	pendingInputTimeout = 10 * time.Second // 超过该时间没有收到转发的输入不再等待
+)
+
+type phase int
+
+const (
+	connecting phase = iota
+	lobby
+	room
+	loading
+	countDown
+	game
+)
+
+// botDispatcher 服务端命令的处理函数， 所有模拟客户端共用
+var botDispatcher = newBotDispatcher()
+
+func newBotDispatcher() *dispatch.Registry[fb.ServerCommand, *bot] {
+	r := dispatch.NewRegistry[fb.ServerCommand, *bot]()
+	r.Use(dispatch.Recover[fb.ServerCommand, *bot]())
+
+	dispatch.RegisterEmpty(r, fb.ServerCommandS2C_COMMAND_PONG, (*bot).handlePong)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_ENTERLOBBY, dispatch.Table(fb.GetRootAsS2CEnterLobby), (*bot).handleEnterLobby)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_ENTERROOM, dispatch.Table(fb.GetRootAsS2CEnterRoom), (*bot).handleEnterRoom)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_RESPONSETIME, dispatch.Table(fb.GetRootAsS2CResponseTime), (*bot).handleResponseTime)
+	dispatch.RegisterEmpty(r, fb.ServerCommandS2C_COMMAND_STARTENTERGAME, (*bot).handleStartEnterGame)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_STARTGAME, dispatch.Table(fb.GetRootAsS2CStartGame), (*bot).handleStartGame)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_WORLDSYNC, dispatch.Decode(serialization.DeserializeWorldSync), (*bot).handleWorldSync)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_LATENCYPROBE, dispatch.Table(fb.GetRootAsS2CLatencyProbe), (*bot).handleLatencyProbe)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_INPUTDELAY, dispatch.Table(fb.GetRootAsS2CInputDelay), (*bot).handleInputDelay)
+	dispatch.Register(r, fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC, dispatch.Decode(serialization.DeserializePlayerInput), (*bot).handlePlayerInputSync)
+	return r
+}
+
+// frame 读取goroutine收到的消息， 接收时间在读到时记录， 不包含在主循环中排队的时间
+type frame struct {
+	data       []byte
+	receivedAt time.Time
+}
+
+// bot 只走协议流程的模拟客户端， 不执行游戏逻辑， 所有状态只在 run 的goroutine中访问
+// 流程与客户端相同： 快速匹配、若干次校时、加载完成后按服务器时钟定期发送空输入
+type bot struct {
+	index  int
+	conn   transport.Conn
+	stats  *stats
+	logger *slog.Logger
+
+	phase      phase
+	playerID   int
+	receivedAt time.Time // 正在处理的消息的接收时间
+
+	clock          *clocksync.Estimator
+	timeSyncTimes  int
+	timeSyncs      int
+	timeSyncSentAt time.Time // 没有等待回复的校时请求时为零值
+	lastTimeSync   time.Time
+
+	lastPing   time.Time
+	pingSentAt time.Time // PONG 没有消息体， 同一时间只对一个 PING 计时
+
+	loadAt              time.Time // 模拟加载完成的时间
+	sendInputInterval   time.Duration
+	tickRate            int
+	inputLeadFrames     int
+	gameStartServerTime int64
+	nextInputServerTime int64
+	logicFrame          int
+	lastInputFrame      int
+	sentInputs          map[int]time.Time // 等待服务端转发的输入， 按帧号记录发送时间
+}
+
+func newBot(index int, conn transport.Conn, s *stats) *bot {
+	return &bot{
+		index:             index,
+		conn:              conn,
+		stats:             s,
+		logger:            slog.With(logging.KeyClient, index),
+		clock:             clocksync.NewEstimator(clocksync.DefaultWindow),
+		sendInputInterval: 2 * time.Second,
+		tickRate:          20,
+		lastInputFrame:    -1,
+		sentInputs:        make(map[int]time.Time),
+	}
+}
+
+// run 处理消息与定时任务直到 stop 关闭， 连接出错时返回错误
+func (b *bot) run(stop <-chan struct{}) error {
+	frames := make(chan frame, 64)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	go b.receive(frames, readErr, done)
+	defer close(done)
+	defer b.conn.Close()
+
+	ticker := time.NewTicker(botTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-readErr:
+			return err
+		case f := <-frames:
+			if err := b.handleFrame(f); err != nil {
+				return err
+			}
+		case now := <-ticker.C:
+			if err := b.tick(now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// receive 在单独的goroutine中读取消息， run 返回后关闭 done， 不再投递
+func (b *bot) receive(frames chan<- frame, readErr chan<- error, done <-chan struct{}) {
+	reader := framing.NewReader(b.conn, framing.DefaultMaxFrameSize)
+	for {
+		b.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		data, err := reader.ReadFrame()
+		if err != nil {
+			readErr <- fmt.Errorf("connection read error: %w", err)
+			return
+		}
+		select {
+		case frames <- frame{data: data, receivedAt: time.Now()}:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (b *bot) handleFrame(f frame) error {
+	if len(f.data) < flatbuffers.SizeUOffsetT {
+		return fmt.Errorf("invalid message of %d bytes", len(f.data))
+	}
+	s2cCommand := fb.GetRootAsS2CCommand(f.data, 0)
+	b.receivedAt = f.receivedAt
+
+	if s2cCommand.Status() == fb.S2CStatusS2C_STATUS_FAIL {
+		b.stats.commandFailures.Add(1)
+		if s2cCommand.Command() == fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC {
+			b.stats.inputsRejected.Add(1)
+		}
+		b.logger.Debug("Command failed",
+			logging.KeyCommand, s2cCommand.Command().String(),
+			"code", s2cCommand.Code(),
+			"message", string(s2cCommand.Message()))
+		return nil
+	}
+	// 不关心的命令（例如其他客户端的加载进度）直接忽略
+	if !botDispatcher.Has(s2cCommand.Command()) {
+		return nil
+	}
+	return botDispatcher.Dispatch(b, s2cCommand.Command(), s2cCommand.BodyBytes())
+}
+
+func (b *bot) tick(now time.Time) error {
+	if now.Sub(b.lastPing) >= pingInterval {
+		b.lastPing = now
+		if b.pingSentAt.IsZero() {
+			b.pingSentAt = now
+		}
+		if err := b.send(fb.ClientCommandC2S_COMMAND_PING, nil); err != nil {
+			return err
+		}
+	}
+
+	switch b.phase {
+	case loading:
+		if !now.Before(b.loadAt) {
+			b.phase = countDown
+			return b.send(fb.ClientCommandC2S_COMMAND_GAMELOADED, nil)
+		}
+	case countDown:
+		if b.gameStartServerTime > 0 && b.clock.ServerTime(now) >= b.gameStartServerTime {
+			b.phase = game
+			b.stats.inGame.Add(1)
+			b.nextInputServerTime = b.gameStartServerTime + b.sendInputInterval.Milliseconds()
+		}
+	case game:
+		if b.timeSyncSentAt.IsZero() && now.Sub(b.lastTimeSync) >= clockResyncInterval {
+			if err := b.requestTime(now); err != nil {
+				return err
+			}
+		}
+		lead := time.Duration(b.inputLeadFrames) * time.Second / time.Duration(b.tickRate)
+		serverNow := b.clock.ServerTime(now) + lead.Milliseconds()
+		if serverNow >= b.nextInputServerTime {
+			interval := b.sendInputInterval.Milliseconds()
+			b.nextInputServerTime += ((serverNow-b.nextInputServerTime)/interval + 1) * interval
+			return b.sendInput(now)
+		}
+	}
+	return nil
+}
+
+// sendInput 以最近一次world sync的逻辑帧发送空输入， 与上一次输入的帧号相同时跳过
+func (b *bot) sendInput(now time.Time) error {
+	if b.logicFrame <= b.lastInputFrame {
+		b.stats.inputsSkipped.Add(1)
+		return nil
+	}
+	for logicFrame, sentAt := range b.sentInputs {
+		if now.Sub(sentAt) > pendingInputTimeout {
+			delete(b.sentInputs, logicFrame)
+		}
+	}
+
+	input := gametypes.PlayerInput{ID: b.playerID, LogicFrame: b.logicFrame}
+	b.lastInputFrame = b.logicFrame
+	b.sentInputs[b.logicFrame] = now
+	b.stats.inputsSent.Add(1)
+	return b.send(fb.ClientCommandC2S_COMMAND_PLAYERINPUT, serialization.SerializePlayerInput(&input))
+}
+
+// requestTime 先记录发送时间再发送， 低延迟时回复可能很快被处理
+func (b *bot) requestTime(now time.Time) error {
+	b.timeSyncSentAt = now
+	b.lastTimeSync = now
+	return b.send(fb.ClientCommandC2S_COMMAND_REQUESTTIME, nil)
+}
+
+func (b *bot) send(command fb.ClientCommand, body []byte) error {
+	builder := flatbuffers.NewBuilder(64 + len(body))
+	var bodyOffset flatbuffers.UOffsetT
+	if body != nil {
+		bodyOffset = builder.CreateByteVector(body)
+	}
+	fb.C2SCommandStart(builder)
+	fb.C2SCommandAddCommand(builder, command)
+	if body != nil {
+		fb.C2SCommandAddBody(builder, bodyOffset)
+	}
+	builder.Finish(fb.C2SCommandEnd(builder))
+
+	if err := framing.WriteFrame(b.conn, builder.FinishedBytes()); err != nil {
+		return fmt.Errorf("failed to send %v: %w", command, err)
+	}
+	return nil
+}
+
+func (b *bot) handlePong() error {
+	if !b.pingSentAt.IsZero() {
+		b.stats.ping.add(b.receivedAt.Sub(b.pingSentAt))
+		b.pingSentAt = time.Time{}
+	}
+	return nil
+}
+
+func (b *bot) handleEnterLobby(enterLobby *fb.S2CEnterLobby) error {
+	b.playerID = int(enterLobby.PlayerId())
+	b.logger = b.logger.With(logging.KeyPlayerID, b.playerID)
+	b.phase = lobby
+
+	builder := flatbuffers.NewBuilder(64)
+	fb.C2SQuickMatchStart(builder)
+	fb.C2SQuickMatchAddSkillRating(builder, 0)
+	fb.C2SQuickMatchAddPartyId(builder, 0)
+	builder.Finish(fb.C2SQuickMatchEnd(builder))
+	return b.send(fb.ClientCommandC2S_COMMAND_QUICKMATCH, builder.FinishedBytes())
+}
+
+func (b *bot) handleEnterRoom(enterRoom *fb.S2CEnterRoom) error {
+	b.playerID = int(enterRoom.PlayerId())
+	b.timeSyncTimes = int(enterRoom.TimeSyncTimes())
+	if interval := enterRoom.SendInputInterval(); interval > 0 {
+		b.sendInputInterval = time.Duration(float64(interval) * float64(time.Second))
+	}
+	if tickRate := enterRoom.TickRate(); tickRate > 0 {
+		b.tickRate = int(tickRate)
+	}
+	b.logger.Debug("Enter room", logging.KeyRoomID, enterRoom.RoomId())
+	b.phase = room
+	b.timeSyncs = 0
+	return b.requestTime(time.Now())
+}
+
+// handleResponseTime 记录往返时间， 进入游戏前校时次数不够时继续请求
+func (b *bot) handleResponseTime(responseTime *fb.S2CResponseTime) error {
+	if b.timeSyncSentAt.IsZero() {
+		return nil
+	}
+	b.stats.timeSync.add(b.receivedAt.Sub(b.timeSyncSentAt))
+	b.clock.AddRoundTrip(b.timeSyncSentAt, b.receivedAt, responseTime.ServerTime())
+	b.timeSyncSentAt = time.Time{}
+	b.timeSyncs++
+	if b.phase == room && b.timeSyncs < b.timeSyncTimes {
+		return b.requestTime(time.Now())
+	}
+	return nil
+}
+
+// handleStartEnterGame 与客户端一样模拟0.5到1.5秒的加载时间
+func (b *bot) handleStartEnterGame() error {
+	b.phase = loading
+	b.loadAt = time.Now().Add(500*time.Millisecond + rand.N(time.Second))
+	return nil
+}
+
+func (b *bot) handleStartGame(startGame *fb.S2CStartGame) error {
+	b.gameStartServerTime = startGame.AppointedServerTime()
+	if b.phase == loading {
+		b.phase = countDown
+	}
+	return nil
+}
+
+func (b *bot) handleWorldSync(worldSync gametypes.WorldSync) error {
+	b.clock.AddOneWay(b.receivedAt, worldSync.ServerTime)
+	b.logicFrame = int(worldSync.LogicFrame)
+	return nil
+}
+
+func (b *bot) handleLatencyProbe(probe *fb.S2CLatencyProbe) error {
+	builder := flatbuffers.NewBuilder(32)
+	fb.C2SLatencyProbeStart(builder)
+	fb.C2SLatencyProbeAddId(builder, probe.Id())
+	builder.Finish(fb.C2SLatencyProbeEnd(builder))
+	return b.send(fb.ClientCommandC2S_COMMAND_LATENCYPROBE, builder.FinishedBytes())
+}
+
+func (b *bot) handleInputDelay(inputDelay *fb.S2CInputDelay) error {
+	b.inputLeadFrames = int(inputDelay.InputDelay())
+	return nil
+}
+
+// handlePlayerInputSync 收到服务端转发的自己的输入时记录转发延迟
+func (b *bot) handlePlayerInputSync(playerInput gametypes.PlayerInput) error {
+	if playerInput.ID != b.playerID {
+		return nil
+	}
+	if playerInput.Substituted {
+		b.stats.inputsSubstituted.Add(1)
+		return nil
+	}
+	if sentAt, ok := b.sentInputs[playerInput.LogicFrame]; ok {
+		b.stats.inputRelay.add(b.receivedAt.Sub(sentAt))
+		delete(b.sentInputs, playerInput.LogicFrame)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// loadtest 启动大量只走协议流程的模拟客户端连接到正在运行的服务器，
+// 结束后报告校时、输入转发与心跳的延迟分位数、连接失败数以及服务端的Tick超时次数
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	clientbackend "gameproject/source/client/backend"
+	"gameproject/source/clock"
+	"gameproject/source/logging"
+	"gameproject/source/netsim"
+	"gameproject/source/transport"
+)
+
+func main() {
+	serverAddr := flag.String("server", clientbackend.DefaultServerAddress, "服务器地址， 省略端口时使用默认端口")
+	transportName := flag.String("transport", transport.KCP, "传输方式： kcp、tcp 或 websocket， 需要与服务器相同")
+	clients := flag.Int("clients", 100, "模拟的客户端数量")
+	duration := flag.Duration("duration", time.Minute, "压测持续的时间， 包括逐步建立连接的时间")
+	ramp := flag.Duration("ramp", 10*time.Second, "在这段时间内均匀地建立连接， 0表示同时连接")
+	metricsAddr := flag.String("metrics", "", "服务器Prometheus指标的地址， 例如 127.0.0.1:9100， 用于统计压测期间的Tick超时")
+	jsonOutput := flag.Bool("json", false, "以JSON格式输出结果")
+	logLevel := flag.String("log-level", "info", "日志级别： debug、info、warn、error")
+	logFormat := flag.String("log-format", "text", "日志格式： text 或 json")
+	netsimSpec := flag.String("netsim", "", "模拟的网络条件： 内置场景名、场景文件路径或 \"latency=100ms loss=0.01\" 这样的条件")
+	netsimSeed := flag.Uint64("netsim-seed", 0, "网络模拟的随机种子， 0表示随机选择")
+	flag.Parse()
+
+	logCloser, err := logging.Setup(logging.Options{Level: *logLevel, Format: *logFormat})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer logCloser.Close()
+
+	if *clients < 1 {
+		fmt.Fprintln(os.Stderr, "-clients must be at least 1")
+		os.Exit(2)
+	}
+	clientTransport, err := transport.New(*transportName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *netsimSpec != "" {
+		clientTransport, err = simulateNetwork(clientTransport, *netsimSpec, *netsimSeed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	// 先确认能读取服务端的指标， 避免压测结束后才发现地址不对
+	var before serverTicks
+	if *metricsAddr != "" {
+		if before, err = scrapeTicks(*metricsAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to read server metrics:", err)
+			os.Exit(2)
+		}
+	}
+
+	addr := serverAddress(*serverAddr)
+	s := &stats{}
+	elapsed := run(clientTransport, addr, *clients, *duration, *ramp, s)
+
+	result := s.report()
+	result.Server = addr
+	result.Transport = clientTransport.Name()
+	result.Clients = *clients
+	result.Duration = elapsed.Seconds()
+	if *metricsAddr != "" {
+		after, err := scrapeTicks(*metricsAddr)
+		if err != nil {
+			slog.Error("Failed to read server metrics", logging.Err(err))
+		} else {
+			ticks, overruns := after.ticks-before.ticks, after.overruns-before.overruns
+			result.Ticks, result.TickOverruns = &ticks, &overruns
+		}
+	}
+
+	if *jsonOutput {
+		err = result.writeJSON(os.Stdout)
+	} else {
+		err = result.writeText(os.Stdout)
+	}
+	if err != nil {
+		slog.Error("Failed to write report", logging.Err(err))
+	}
+	if result.Connected == 0 {
+		logCloser.Close()
+		os.Exit(1)
+	}
+}
+
+// run 在 ramp 内逐步连接 count 个模拟客户端， 运行 duration 或收到 SIGINT / SIGTERM 后全部停止， 返回实际运行的时间
+func run(clientTransport transport.Transport, addr string, count int, duration, ramp time.Duration, s *stats) time.Duration {
+	slog.Info("Starting load test", logging.KeyAddr, addr, "transport", clientTransport.Name(), "clients", count, "duration", duration, "ramp", ramp)
+	start := time.Now()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		delay := ramp * time.Duration(i) / time.Duration(count)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-time.After(delay):
+			case <-stop:
+				return
+			}
+			runBot(clientTransport, addr, i+1, s, stop)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case sig := <-signals:
+		slog.Info("Received signal", "signal", sig.String())
+	case <-time.After(duration):
+	}
+	close(stop)
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	slog.Info("Load test finished", "elapsed", elapsed.Round(time.Millisecond))
+	return elapsed
+}
+
+// runBot 连接并运行一个模拟客户端， 连接失败与中途断开都计入统计
+func runBot(clientTransport transport.Transport, addr string, index int, s *stats, stop <-chan struct{}) {
+	conn, err := clientTransport.Dial(addr)
+	if err != nil {
+		s.connectFailed.Add(1)
+		slog.Warn("Failed to connect", logging.KeyClient, index, logging.Err(err))
+		return
+	}
+	s.connected.Add(1)
+
+	b := newBot(index, conn, s)
+	if err := b.run(stop); err != nil {
+		s.disconnected.Add(1)
+		b.logger.Warn("Client disconnected", logging.Err(err))
+	}
+}
+
+// simulateNetwork 按场景模拟网络条件， 日志中记录随机种子， 用同样的种子可以复现
+func simulateNetwork(inner transport.Transport, spec string, seed uint64) (transport.Transport, error) {
+	scenario, err := netsim.Load(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load network scenario: %w", err)
+	}
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	slog.Info("Simulating network conditions", "scenario", scenario.Name(), "seed", seed)
+	return netsim.Wrap(inner, scenario, clock.Real, seed), nil
+}
+
+// serverAddress 没有指定端口时使用默认端口
+func serverAddress(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	_, port, _ := net.SplitHostPort(clientbackend.DefaultServerAddress)
+	return net.JoinHostPort(addr, port)
+}
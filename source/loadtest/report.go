@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stats 所有模拟客户端共用的统计， 在各个客户端的goroutine中写入
+type stats struct {
+	timeSync   samples // REQUESTTIME 到 RESPONSETIME 的往返时间
+	inputRelay samples // 发出 PLAYERINPUT 到收到服务端转发的同一输入的时间
+	ping       samples // PING 到 PONG 的往返时间
+
+	connected       atomic.Int64 // 连接成功的客户端
+	connectFailed   atomic.Int64 // 连接失败的客户端
+	disconnected    atomic.Int64 // 结束之前连接出错的客户端
+	inGame          atomic.Int64 // 进入游戏、开始发送输入的客户端
+	commandFailures atomic.Int64 // 服务端回复失败的命令
+
+	inputsSent        atomic.Int64
+	inputsSkipped     atomic.Int64 // 到发送时间时还没有收到新的world sync， 帧号与上一次相同而没有发送
+	inputsRejected    atomic.Int64 // 帧号超出范围等原因被服务端拒绝的输入
+	inputsSubstituted atomic.Int64 // 没有按时到达、由服务端代替的输入
+}
+
+// samples 一组延迟样本， 结束后计算分位数
+type samples struct {
+	mu     sync.Mutex
+	values []time.Duration
+}
+
+func (s *samples) add(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = append(s.values, d)
+}
+
+// latencySummary 延迟的分位数， 单位毫秒
+type latencySummary struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+func (s *samples) summary() latencySummary {
+	s.mu.Lock()
+	values := slices.Clone(s.values)
+	s.mu.Unlock()
+	if len(values) == 0 {
+		return latencySummary{}
+	}
+	slices.Sort(values)
+	return latencySummary{
+		Count: len(values),
+		P50:   milliseconds(percentile(values, 0.50)),
+		P90:   milliseconds(percentile(values, 0.90)),
+		P99:   milliseconds(percentile(values, 0.99)),
+		Max:   milliseconds(values[len(values)-1]),
+	}
+}
+
+// percentile 对排好序的样本取最近秩分位数
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	return sorted[max(rank, 0)]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// report 一次压测的结果
+type report struct {
+	Server    string  `json:"server"`
+	Transport string  `json:"transport"`
+	Clients   int     `json:"clients"`
+	Duration  float64 `json:"duration_seconds"`
+
+	Connected       int64 `json:"connected"`
+	ConnectFailures int64 `json:"connect_failures"`
+	Disconnects     int64 `json:"disconnects"`
+	InGame          int64 `json:"in_game"`
+	CommandFailures int64 `json:"command_failures"`
+
+	InputsSent        int64 `json:"inputs_sent"`
+	InputsSkipped     int64 `json:"inputs_skipped"`
+	InputsRejected    int64 `json:"inputs_rejected"`
+	InputsSubstituted int64 `json:"inputs_substituted"`
+
+	TimeSync   latencySummary `json:"time_sync"`
+	InputRelay latencySummary `json:"input_relay"`
+	Ping       latencySummary `json:"ping"`
+
+	// 服务端在压测期间的Tick， 没有指定指标地址时为nil
+	Ticks        *int64 `json:"ticks,omitempty"`
+	TickOverruns *int64 `json:"tick_overruns,omitempty"`
+}
+
+func (s *stats) report() report {
+	return report{
+		Connected:         s.connected.Load(),
+		ConnectFailures:   s.connectFailed.Load(),
+		Disconnects:       s.disconnected.Load(),
+		InGame:            s.inGame.Load(),
+		CommandFailures:   s.commandFailures.Load(),
+		InputsSent:        s.inputsSent.Load(),
+		InputsSkipped:     s.inputsSkipped.Load(),
+		InputsRejected:    s.inputsRejected.Load(),
+		InputsSubstituted: s.inputsSubstituted.Load(),
+		TimeSync:          s.timeSync.summary(),
+		InputRelay:        s.inputRelay.summary(),
+		Ping:              s.ping.summary(),
+	}
+}
+
+func (r report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r report) writeText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Load test against %s (%s), %d clients for %v\n", r.Server, r.Transport, r.Clients, time.Duration(r.Duration*float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintf(&b, "\nConnections\n")
+	fmt.Fprintf(&b, "  connected          %d\n", r.Connected)
+	fmt.Fprintf(&b, "  connect failures   %d\n", r.ConnectFailures)
+	fmt.Fprintf(&b, "  disconnects        %d\n", r.Disconnects)
+	fmt.Fprintf(&b, "  in game            %d\n", r.InGame)
+	fmt.Fprintf(&b, "  command failures   %d\n", r.CommandFailures)
+	fmt.Fprintf(&b, "\nInputs\n")
+	fmt.Fprintf(&b, "  sent               %d\n", r.InputsSent)
+	fmt.Fprintf(&b, "  skipped            %d\n", r.InputsSkipped)
+	fmt.Fprintf(&b, "  rejected           %d\n", r.InputsRejected)
+	fmt.Fprintf(&b, "  substituted        %d\n", r.InputsSubstituted)
+	fmt.Fprintf(&b, "\nLatency (ms)        %8s %8s %8s %8s %8s\n", "count", "p50", "p90", "p99", "max")
+	for _, row := range []struct {
+		name    string
+		summary latencySummary
+	}{
+		{"time sync", r.TimeSync},
+		{"input relay", r.InputRelay},
+		{"ping", r.Ping},
+	} {
+		s := row.summary
+		fmt.Fprintf(&b, "  %-17s %8d %8.1f %8.1f %8.1f %8.1f\n", row.name, s.Count, s.P50, s.P90, s.P99, s.Max)
+	}
+	if r.TickOverruns != nil {
+		fmt.Fprintf(&b, "\nServer\n")
+		fmt.Fprintf(&b, "  ticks              %d\n", *r.Ticks)
+		fmt.Fprintf(&b, "  tick overruns      %d\n", *r.TickOverruns)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// 从服务端指标中读取的Tick计数
+const (
+	metricTicks        = "gameserver_tick_duration_seconds_count"
+	metricTickOverruns = "gameserver_tick_overruns_total"
+)
+
+// serverTicks 服务端累计的Tick数与超时的Tick数， 压测前后各抓取一次， 差值即为压测期间的数据
+type serverTicks struct {
+	ticks    int64
+	overruns int64
+}
+
+// scrapeTicks 抓取服务端的Prometheus指标， addr 为 host:port 或完整的URL
+func scrapeTicks(addr string) (serverTicks, error) {
+	url := addr
+	if !strings.Contains(url, "://") {
+		url = "http://" + addr + "/metrics"
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return serverTicks{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return serverTicks{}, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	values, err := parseMetrics(resp.Body, metricTicks, metricTickOverruns)
+	if err != nil {
+		return serverTicks{}, fmt.Errorf("%s: %w", url, err)
+	}
+	return serverTicks{ticks: int64(values[metricTicks]), overruns: int64(values[metricTickOverruns])}, nil
+}
+
+// parseMetrics 从Prometheus文本格式中读取没有标签的样本， names 中的指标都必须出现
+func parseMetrics(r io.Reader, names ...string) (map[string]float64, error) {
+	values := make(map[string]float64, len(names))
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || !slices.Contains(names, name) {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %q", name, value)
+		}
+		values[name] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("metric %s not found, is the server up to date?", name)
+		}
+	}
+	return values, nil
+}
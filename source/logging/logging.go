@@ -0,0 +1,156 @@
+// Package logging 基于 log/slog 的结构化日志
+//
+// Setup 根据配置创建日志： 输出到标准错误（文本或JSON）、按大小轮转的文件， 以及可选的额外Handler（例如GUI），
+// 并设置为 slog 与标准库 log 的默认输出。 各个模块通过 slog.Default().With(...) 带上 room_id、player_id 等字段。
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// 日志格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// 常用的字段名， 各个模块使用相同的名字便于检索
+const (
+	KeyRoomID     = "room_id"
+	KeyPlayerID   = "player_id"
+	KeyLogicFrame = "logic_frame"
+	KeyCommand    = "command"
+	KeyClient     = "client"
+	KeyAddr       = "addr"
+	KeyError      = "err"
+)
+
+// Options 日志配置
+type Options struct {
+	Level      string // debug、info、warn、error， 为空时为info
+	Format     string // text 或 json， 为空时为text
+	File       string // 同时写入的文件， 为空时只输出到标准错误
+	MaxSizeMB  int    // 文件超过该大小时轮转， 0表示不轮转
+	MaxBackups int    // 保留的旧文件数
+}
+
+// ParseLevel 解析日志级别， 为空时返回info
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", level)
+	}
+	return l, nil
+}
+
+// Validate 检查级别与格式是否合法
+func (o Options) Validate() error {
+	if _, err := ParseLevel(o.Level); err != nil {
+		return err
+	}
+	switch strings.ToLower(o.Format) {
+	case "", FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q", o.Format)
+	}
+	if o.MaxSizeMB < 0 || o.MaxBackups < 0 {
+		return errors.New("log file size and backups must not be negative")
+	}
+	return nil
+}
+
+// Setup 创建日志并设置为默认日志， extra 中的Handler自行决定输出的级别
+// 返回的Closer关闭日志文件， 没有文件时为空操作
+func Setup(options Options, extra ...slog.Handler) (io.Closer, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	level, _ := ParseLevel(options.Level)
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	handlers := []slog.Handler{newHandler(os.Stderr, options.Format, handlerOptions)}
+	var closer io.Closer = nopCloser{}
+	if options.File != "" {
+		file, err := OpenRotatingFile(options.File, int64(options.MaxSizeMB)<<20, options.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		// 文件总是使用JSON， 便于日志收集工具解析
+		handlers = append(handlers, slog.NewJSONHandler(file, handlerOptions))
+		closer = file
+	}
+	handlers = append(handlers, extra...)
+
+	slog.SetDefault(slog.New(Fanout(handlers...)))
+	return closer, nil
+}
+
+func newHandler(w io.Writer, format string, options *slog.HandlerOptions) slog.Handler {
+	if strings.ToLower(format) == FormatJSON {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// Err 错误字段， 错误为nil时仍然输出该字段
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// fanout 把每条记录交给所有启用了该级别的Handler
+type fanout []slog.Handler
+
+// Fanout 把日志同时写入多个Handler
+func Fanout(handlers ...slog.Handler) slog.Handler {
+	return fanout(handlers)
+}
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, record.Level) {
+			if err := h.Handle(ctx, record.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile 按大小轮转的日志文件， 超过大小时把 name 重命名为 name.1， 原来的 name.1 变为 name.2，
+// 以此类推， 超过 maxBackups 的旧文件被删除， 可以在多个goroutine中写入
+type RotatingFile struct {
+	name       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile 以追加方式打开日志文件， maxSize 为0时不轮转
+func OpenRotatingFile(name string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{name: name, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// 轮转失败时继续写入原来的文件， 不丢失日志
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if f.maxBackups > 0 {
+		os.Remove(backupName(f.name, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupName(f.name, i), backupName(f.name, i+1))
+		}
+		if err := os.Rename(f.name, backupName(f.name, 1)); err != nil {
+			f.open()
+			return err
+		}
+	} else if err := os.Remove(f.name); err != nil {
+		f.open()
+		return err
+	}
+	return f.open()
+}
+
+func backupName(name string, index int) string {
+	return fmt.Sprintf("%s.%d", name, index)
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
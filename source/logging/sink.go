@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 格式化后的一条日志， 供GUI等显示
+type Entry struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   string // key=value 形式的字段
+}
+
+func (e Entry) String() string {
+	line := e.Time.Format("15:04:05.000") + " " + e.Level.String() + " " + e.Message
+	if e.Attrs != "" {
+		line += " " + e.Attrs
+	}
+	return line
+}
+
+// Buffer 保存最近的若干条日志， 超出容量时丢弃最早的日志， 可以在多个goroutine中使用
+// 作为 slog.Handler 接收所有级别的日志， 显示时再按级别过滤
+type Buffer struct {
+	mu       sync.Mutex
+	entries  []Entry // 环形缓冲区
+	next     int
+	count    int
+	dirty    bool
+	onChange func()
+}
+
+// NewBuffer 创建保存最近 capacity 条日志的缓冲区
+func NewBuffer(capacity int) *Buffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Buffer{entries: make([]Entry, capacity)}
+}
+
+// SetOnChange 设置有新日志时的回调， 回调在写日志的goroutine中执行， 不应阻塞
+func (b *Buffer) SetOnChange(callback func()) {
+	b.mu.Lock()
+	b.onChange = callback
+	b.mu.Unlock()
+}
+
+func (b *Buffer) add(entry Entry) {
+	b.mu.Lock()
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.count < len(b.entries) {
+		b.count++
+	}
+	b.dirty = true
+	callback := b.onChange
+	b.mu.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+// Entries 返回级别不低于 level 的日志， 按时间顺序
+func (b *Buffer) Entries(level slog.Level) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dirty = false
+	entries := make([]Entry, 0, b.count)
+	start := (b.next - b.count + len(b.entries)) % len(b.entries)
+	for i := 0; i < b.count; i++ {
+		entry := b.entries[(start+i)%len(b.entries)]
+		if entry.Level >= level {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Dirty 上次调用 Entries 之后是否有新日志
+func (b *Buffer) Dirty() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dirty
+}
+
+// Handler 返回写入该缓冲区的 slog.Handler
+func (b *Buffer) Handler() slog.Handler {
+	return &bufferHandler{buffer: b}
+}
+
+type bufferHandler struct {
+	buffer *Buffer
+	attrs  []string // WithAttrs 添加的字段， 已格式化
+	group  string
+}
+
+func (h *bufferHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *bufferHandler) Handle(_ context.Context, record slog.Record) error {
+	attrs := slices.Clone(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = appendAttr(attrs, h.group, attr)
+		return true
+	})
+	h.buffer.add(Entry{
+		Time:    record.Time,
+		Level:   record.Level,
+		Message: record.Message,
+		Attrs:   strings.Join(attrs, " "),
+	})
+	return nil
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = slices.Clone(h.attrs)
+	for _, attr := range attrs {
+		clone.attrs = appendAttr(clone.attrs, h.group, attr)
+	}
+	return &clone
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		clone.group += "."
+	}
+	clone.group += name
+	return &clone
+}
+
+func appendAttr(attrs []string, group string, attr slog.Attr) []string {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return attrs
+	}
+	key := attr.Key
+	if group != "" {
+		key = group + "." + key
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, child := range attr.Value.Group() {
+			attrs = appendAttr(attrs, key, child)
+		}
+		return attrs
+	}
+	return append(attrs, key+"="+attr.Value.String())
+}
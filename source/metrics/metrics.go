@@ -0,0 +1,241 @@
+// Package metrics 以Prometheus的文本格式（text exposition format 0.0.4）输出指标
+//
+// 只实现服务器需要的部分： 计数器、仪表与固定分桶的直方图。 指标的值由调用方在每次抓取时
+// 通过 Writer 写出， 不维护全局的注册表。
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gameproject/source/logging"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指标的类型
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// Labels 一个样本的标签
+type Labels map[string]string
+
+// Writer 按文本格式写出指标， 同一个指标的所有样本需要在一次 Family 之后连续写出
+type Writer struct {
+	w      *bufio.Writer
+	family string
+	err    error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family 开始一个指标， 写出 HELP 与 TYPE
+func (w *Writer) Family(name, kind, help string) {
+	w.family = name
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+// Sample 写出当前指标的一个样本
+func (w *Writer) Sample(value float64, labels Labels) {
+	w.sample(w.family, labels, value)
+}
+
+// Counter 写出只有一个样本的计数器
+func (w *Writer) Counter(name, help string, value float64) {
+	w.Family(name, Counter, help)
+	w.Sample(value, nil)
+}
+
+// Gauge 写出只有一个样本的仪表
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.Family(name, Gauge, help)
+	w.Sample(value, nil)
+}
+
+// Histogram 写出直方图， 桶的计数为累计值
+func (w *Writer) Histogram(name, help string, snapshot HistogramSnapshot) {
+	w.Family(name, "histogram", help)
+	var cumulative uint64
+	for i, bound := range snapshot.Bounds {
+		cumulative += snapshot.Counts[i]
+		w.sample(name+"_bucket", Labels{"le": formatFloat(bound)}, float64(cumulative))
+	}
+	w.sample(name+"_bucket", Labels{"le": "+Inf"}, float64(snapshot.Count))
+	w.sample(name+"_sum", nil, snapshot.Sum)
+	w.sample(name+"_count", nil, float64(snapshot.Count))
+}
+
+// Flush 写出缓冲的内容， 返回写出过程中的第一个错误
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+func (w *Writer) sample(name string, labels Labels, value float64) {
+	if len(labels) == 0 {
+		w.printf("%s %s\n", name, formatFloat(value))
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+`="`+escapeLabel(labels[key])+`"`)
+	}
+	w.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Histogram 固定分桶的直方图， 可以在任意goroutine中记录与读取
+type Histogram struct {
+	bounds []float64 // 各个桶的上界， 升序
+
+	mu     sync.Mutex
+	counts []uint64 // 落在 (bounds[i-1], bounds[i]] 的样本数， 最后一个为超出所有上界的样本数
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram 使用升序的桶上界创建直方图
+func NewHistogram(bounds ...float64) *Histogram {
+	if !sort.Float64sAreSorted(bounds) {
+		panic("metrics: histogram bounds must be sorted")
+	}
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	index := sort.SearchFloat64s(h.bounds, value)
+	h.mu.Lock()
+	h.counts[index]++
+	h.sum += value
+	h.count++
+	h.mu.Unlock()
+}
+
+// HistogramSnapshot 直方图的副本， Counts 与 Bounds 一一对应， 不是累计值
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []uint64
+	Sum    float64
+	Count  uint64
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: append([]uint64(nil), h.counts[:len(h.bounds)]...),
+		Sum:    h.sum,
+		Count:  h.count,
+	}
+}
+
+// Handler 每次抓取时调用collect写出所有指标， collect返回错误时回复503
+func Handler(collect func(w *Writer) error) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		err := collect(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			slog.Error("Failed to collect metrics", logging.Err(err))
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		rw.Write(buf.Bytes())
+	})
+}
+
+// Server 在 /metrics 上提供指标的HTTP服务
+type Server struct {
+	http *http.Server
+}
+
+func NewServer(addr string, collect func(w *Writer) error) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler(collect))
+	return &Server{http: &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}}
+}
+
+// Start 开始监听， 端口被占用等错误直接返回， 之后在后台处理请求
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Metrics listening", logging.KeyAddr, listener.Addr().String(), "path", "/metrics")
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", logging.Err(err))
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.http.Shutdown(ctx)
+}
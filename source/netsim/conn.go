@@ -0,0 +1,333 @@
+package netsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gameproject/source/clock"
+	"gameproject/source/framing"
+	"gameproject/source/transport"
+	"math"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// conn 两个方向各有一个link： 发出的消息经过 out 写入底层连接，
+// 从底层连接读到的消息经过 in 放入 buffer， 由 Read 取出
+type conn struct {
+	transport.Conn
+	owner *Transport
+	out   *link
+	in    *link
+
+	buffer    readBuffer
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	writeErr error // 底层连接写入失败后， 之后的 Write 都返回该错误
+}
+
+// Write 每次调用是一条完整的消息， 按条件延迟后写入底层连接
+func (c *conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.mu.Lock()
+	err := c.writeErr
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	c.out.send(append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.buffer.read(b)
+}
+
+// SetReadDeadline 只作用于 Read， 底层连接一直在读取， 不设置超时
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.buffer.setDeadline(t)
+	return nil
+}
+
+func (c *conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.owner.remove(c)
+		c.buffer.fail(net.ErrClosed)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// readLoop 从底层连接读取完整的消息交给 in， 出错时错误排在已读到的消息之后
+func (c *conn) readLoop() {
+	// 消息长度由调用方的Reader检查， 这里不限制
+	reader := framing.NewReader(c.Conn, math.MaxInt32)
+	for {
+		payload, err := reader.ReadFrame()
+		if err != nil {
+			c.in.finish(err)
+			return
+		}
+		frame := make([]byte, framing.HeaderSize+len(payload))
+		binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+		copy(frame[framing.HeaderSize:], payload)
+		c.in.send(frame)
+	}
+}
+
+func (c *conn) deliverOut(data []byte, err error) bool {
+	if err == nil {
+		_, err = c.Conn.Write(data)
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.writeErr = err
+		c.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+func (c *conn) deliverIn(data []byte, err error) bool {
+	if err != nil {
+		c.buffer.fail(err)
+		return false
+	}
+	c.buffer.push(data)
+	return true
+}
+
+// packet 一条等待送达的消息， err 不为nil时表示连接在此之后出错
+type packet struct {
+	at   time.Time
+	data []byte
+	err  error
+}
+
+// link 一个方向上的消息队列， 按送达时间交给 deliver
+type link struct {
+	clock      clock.Clock
+	rng        *rand.Rand
+	done       <-chan struct{}
+	conditions func() Conditions
+	deliver    func(data []byte, err error) bool // 返回false时停止
+
+	mu         sync.Mutex
+	queue      []packet  // 按送达时间排序， 同时送达的按加入的顺序
+	lastAt     time.Time // 不乱序时消息不早于之前的消息到达
+	busyUntil  time.Time // 带宽限制下之前的消息发送完的时间
+	delivering int       // 已取出、正在交给 deliver 的消息数
+	wake       chan struct{}
+}
+
+func newLink(c clock.Clock, rng *rand.Rand, done <-chan struct{}, conditions func() Conditions, deliver func([]byte, error) bool) *link {
+	return &link{
+		clock:      c,
+		rng:        rng,
+		done:       done,
+		conditions: conditions,
+		deliver:    deliver,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// send 按当前条件决定消息是否丢弃、是否重复以及送达的时间
+func (l *link) send(data []byte) {
+	conditions := l.conditions()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	departure := l.clock.Now()
+	if conditions.Bandwidth > 0 {
+		if l.busyUntil.After(departure) {
+			departure = l.busyUntil
+		}
+		departure = departure.Add(time.Duration(len(data)) * time.Second / time.Duration(conditions.Bandwidth))
+		l.busyUntil = departure
+	}
+
+	if l.rng.Float64() < conditions.Loss {
+		return
+	}
+	copies := 1
+	if l.rng.Float64() < conditions.Duplicate {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		delay := conditions.Latency
+		if conditions.Jitter > 0 {
+			delay += time.Duration(l.rng.Int64N(int64(2*conditions.Jitter)+1)) - conditions.Jitter
+		}
+		at := departure.Add(max(delay, 0))
+		if l.rng.Float64() >= conditions.Reorder && at.Before(l.lastAt) {
+			at = l.lastAt
+		}
+		l.pushLocked(at, data, nil)
+	}
+}
+
+// finish 连接出错， 错误在之前的消息都送达后交给 deliver
+func (l *link) finish(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at := l.clock.Now()
+	if at.Before(l.lastAt) {
+		at = l.lastAt
+	}
+	l.pushLocked(at, nil, err)
+}
+
+func (l *link) pushLocked(at time.Time, data []byte, err error) {
+	if at.After(l.lastAt) {
+		l.lastAt = at
+	}
+	p := packet{at: at, data: data, err: err}
+	i := sort.Search(len(l.queue), func(i int) bool {
+		return l.queue[i].at.After(at)
+	})
+	l.queue = append(l.queue, packet{})
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = p
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// overdue 已到送达时间、还没有交给 deliver 的消息数
+func (l *link) overdue(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.delivering
+	for _, p := range l.queue {
+		if p.at.After(now) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func (l *link) run() {
+	for {
+		l.mu.Lock()
+		now := l.clock.Now()
+		n := 0
+		for n < len(l.queue) && !l.queue[n].at.After(now) {
+			n++
+		}
+		due := l.queue[:n:n]
+		l.queue = l.queue[n:]
+		l.delivering = n
+		wait := time.Duration(-1)
+		if len(l.queue) > 0 {
+			wait = l.queue[0].at.Sub(now)
+		}
+		l.mu.Unlock()
+
+		for _, p := range due {
+			ok := l.deliver(p.data, p.err)
+			l.mu.Lock()
+			l.delivering--
+			l.mu.Unlock()
+			if !ok {
+				return
+			}
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = l.clock.After(wait)
+		}
+		select {
+		case <-timer:
+		case <-l.wake:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// readBuffer 已送达、等待 Read 取出的数据， 读超时使用真实时间， 与网络连接一致
+type readBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	err      error
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func (b *readBuffer) init() {
+	b.cond = sync.NewCond(&b.mu)
+}
+
+func (b *readBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && b.err == nil {
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, b.err
+	}
+	return b.buf.Read(p)
+}
+
+func (b *readBuffer) push(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(data)
+	b.cond.Broadcast()
+}
+
+// fail 剩余的数据读完后返回 err， 只记录第一个错误
+func (b *readBuffer) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.cond.Broadcast()
+}
+
+func (b *readBuffer) setDeadline(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadline = t
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if !t.IsZero() {
+		b.timer = time.AfterFunc(time.Until(t), func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+	}
+	b.cond.Broadcast()
+}
+
+var _ transport.Conn = (*conn)(nil)
@@ -0,0 +1,173 @@
+// Package netsim 在客户端与服务器之间的连接上模拟不理想的网络
+//
+// 模拟以消息为单位： 每次Write是一条带长度前缀的消息（framing.WriteFrame 保证这一点），
+// 读取时先按长度前缀切分出完整的消息， 再按条件延迟后交给调用方。
+// 底层的KCP、TCP与WebSocket都是可靠的， 这里的丢失与重复针对整条消息， 相当于应用层的消息丢失与重发，
+// 比真实网络中由重传带来的延迟更严重， 用于检验游戏逻辑在最坏情况下的表现：
+// 客户端需要丢弃重复收到的消息， 服务端按帧号拒绝重复的输入。
+//
+// 方向以包装连接的一端为准： 客户端的 send 是发往服务器的消息， 服务器的 send 是发往客户端的消息。
+package netsim
+
+import (
+	"fmt"
+	"gameproject/source/clock"
+	"gameproject/source/transport"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conditions 一个方向上的网络条件， 零值表示不模拟
+type Conditions struct {
+	Latency   time.Duration // 固定的单向延迟
+	Jitter    time.Duration // 延迟在 [-Jitter, +Jitter] 内均匀波动
+	Loss      float64       // 丢弃消息的概率
+	Duplicate float64       // 消息被发送两次的概率
+	Reorder   float64       // 消息不等待之前的消息、可以先于它们到达的概率
+	Bandwidth int           // 每秒最多发送的字节数， 0表示不限
+}
+
+func (c Conditions) String() string {
+	var parts []string
+	if c.Latency > 0 {
+		parts = append(parts, "latency="+c.Latency.String())
+	}
+	if c.Jitter > 0 {
+		parts = append(parts, "jitter="+c.Jitter.String())
+	}
+	if c.Loss > 0 {
+		parts = append(parts, fmt.Sprintf("loss=%g", c.Loss))
+	}
+	if c.Duplicate > 0 {
+		parts = append(parts, fmt.Sprintf("duplicate=%g", c.Duplicate))
+	}
+	if c.Reorder > 0 {
+		parts = append(parts, fmt.Sprintf("reorder=%g", c.Reorder))
+	}
+	if c.Bandwidth > 0 {
+		parts = append(parts, fmt.Sprintf("bandwidth=%d", c.Bandwidth))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Transport 按场景模拟网络条件的传输方式， 包装另一个传输方式
+// 场景的时间从调用 Wrap 时开始， 所有连接共用同一条时间线
+type Transport struct {
+	inner    transport.Transport
+	scenario *Scenario
+	clock    clock.Clock
+	start    time.Time
+	seed     uint64
+
+	mu       sync.Mutex
+	nextConn uint64
+	conns    map[*conn]struct{}
+}
+
+// Wrap 使用 scenario 包装 inner， 相同的 seed 对同样的消息序列做出相同的随机选择
+func Wrap(inner transport.Transport, scenario *Scenario, c clock.Clock, seed uint64) *Transport {
+	return &Transport{
+		inner:    inner,
+		scenario: scenario,
+		clock:    c,
+		start:    c.Now(),
+		seed:     seed,
+		conns:    make(map[*conn]struct{}),
+	}
+}
+
+// Name 返回被包装的传输方式的名字， 对端需要使用相同的传输方式
+func (t *Transport) Name() string {
+	return t.inner.Name()
+}
+
+func (t *Transport) Listen(addr string) (transport.Listener, error) {
+	l, err := t.inner.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{Listener: l, owner: t}, nil
+}
+
+func (t *Transport) Dial(addr string) (transport.Conn, error) {
+	c, err := t.inner.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return t.wrap(c), nil
+}
+
+// Scenario 返回使用的场景
+func (t *Transport) Scenario() *Scenario {
+	return t.scenario
+}
+
+// Conditions 返回当前两个方向的条件
+func (t *Transport) Conditions() (send, receive Conditions) {
+	return t.scenario.At(t.clock.Since(t.start))
+}
+
+// Overdue 已到达时间、尚未交给对端或调用方的消息数， 为0时模拟中没有应当已经送达的消息
+func (t *Transport) Overdue() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock.Now()
+	n := 0
+	for c := range t.conns {
+		n += c.out.overdue(now) + c.in.overdue(now)
+	}
+	return n
+}
+
+func (t *Transport) wrap(inner transport.Conn) *conn {
+	t.mu.Lock()
+	id := t.nextConn
+	t.nextConn++
+	t.mu.Unlock()
+
+	c := &conn{Conn: inner, owner: t, done: make(chan struct{})}
+	c.buffer.init()
+	c.out = newLink(t.clock, rand.New(rand.NewPCG(t.seed, 2*id)), c.done, func() Conditions {
+		send, _ := t.Conditions()
+		return send
+	}, c.deliverOut)
+	c.in = newLink(t.clock, rand.New(rand.NewPCG(t.seed, 2*id+1)), c.done, func() Conditions {
+		_, receive := t.Conditions()
+		return receive
+	}, c.deliverIn)
+
+	t.mu.Lock()
+	t.conns[c] = struct{}{}
+	t.mu.Unlock()
+
+	go c.out.run()
+	go c.in.run()
+	go c.readLoop()
+	return c
+}
+
+func (t *Transport) remove(c *conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c)
+}
+
+type listener struct {
+	transport.Listener
+	owner *Transport
+}
+
+func (l *listener) Accept() (transport.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.owner.wrap(c), nil
+}
+
+var _ transport.Transport = (*Transport)(nil)
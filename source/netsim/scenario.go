@@ -0,0 +1,255 @@
+package netsim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 内置场景， 可以直接通过名字使用
+var builtinScenarios = map[string]string{
+	"none": "",
+	"broadband": `
+0s both latency=20ms jitter=5ms
+`,
+	"mobile": `
+0s both latency=60ms jitter=30ms bandwidth=256k
+`,
+	// 每40秒出现一次3秒的延迟尖峰， 期间的消息按顺序在尖峰结束后陆续到达
+	"lag-spikes": `
+0s  both latency=40ms jitter=10ms
+20s both latency=800ms
+23s both latency=40ms
+40s repeat
+`,
+	// 本端发出的消息每30秒有5秒要延迟5秒才到达， 模拟客户端卡顿或上行中断
+	"stall": `
+0s  both latency=30ms jitter=5ms
+10s send latency=5s jitter=0s
+15s send latency=30ms jitter=5ms
+30s repeat
+`,
+	// 丢失、重复与乱序的消息， 用于检验游戏逻辑的容错
+	"lossy": `
+0s both latency=50ms jitter=20ms loss=0.02 duplicate=0.01 reorder=0.02
+`,
+}
+
+// Builtins 返回内置场景的名字
+func Builtins() []string {
+	names := make([]string, 0, len(builtinScenarios))
+	for name := range builtinScenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Step 场景中的一个时间点， 从 At 开始使用这里的条件， 直到下一个时间点
+type Step struct {
+	At      time.Duration
+	Send    Conditions
+	Receive Conditions
+}
+
+// Scenario 网络条件随时间的变化
+type Scenario struct {
+	name   string
+	steps  []Step
+	period time.Duration // 大于0时按该周期重复
+}
+
+// Constant 在整个过程中使用固定的条件
+func Constant(name string, send, receive Conditions) *Scenario {
+	return &Scenario{name: name, steps: []Step{{Send: send, Receive: receive}}}
+}
+
+// Load 按名字加载内置场景； 包含 "=" 时作为单行条件， 例如 "latency=100ms loss=0.01"， 同时用于两个方向；
+// 否则作为文件路径读取
+func Load(spec string) (*Scenario, error) {
+	if source, ok := builtinScenarios[spec]; ok {
+		return Parse(spec, strings.NewReader(source))
+	}
+	if strings.Contains(spec, "=") {
+		return Parse(spec, strings.NewReader("0s both "+spec))
+	}
+	file, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(spec, file)
+}
+
+// Parse 解析场景， 出错时返回带行号的错误
+//
+// 每行一个时间点， # 之后为注释：
+//
+//	10s send latency=200ms loss=0.05   从第10秒起修改本端发出的消息的条件
+//	20s receive reset                  从第20秒起不再模拟本端收到的消息
+//	30s both jitter=0s                 同时修改两个方向
+//	60s repeat                         从头重新开始， 必须是最后一行
+//
+// 条件有 latency、jitter、loss、duplicate、reorder 与 bandwidth（每秒字节数， 可以使用k、m后缀），
+// 没有出现的条件沿用之前的值。 时间从建立模拟的传输方式时开始计算， 不能倒退。
+func Parse(name string, r io.Reader) (*Scenario, error) {
+	scenario := &Scenario{name: name}
+	var send, receive Conditions
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", name, line, fmt.Sprintf(format, args...))
+		}
+		if scenario.period > 0 {
+			return nil, fail("repeat must be the last line")
+		}
+		at, err := time.ParseDuration(fields[0])
+		if err != nil || at < 0 {
+			return nil, fail("invalid time %q", fields[0])
+		}
+		if n := len(scenario.steps); n > 0 && at < scenario.steps[n-1].At {
+			return nil, fail("time %v is before the previous line", at)
+		}
+		if len(fields) < 2 {
+			return nil, fail("usage: <time> send|receive|both <condition>... or <time> repeat")
+		}
+
+		if fields[1] == "repeat" {
+			if len(fields) != 2 {
+				return nil, fail("usage: <time> repeat")
+			}
+			if at == 0 {
+				return nil, fail("repeat period must be positive")
+			}
+			scenario.period = at
+			continue
+		}
+
+		var targets []*Conditions
+		switch fields[1] {
+		case "send":
+			targets = []*Conditions{&send}
+		case "receive":
+			targets = []*Conditions{&receive}
+		case "both":
+			targets = []*Conditions{&send, &receive}
+		default:
+			return nil, fail("unknown direction %q, want send, receive or both", fields[1])
+		}
+		for _, target := range targets {
+			if err := target.apply(fields[2:]); err != nil {
+				return nil, fail("%v", err)
+			}
+		}
+		scenario.steps = append(scenario.steps, Step{At: at, Send: send, Receive: receive})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+func (s *Scenario) Name() string {
+	return s.name
+}
+
+// At 返回开始后经过 elapsed 时两个方向的条件
+func (s *Scenario) At(elapsed time.Duration) (send, receive Conditions) {
+	if s.period > 0 {
+		elapsed %= s.period
+	}
+	for _, step := range s.steps {
+		if step.At > elapsed {
+			break
+		}
+		send, receive = step.Send, step.Receive
+	}
+	return send, receive
+}
+
+// apply 按 key=value 修改条件， reset 恢复为不模拟
+func (c *Conditions) apply(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing conditions")
+	}
+	for _, arg := range args {
+		if arg == "reset" {
+			*c = Conditions{}
+			continue
+		}
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid condition %q, want key=value", arg)
+		}
+		var err error
+		switch key {
+		case "latency":
+			c.Latency, err = parseDuration(value)
+		case "jitter":
+			c.Jitter, err = parseDuration(value)
+		case "loss":
+			c.Loss, err = parseProbability(value)
+		case "duplicate":
+			c.Duplicate, err = parseProbability(value)
+		case "reorder":
+			c.Reorder, err = parseProbability(value)
+		case "bandwidth":
+			c.Bandwidth, err = parseBandwidth(value)
+		default:
+			return fmt.Errorf("unknown condition %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+func parseProbability(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, fmt.Errorf("must be a number in 0..1, got %q", value)
+	}
+	return p, nil
+}
+
+// parseBandwidth 解析每秒字节数， k 与 m 后缀分别表示1024与1024*1024
+func parseBandwidth(value string) (int, error) {
+	multiplier := 1
+	number := strings.ToLower(value)
+	switch {
+	case strings.HasSuffix(number, "k"):
+		multiplier, number = 1<<10, strings.TrimSuffix(number, "k")
+	case strings.HasSuffix(number, "m"):
+		multiplier, number = 1<<20, strings.TrimSuffix(number, "m")
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", value)
+	}
+	return n * multiplier, nil
+}
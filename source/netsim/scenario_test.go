@@ -0,0 +1,70 @@
+package netsim
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScenario(t *testing.T) {
+	scenario, err := Parse("test", strings.NewReader(`
+# 注释与空行被忽略
+0s  both latency=50ms jitter=10ms
+10s send loss=0.5 bandwidth=64k
+20s receive reset
+30s repeat
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at            time.Duration
+		send, receive Conditions
+	}{
+		{0, Conditions{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}, Conditions{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}},
+		{15 * time.Second, Conditions{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.5, Bandwidth: 64 << 10}, Conditions{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}},
+		{25 * time.Second, Conditions{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.5, Bandwidth: 64 << 10}, Conditions{}},
+		// 30秒后从头开始
+		{35 * time.Second, Conditions{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}, Conditions{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}},
+	}
+	for _, test := range tests {
+		send, receive := scenario.At(test.at)
+		if send != test.send || receive != test.receive {
+			t.Errorf("At(%v) = %v / %v, want %v / %v", test.at, send, receive, test.send, test.receive)
+		}
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown direction":  "0s up latency=1ms",
+		"unknown condition":  "0s both delay=1ms",
+		"invalid loss":       "0s both loss=2",
+		"time goes backward": "10s both latency=1ms\n5s both latency=2ms",
+		"line after repeat":  "10s repeat\n20s both latency=1ms",
+		"missing conditions": "0s both",
+	}
+	for name, source := range tests {
+		if _, err := Parse(name, strings.NewReader(source)); err == nil {
+			t.Errorf("%s: expected an error for %q", name, source)
+		}
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	for _, name := range Builtins() {
+		if _, err := Load(name); err != nil {
+			t.Errorf("builtin scenario %s: %v", name, err)
+		}
+	}
+
+	scenario, err := Load("latency=100ms loss=0.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Conditions{Latency: 100 * time.Millisecond, Loss: 0.01}
+	if send, receive := scenario.At(time.Hour); send != want || receive != want {
+		t.Errorf("inline conditions = %v / %v, want %v for both directions", send, receive, want)
+	}
+}
@@ -0,0 +1,250 @@
+// Package replay 比赛录像的文件格式
+//
+// 文件由文件头与若干条记录组成：
+//
+//	文件头: magic "GSRP" | version uint16 | 录制开始时间 int64 (Unix毫秒)
+//	记录:   类型 byte | 距上一条记录的毫秒数 uvarint | 数据长度 uvarint | 数据
+//
+// 整数均为大端序。 RecordMessage 的数据为服务端下发的完整 S2CCommand，
+// RecordChecksum 的数据为服务端在某个逻辑帧的状态哈希， 用于离线复现不同步。
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	Magic   = "GSRP"
+	Version = 1
+)
+
+// 单条记录的最大长度， 防止损坏的文件导致分配过大的内存
+const maxRecordSize = 1 << 20
+
+type RecordKind byte
+
+const (
+	RecordMessage  RecordKind = 1 // 服务端下发的 S2CCommand
+	RecordChecksum RecordKind = 2 // 服务端权威状态的哈希
+)
+
+var (
+	ErrBadMagic           = errors.New("replay: not a replay file")
+	ErrUnsupportedVersion = errors.New("replay: unsupported version")
+)
+
+// Record 录像中的一条记录， Elapsed 为距离录制开始的时间
+type Record struct {
+	Kind    RecordKind
+	Elapsed time.Duration
+	Data    []byte
+}
+
+// Recorder 按时间顺序写入录像记录， 不是并发安全的
+type Recorder struct {
+	w       *bufio.Writer
+	closer  io.Closer
+	start   time.Time
+	lastMs  int64 // 上一条记录距离录制开始的毫秒数
+	scratch [binary.MaxVarintLen64]byte
+	now     func() time.Time
+}
+
+// NewRecorder 写入文件头并返回Recorder， w 实现了 io.Closer 时 Close 会一并关闭
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	r := &Recorder{
+		w:   bufio.NewWriter(w),
+		now: time.Now,
+	}
+	if closer, ok := w.(io.Closer); ok {
+		r.closer = closer
+	}
+	r.start = r.now()
+
+	header := make([]byte, 0, len(Magic)+2+8)
+	header = append(header, Magic...)
+	header = binary.BigEndian.AppendUint16(header, Version)
+	header = binary.BigEndian.AppendUint64(header, uint64(r.start.UnixMilli()))
+	if _, err := r.w.Write(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CreateFile 创建录像文件， 父目录不存在时自动创建
+func CreateFile(path string) (*Recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	recorder, err := NewRecorder(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return recorder, nil
+}
+
+// RecordMessage 记录一条服务端下发的消息
+func (r *Recorder) RecordMessage(data []byte) error {
+	return r.write(RecordMessage, data)
+}
+
+// RecordChecksum 记录服务端在某个逻辑帧的状态哈希
+func (r *Recorder) RecordChecksum(logicFrame int, hash uint64) error {
+	data := make([]byte, 0, 12)
+	data = binary.BigEndian.AppendUint32(data, uint32(logicFrame))
+	data = binary.BigEndian.AppendUint64(data, hash)
+	return r.write(RecordChecksum, data)
+}
+
+func (r *Recorder) write(kind RecordKind, data []byte) error {
+	elapsedMs := r.now().Sub(r.start).Milliseconds()
+	deltaMs := elapsedMs - r.lastMs
+	if deltaMs < 0 {
+		deltaMs = 0
+	}
+	r.lastMs += deltaMs
+
+	if err := r.w.WriteByte(byte(kind)); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(r.scratch[:], uint64(deltaMs))
+	if _, err := r.w.Write(r.scratch[:n]); err != nil {
+		return err
+	}
+	n = binary.PutUvarint(r.scratch[:], uint64(len(data)))
+	if _, err := r.w.Write(r.scratch[:n]); err != nil {
+		return err
+	}
+	_, err := r.w.Write(data)
+	return err
+}
+
+// Close 写出缓存的记录并关闭底层的文件
+func (r *Recorder) Close() error {
+	err := r.w.Flush()
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Reader 按顺序读取录像记录
+type Reader struct {
+	r       *bufio.Reader
+	closer  io.Closer
+	version uint16
+	start   time.Time
+	elapsed time.Duration
+}
+
+// NewReader 读取并校验文件头
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+	if closer, ok := r.(io.Closer); ok {
+		reader.closer = closer
+	}
+
+	header := make([]byte, len(Magic)+2+8)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		return nil, ErrBadMagic
+	}
+	if string(header[:len(Magic)]) != Magic {
+		return nil, ErrBadMagic
+	}
+	reader.version = binary.BigEndian.Uint16(header[len(Magic):])
+	if reader.version == 0 || reader.version > Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, reader.version)
+	}
+	reader.start = time.UnixMilli(int64(binary.BigEndian.Uint64(header[len(Magic)+2:])))
+	return reader, nil
+}
+
+// OpenFile 打开录像文件
+func OpenFile(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (r *Reader) Version() uint16 {
+	return r.version
+}
+
+// StartTime 录制开始的时间
+func (r *Reader) StartTime() time.Time {
+	return r.start
+}
+
+// Next 读取下一条记录， 读完时返回 io.EOF
+func (r *Reader) Next() (Record, error) {
+	kind, err := r.r.ReadByte()
+	if err != nil {
+		return Record{}, err
+	}
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Record{}, unexpectedEOF(err)
+	}
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Record{}, unexpectedEOF(err)
+	}
+	if size > maxRecordSize {
+		return Record{}, fmt.Errorf("replay: record too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return Record{}, unexpectedEOF(err)
+	}
+
+	r.elapsed += time.Duration(delta) * time.Millisecond
+	return Record{
+		Kind:    RecordKind(kind),
+		Elapsed: r.elapsed,
+		Data:    data,
+	}, nil
+}
+
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// DecodeChecksum 解析 RecordChecksum 记录的数据
+func DecodeChecksum(data []byte) (logicFrame int, hash uint64, err error) {
+	if len(data) != 12 {
+		return 0, 0, fmt.Errorf("replay: invalid checksum record length: %d", len(data))
+	}
+	return int(binary.BigEndian.Uint32(data)), binary.BigEndian.Uint64(data[4:]), nil
+}
+
+// 记录写到一半时读到文件末尾说明文件被截断
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -9,7 +9,14 @@ import (
 
 func SerializePlayerInput(data *gametypes.PlayerInput) []byte {
 	builder := flatbuffers.NewBuilder(1024)
+	playerInputOffset := AddPlayerInput(builder, data)
 
+	builder.Finish(playerInputOffset)
+	return builder.FinishedBytes()
+}
+
+// AddPlayerInput 在builder中创建PlayerInput， 用于嵌入到其他消息中
+func AddPlayerInput(builder *flatbuffers.Builder, data *gametypes.PlayerInput) flatbuffers.UOffsetT {
 	// 创建命令数组偏移量列表
 	commandOffsets := make([]flatbuffers.UOffsetT, len(data.Commands))
 
@@ -52,15 +59,15 @@ func SerializePlayerInput(data *gametypes.PlayerInput) []byte {
 	fb.PlayerInputAddPlayerId(builder, int32(data.ID))
 	fb.PlayerInputAddFrame(builder, int32(data.LogicFrame))
 	fb.PlayerInputAddCommands(builder, commandsVector)
-	playerInputOffset := fb.PlayerInputEnd(builder)
-
-	builder.Finish(playerInputOffset)
-	return builder.FinishedBytes()
+	fb.PlayerInputAddSubstituted(builder, data.Substituted)
+	return fb.PlayerInputEnd(builder)
 }
 
 func DeserializePlayerInput(buf []byte) gametypes.PlayerInput {
-	playerInput := fb.GetRootAsPlayerInput(buf, 0)
+	return readPlayerInput(fb.GetRootAsPlayerInput(buf, 0))
+}
 
+func readPlayerInput(playerInput *fb.PlayerInput) gametypes.PlayerInput {
 	// 解析命令数组
 	commands := make([]gametypes.PlayerCommand, 0, playerInput.CommandsLength())
 
@@ -89,9 +96,10 @@ func DeserializePlayerInput(buf []byte) gametypes.PlayerInput {
 	}
 
 	return gametypes.PlayerInput{
-		ID:         int(playerInput.PlayerId()),
-		LogicFrame: int(playerInput.Frame()),
-		Commands:   commands,
+		ID:          int(playerInput.PlayerId()),
+		LogicFrame:  int(playerInput.Frame()),
+		Commands:    commands,
+		Substituted: playerInput.Substituted(),
 	}
 }
 
@@ -111,6 +119,7 @@ func DeserializeWorldSync(buf []byte) gametypes.WorldSync {
 	worldSync := fb.GetRootAsS2CWorldSync(buf, 0)
 	result := gametypes.WorldSync{
 		LogicFrame: worldSync.LogicFrame(),
+		ServerTime: worldSync.ServerTime(),
 	}
 
 	return result
@@ -183,3 +192,132 @@ func DeserializeS2CStartEnterGame(buf []byte) gametypes.StartEnterGame {
 		Players: players,
 	}
 }
+
+func SerializeS2CRoomList(roomList *gametypes.RoomList) []byte {
+	builder := flatbuffers.NewBuilder(1024)
+
+	roomOffsets := make([]flatbuffers.UOffsetT, len(roomList.Rooms))
+	for i := len(roomList.Rooms) - 1; i >= 0; i-- {
+		room := roomList.Rooms[i]
+
+		fb.RoomInfoStart(builder)
+		fb.RoomInfoAddRoomId(builder, int32(room.RoomID))
+		fb.RoomInfoAddPlayerCount(builder, int32(room.PlayerCount))
+		fb.RoomInfoAddMaxPlayers(builder, int32(room.MaxPlayers))
+		fb.RoomInfoAddState(builder, int32(room.State))
+		roomOffsets[i] = fb.RoomInfoEnd(builder)
+	}
+
+	fb.S2CRoomListStartRoomsVector(builder, len(roomOffsets))
+	for i := len(roomOffsets) - 1; i >= 0; i-- {
+		builder.PrependUOffsetT(roomOffsets[i])
+	}
+	roomsVector := builder.EndVector(len(roomOffsets))
+
+	fb.S2CRoomListStart(builder)
+	fb.S2CRoomListAddRooms(builder, roomsVector)
+	roomListOffset := fb.S2CRoomListEnd(builder)
+
+	builder.Finish(roomListOffset)
+	return builder.FinishedBytes()
+}
+
+func DeserializeS2CRoomList(buf []byte) gametypes.RoomList {
+	roomList := fb.GetRootAsS2CRoomList(buf, 0)
+	rooms := make([]gametypes.RoomInfo, 0, roomList.RoomsLength())
+
+	for i := 0; i < roomList.RoomsLength(); i++ {
+		room := new(fb.RoomInfo)
+		if roomList.Rooms(room, i) {
+			rooms = append(rooms, gametypes.RoomInfo{
+				RoomID:      int(room.RoomId()),
+				PlayerCount: int(room.PlayerCount()),
+				MaxPlayers:  int(room.MaxPlayers()),
+				State:       int(room.State()),
+			})
+		}
+	}
+
+	return gametypes.RoomList{
+		Rooms: rooms,
+	}
+}
+
+// addPlayerInputsVector 创建PlayerInput数组， 保持原有顺序
+func addPlayerInputsVector(builder *flatbuffers.Builder, inputs []gametypes.PlayerInput) flatbuffers.UOffsetT {
+	inputOffsets := make([]flatbuffers.UOffsetT, len(inputs))
+	for i := len(inputs) - 1; i >= 0; i-- {
+		inputOffsets[i] = AddPlayerInput(builder, &inputs[i])
+	}
+
+	builder.StartVector(4, len(inputOffsets), 4)
+	for i := len(inputOffsets) - 1; i >= 0; i-- {
+		builder.PrependUOffsetT(inputOffsets[i])
+	}
+	return builder.EndVector(len(inputOffsets))
+}
+
+func SerializeS2CCatchUp(catchUp *gametypes.CatchUp) []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	playersVector := AddPlayersVector(builder, catchUp.Players)
+	pendingInputsVector := addPlayerInputsVector(builder, catchUp.PendingInputs)
+	inputLogVector := addPlayerInputsVector(builder, catchUp.InputLog)
+
+	fb.S2CCatchUpStart(builder)
+	fb.S2CCatchUpAddGameState(builder, int32(catchUp.GameState))
+	fb.S2CCatchUpAddLogicFrame(builder, int32(catchUp.LogicFrame))
+	fb.S2CCatchUpAddSnapshotFrame(builder, int32(catchUp.SnapshotFrame))
+	fb.S2CCatchUpAddPlayers(builder, playersVector)
+	fb.S2CCatchUpAddPendingInputs(builder, pendingInputsVector)
+	fb.S2CCatchUpAddInputLog(builder, inputLogVector)
+	fb.S2CCatchUpAddAppointedServerTime(builder, catchUp.AppointedServerTime)
+	catchUpOffset := fb.S2CCatchUpEnd(builder)
+
+	builder.Finish(catchUpOffset)
+	return builder.FinishedBytes()
+}
+
+func DeserializeS2CCatchUp(buf []byte) gametypes.CatchUp {
+	catchUp := fb.GetRootAsS2CCatchUp(buf, 0)
+
+	players := make([]gametypes.SerializePlayer, 0, catchUp.PlayersLength())
+	for i := 0; i < catchUp.PlayersLength(); i++ {
+		player := new(fb.Player)
+		if catchUp.Players(player, i) {
+			position := player.Position(nil)
+			players = append(players, gametypes.SerializePlayer{
+				ID: int(player.PlayerId()),
+				Position: gametypes.Vector2Int{
+					X: int(position.X()),
+					Y: int(position.Y()),
+				},
+			})
+		}
+	}
+
+	pendingInputs := make([]gametypes.PlayerInput, 0, catchUp.PendingInputsLength())
+	for i := 0; i < catchUp.PendingInputsLength(); i++ {
+		input := new(fb.PlayerInput)
+		if catchUp.PendingInputs(input, i) {
+			pendingInputs = append(pendingInputs, readPlayerInput(input))
+		}
+	}
+
+	inputLog := make([]gametypes.PlayerInput, 0, catchUp.InputLogLength())
+	for i := 0; i < catchUp.InputLogLength(); i++ {
+		input := new(fb.PlayerInput)
+		if catchUp.InputLog(input, i) {
+			inputLog = append(inputLog, readPlayerInput(input))
+		}
+	}
+
+	return gametypes.CatchUp{
+		GameState:           int(catchUp.GameState()),
+		LogicFrame:          int(catchUp.LogicFrame()),
+		SnapshotFrame:       int(catchUp.SnapshotFrame()),
+		Players:             players,
+		PendingInputs:       pendingInputs,
+		InputLog:            inputLog,
+		AppointedServerTime: catchUp.AppointedServerTime(),
+	}
+}
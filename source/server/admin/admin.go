@@ -0,0 +1,204 @@
+// Package admin 服务器的HTTP/JSON管理接口， 供运维在没有GUI的主机上查看与控制服务器
+//
+//	GET  /api/status                 服务器概要
+//	GET  /api/rooms                  所有房间
+//	GET  /api/rooms/{id}             指定房间
+//	POST /api/rooms/{id}/start       不等待满员与加载完成， 立即开始游戏
+//	POST /api/rooms/{id}/pause       暂停游戏
+//	POST /api/rooms/{id}/resume      继续游戏
+//	POST /api/rooms/{id}/end         结束游戏， 玩家回到大厅
+//	GET  /api/players                所有玩家
+//	GET  /api/players/{id}           指定玩家
+//	POST /api/players/{id}/kick      断开玩家的连接
+//
+// 设置了token时， 请求需要带上 "Authorization: Bearer <token>"
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"gameproject/source/logging"
+	"gameproject/source/server/backend"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 关闭时等待正在处理的请求的时间
+const shutdownTimeout = 5 * time.Second
+
+// Server 管理接口的HTTP服务
+type Server struct {
+	game  *backend.GameServer
+	token string
+	http  *http.Server
+}
+
+func NewServer(game *backend.GameServer, addr, token string) *Server {
+	s := &Server{game: game, token: token}
+	s.http = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Start 开始监听， 端口被占用等错误直接返回， 之后在后台处理请求
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Admin API listening", logging.KeyAddr, listener.Addr().String())
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Admin API stopped", logging.Err(err))
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	s.http.Shutdown(ctx)
+}
+
+// Handler 返回处理管理接口的http.Handler， 可以挂载到其他的HTTP服务上
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", s.handleStatus)
+	mux.HandleFunc("GET /api/rooms", s.handleRooms)
+	mux.HandleFunc("GET /api/rooms/{id}", s.handleRoom)
+	mux.HandleFunc("POST /api/rooms/{id}/start", s.roomAction(s.game.ForceStartRoom))
+	mux.HandleFunc("POST /api/rooms/{id}/pause", s.roomAction(func(id int) error { return s.game.PauseRoom(id, true) }))
+	mux.HandleFunc("POST /api/rooms/{id}/resume", s.roomAction(func(id int) error { return s.game.PauseRoom(id, false) }))
+	mux.HandleFunc("POST /api/rooms/{id}/end", s.roomAction(s.game.EndRoom))
+	mux.HandleFunc("GET /api/players", s.handlePlayers)
+	mux.HandleFunc("GET /api/players/{id}", s.handlePlayer)
+	mux.HandleFunc("POST /api/players/{id}/kick", s.playerAction(s.game.KickPlayer))
+	return s.authorize(mux)
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.game.Status()
+	respond(w, status, err)
+}
+
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := s.game.Rooms()
+	respond(w, rooms, err)
+}
+
+func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	room, err := s.game.Room(id)
+	respond(w, room, err)
+}
+
+func (s *Server) handlePlayers(w http.ResponseWriter, r *http.Request) {
+	players, err := s.game.Players()
+	respond(w, players, err)
+}
+
+func (s *Server) handlePlayer(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	player, err := s.game.Player(id)
+	respond(w, player, err)
+}
+
+// roomAction 对房间执行操作， 成功后返回操作之后的房间状态
+func (s *Server) roomAction(action func(roomID int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		if err := action(id); err != nil {
+			respond(w, nil, err)
+			return
+		}
+		room, err := s.game.Room(id)
+		if errors.Is(err, backend.ErrRoomNotFound) {
+			// 结束的房间可能已经被移除
+			respond(w, map[string]any{"id": id, "removed": true}, nil)
+			return
+		}
+		respond(w, room, err)
+	}
+}
+
+func (s *Server) playerAction(action func(playerID int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		err := action(id)
+		respond(w, map[string]any{"id": id, "ok": err == nil}, err)
+	}
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid id"))
+		return 0, false
+	}
+	return id, true
+}
+
+// respond 把管理接口的错误转换为对应的HTTP状态码
+func respond(w http.ResponseWriter, body any, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, body)
+	case errors.Is(err, backend.ErrRoomNotFound), errors.Is(err, backend.ErrPlayerNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, backend.ErrInvalidRoomState):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, backend.ErrServerNotRunning):
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(body); err != nil {
+		slog.Warn("Failed to write admin response", logging.Err(err))
+	}
+}
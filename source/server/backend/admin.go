@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"gameproject/source/gametypes"
+	"sort"
+	"time"
+)
+
+// 管理接口的错误， 由调用方转换为HTTP状态码等
+var (
+	ErrServerNotRunning = errors.New("server is not running")
+	ErrRoomNotFound     = errors.New("room not found")
+	ErrPlayerNotFound   = errors.New("player not found")
+	ErrInvalidRoomState = errors.New("operation not allowed in current room state")
+)
+
+// PlayerSnapshot 玩家状态的副本， 用于管理接口
+type PlayerSnapshot struct {
+	ID              int                  `json:"id"`
+	Nickname        string               `json:"nickname"`
+	Address         string               `json:"address"`
+	RoomID          int                  `json:"room_id"`     // 0表示在大厅中
+	SpectatingRoom  int                  `json:"spectating"`  // 正在观战的房间， 0表示没有观战
+	Online          bool                 `json:"online"`      // 游戏中断线等待重连时为false
+	RTTMillis       float64              `json:"rtt_ms"`      // 服务端测得的平滑RTT
+	RTTVarMillis    float64              `json:"rtt_var_ms"`  // RTT的波动
+	InputDelay      int                  `json:"input_delay"` // 输入从发送到到达服务端预计需要的逻辑帧数
+	LastActive      time.Time            `json:"last_active"` // 最近一次收到消息的时间
+	TimeSyncedTimes int                  `json:"time_synced"` // 已完成的校时次数
+	IsReady         bool                 `json:"is_ready"`    // 是否已完成加载
+	Position        gametypes.Vector2Int `json:"position"`    // 权威状态中的位置
+	SendQueueDepth  int                  `json:"send_queue"`  // 发送队列中等待发送的消息数
+}
+
+// RoomSnapshot 房间状态的副本， 用于管理接口
+type RoomSnapshot struct {
+	ID         int              `json:"id"`
+	State      string           `json:"state"`
+	Paused     bool             `json:"paused"`
+	LogicFrame int              `json:"logic_frame"`
+	MaxPlayers int              `json:"max_players"`
+	Players    []PlayerSnapshot `json:"players"`
+	Spectators []PlayerSnapshot `json:"spectators"`
+}
+
+// ServerStatus 服务器的概要信息
+type ServerStatus struct {
+	Port          int            `json:"port"`
+	TickRate      int            `json:"tick_rate"`
+	Sessions      int            `json:"sessions"`       // 当前的连接数
+	LobbyPlayers  int            `json:"lobby_players"`  // 大厅中的玩家数
+	MatchQueue    int            `json:"match_queue"`    // 匹配队列中的玩家数
+	Rooms         int            `json:"rooms"`          // 房间数
+	RunningGames  int            `json:"running_games"`  // 已经开始游戏的房间数
+	SendQueue     SendQueueStats `json:"send_queue"`     // 所有连接发送队列的统计
+	StartedAt     time.Time      `json:"started_at"`     // 服务器启动的时间
+	UptimeSeconds float64        `json:"uptime_seconds"` // 服务器已运行的秒数
+}
+
+// inLoop 在主循环中执行fn并等待其完成， 管理接口通过它访问只属于主循环的状态
+func (s *GameServer) inLoop(fn func()) error {
+	if !s.running.Load() {
+		return ErrServerNotRunning
+	}
+	done := make(chan struct{})
+	call := func() {
+		defer close(done)
+		fn()
+	}
+	select {
+	case s.calls <- call:
+	case <-s.ctx.Done():
+		return ErrServerNotRunning
+	}
+	<-done
+	return nil
+}
+
+// Status 返回服务器的概要信息， 可以在任意goroutine中调用
+func (s *GameServer) Status() (ServerStatus, error) {
+	var status ServerStatus
+	err := s.inLoop(func() {
+		status = ServerStatus{
+			Port:          s.config.Port,
+			TickRate:      s.config.TickRate,
+			Sessions:      len(s.sessions),
+			LobbyPlayers:  len(s.lobby.players),
+			MatchQueue:    len(s.lobby.queue),
+			Rooms:         len(s.rooms),
+			SendQueue:     s.SendQueueStats(),
+			StartedAt:     s.startedAt,
+			UptimeSeconds: s.clock.Since(s.startedAt).Seconds(),
+		}
+		for _, room := range s.rooms {
+			if room.gameState != Room {
+				status.RunningGames++
+			}
+		}
+	})
+	return status, err
+}
+
+// Rooms 返回所有房间的状态， 按房间ID排序， 可以在任意goroutine中调用
+func (s *GameServer) Rooms() ([]RoomSnapshot, error) {
+	var rooms []RoomSnapshot
+	err := s.inLoop(func() {
+		rooms = make([]RoomSnapshot, 0, len(s.rooms))
+		for _, room := range s.rooms {
+			rooms = append(rooms, room.snapshot())
+		}
+		sort.Slice(rooms, func(i, j int) bool {
+			return rooms[i].ID < rooms[j].ID
+		})
+	})
+	return rooms, err
+}
+
+// Room 返回指定房间的状态， 可以在任意goroutine中调用
+func (s *GameServer) Room(roomID int) (RoomSnapshot, error) {
+	var room RoomSnapshot
+	err := s.withRoom(roomID, func(r *GameRoom) error {
+		room = r.snapshot()
+		return nil
+	})
+	return room, err
+}
+
+// Players 返回所有玩家（包括断线等待重连的玩家）的状态， 按玩家ID排序， 可以在任意goroutine中调用
+func (s *GameServer) Players() ([]PlayerSnapshot, error) {
+	var players []PlayerSnapshot
+	err := s.inLoop(func() {
+		for _, player := range s.allPlayers() {
+			players = append(players, player.snapshot())
+		}
+		sort.Slice(players, func(i, j int) bool {
+			return players[i].ID < players[j].ID
+		})
+	})
+	return players, err
+}
+
+// Player 返回指定玩家的状态， 可以在任意goroutine中调用
+func (s *GameServer) Player(playerID int) (PlayerSnapshot, error) {
+	var player PlayerSnapshot
+	err := s.withPlayer(playerID, func(p *Player) error {
+		player = p.snapshot()
+		return nil
+	})
+	return player, err
+}
+
+// KickPlayer 断开玩家的连接， 游戏中的玩家直接移出房间， 不等待重连
+func (s *GameServer) KickPlayer(playerID int) error {
+	return s.withPlayer(playerID, func(player *Player) error {
+		player.logger.Info("Admin kicked player")
+		if player.isOnline() {
+			delete(s.sessions, player.conn)
+			s.disconnectPlayer(player)
+			player.sendQueue.close()
+			player.conn.Close()
+		}
+		if player.room != nil {
+			player.room.removePlayer(player)
+		}
+		return nil
+	})
+}
+
+// ForceStartRoom 不等待房间满员与玩家加载完成， 立即开始游戏
+// 仍然等待房间中的玩家完成校时， 否则客户端无法按约定的时间开始
+func (s *GameServer) ForceStartRoom(roomID int) error {
+	return s.withRoom(roomID, func(room *GameRoom) error {
+		if room.gameState != Room && room.gameState != WaitPlayersReady {
+			return fmt.Errorf("%w: room %d is %v", ErrInvalidRoomState, room.id, room.gameState)
+		}
+		if len(room.players) == 0 {
+			return fmt.Errorf("%w: room %d has no players", ErrInvalidRoomState, room.id)
+		}
+		room.logger.Info("Admin force started room", "players", len(room.players), "max_players", room.maxPlayers)
+		room.forceStart = true
+		return nil
+	})
+}
+
+// PauseRoom 暂停或继续房间的游戏， 暂停时逻辑帧不再推进， 客户端停在最后一次world sync
+func (s *GameServer) PauseRoom(roomID int, paused bool) error {
+	return s.withRoom(roomID, func(room *GameRoom) error {
+		if room.gameState != Game {
+			return fmt.Errorf("%w: room %d is %v", ErrInvalidRoomState, room.id, room.gameState)
+		}
+		if room.paused != paused {
+			room.logger.Info("Admin paused room", "paused", paused)
+		}
+		room.paused = paused
+		return nil
+	})
+}
+
+// EndRoom 结束房间， 玩家与观战者在下一个Tick回到大厅
+func (s *GameServer) EndRoom(roomID int) error {
+	return s.withRoom(roomID, func(room *GameRoom) error {
+		room.logger.Info("Admin ended room")
+		room.gameState = GameOver
+		return nil
+	})
+}
+
+func (s *GameServer) withRoom(roomID int, fn func(room *GameRoom) error) error {
+	var err error
+	if loopErr := s.inLoop(func() {
+		room, ok := s.rooms[roomID]
+		if !ok {
+			err = ErrRoomNotFound
+			return
+		}
+		err = fn(room)
+	}); loopErr != nil {
+		return loopErr
+	}
+	return err
+}
+
+func (s *GameServer) withPlayer(playerID int, fn func(player *Player) error) error {
+	var err error
+	if loopErr := s.inLoop(func() {
+		for _, player := range s.allPlayers() {
+			if player.id == playerID {
+				err = fn(player)
+				return
+			}
+		}
+		err = ErrPlayerNotFound
+	}); loopErr != nil {
+		return loopErr
+	}
+	return err
+}
+
+// allPlayers 返回所有连接中的玩家， 以及房间中断线等待重连的玩家
+func (s *GameServer) allPlayers() []*Player {
+	players := make([]*Player, 0, len(s.sessions))
+	for _, player := range s.sessions {
+		players = append(players, player)
+	}
+	for _, room := range s.rooms {
+		for _, player := range room.players {
+			if !player.isOnline() {
+				players = append(players, player)
+			}
+		}
+	}
+	return players
+}
+
+func (r *GameRoom) snapshot() RoomSnapshot {
+	room := RoomSnapshot{
+		ID:         r.id,
+		State:      r.gameState.String(),
+		Paused:     r.paused,
+		LogicFrame: r.logicFrame,
+		MaxPlayers: r.maxPlayers,
+		Players:    make([]PlayerSnapshot, 0, len(r.players)),
+		Spectators: make([]PlayerSnapshot, 0, len(r.spectators)),
+	}
+	for _, player := range r.players {
+		room.Players = append(room.Players, player.snapshot())
+	}
+	for _, spectator := range r.spectators {
+		room.Spectators = append(room.Spectators, spectator.snapshot())
+	}
+	sort.Slice(room.Players, func(i, j int) bool {
+		return room.Players[i].ID < room.Players[j].ID
+	})
+	sort.Slice(room.Spectators, func(i, j int) bool {
+		return room.Spectators[i].ID < room.Spectators[j].ID
+	})
+	return room
+}
+
+func (p *Player) snapshot() PlayerSnapshot {
+	player := PlayerSnapshot{
+		ID:              p.id,
+		Nickname:        p.nickname,
+		Address:         p.conn.RemoteAddr().String(),
+		Online:          p.isOnline(),
+		RTTMillis:       milliseconds(p.rtt.srtt),
+		RTTVarMillis:    milliseconds(p.rtt.rttvar),
+		InputDelay:      p.inputDelay,
+		LastActive:      p.lastActive,
+		TimeSyncedTimes: p.timeSyncedTimes,
+		IsReady:         p.isReady,
+		Position:        p.position,
+		SendQueueDepth:  p.sendQueue.len(),
+	}
+	if p.room != nil {
+		player.RoomID = p.room.id
+	}
+	if p.spectating != nil {
+		player.SpectatingRoom = p.spectating.id
+	}
+	return player
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
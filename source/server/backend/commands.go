@@ -3,8 +3,8 @@ package backend
 import (
 	"gameproject/fb"
 	"gameproject/source/gametypes"
+	"gameproject/source/logging"
 	"gameproject/source/serialization"
-	"log"
 	"time"
 
 	flatbuffers "github.com/google/flatbuffers/go"
@@ -45,20 +45,20 @@ func createS2CCommand(command fb.ServerCommand, status fb.S2CStatus, code int64,
 
 func sendPong(player *Player) error {
 	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_PONG, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", nil)
-	_, err := player.conn.Write(data)
+	err := player.sendDroppable(data)
 	if err != nil {
-		log.Printf("Failed to send pong message to player %d: %v", player.id, err)
+		player.logger.Warn("Failed to send pong message", logging.Err(err))
 		return err
 	}
 	return nil
 }
 
-func sendResponseTime(player *Player) error {
+func sendResponseTime(player *Player, now time.Time) error {
 	builder := flatbuffers.NewBuilder(1024)
 
 	// 创建 S2CResponseTime
 	fb.S2CResponseTimeStart(builder)
-	fb.S2CResponseTimeAddServerTime(builder, time.Now().UnixMilli())
+	fb.S2CResponseTimeAddServerTime(builder, now.UnixMilli())
 	responseTimeOffset := fb.S2CResponseTimeEnd(builder)
 
 	builder.Finish(responseTimeOffset)
@@ -67,25 +67,141 @@ func sendResponseTime(player *Player) error {
 	// 创建 S2CCommand
 	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_RESPONSETIME, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
 
-	_, err := player.conn.Write(data)
+	// 客户端同一时间只有一个校时请求， 回复不能被丢弃
+	err := player.send(data)
 	if err != nil {
-		log.Printf("Failed to send response time message to player %d: %v", player.id, err)
+		player.logger.Warn("Failed to send response time message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+// sendLatencyProbe 发送RTT探测， 丢失时只是少一个样本
+func sendLatencyProbe(player *Player, id int) error {
+	builder := flatbuffers.NewBuilder(64)
+	fb.S2CLatencyProbeStart(builder)
+	fb.S2CLatencyProbeAddId(builder, int32(id))
+	builder.Finish(fb.S2CLatencyProbeEnd(builder))
+
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_LATENCYPROBE, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", builder.FinishedBytes())
+	err := player.sendDroppable(data)
+	if err != nil {
+		player.logger.Warn("Failed to send latency probe", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+// sendInputDelay 通知客户端需要提前发送输入的帧数
+func sendInputDelay(player *Player, inputLead int, rtt time.Duration) error {
+	builder := flatbuffers.NewBuilder(64)
+	fb.S2CInputDelayStart(builder)
+	fb.S2CInputDelayAddInputDelay(builder, int32(inputLead))
+	fb.S2CInputDelayAddRtt(builder, int32(rtt.Milliseconds()))
+	builder.Finish(fb.S2CInputDelayEnd(builder))
+
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_INPUTDELAY, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", builder.FinishedBytes())
+	err := player.send(data)
+	if err != nil {
+		player.logger.Warn("Failed to send input delay", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+// sendFail 回复请求失败， code 为 gametypes 中定义的错误码
+func sendFail(player *Player, command fb.ServerCommand, code int64, message string) error {
+	data := createS2CCommand(command, fb.S2CStatusS2C_STATUS_FAIL, code, message, nil)
+	err := player.send(data)
+	if err != nil {
+		player.logger.Warn("Failed to send fail message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+// sendEnterLobby 发送进入大厅消息
+func sendEnterLobby(player *Player) error {
+	builder := flatbuffers.NewBuilder(1024)
+
+	fb.S2CEnterLobbyStart(builder)
+	fb.S2CEnterLobbyAddPlayerId(builder, int32(player.id))
+	enterLobbyOffset := fb.S2CEnterLobbyEnd(builder)
+
+	builder.Finish(enterLobbyOffset)
+	bodyBytes := builder.FinishedBytes()
+
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_ENTERLOBBY, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+
+	err := player.send(data)
+	if err != nil {
+		player.logger.Warn("Failed to send enter lobby message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendRoomList(player *Player, roomList *gametypes.RoomList) error {
+	bodyBytes := serialization.SerializeS2CRoomList(roomList)
+
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_ROOMLIST, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+
+	err := player.sendDroppable(data)
+	if err != nil {
+		player.logger.Warn("Failed to send room list", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendLeaveRoom(player *Player) error {
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_LEAVEROOM, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", nil)
+
+	err := player.send(data)
+	if err != nil {
+		player.logger.Warn("Failed to send leave room message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendQuickMatch(player *Player, queueSize int) error {
+	builder := flatbuffers.NewBuilder(1024)
+
+	fb.S2CQuickMatchStart(builder)
+	fb.S2CQuickMatchAddQueueSize(builder, int32(queueSize))
+	quickMatchOffset := fb.S2CQuickMatchEnd(builder)
+
+	builder.Finish(quickMatchOffset)
+	bodyBytes := builder.FinishedBytes()
+
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_QUICKMATCH, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+
+	err := player.sendDroppable(data)
+	if err != nil {
+		player.logger.Warn("Failed to send quick match message", logging.Err(err))
 		return err
 	}
 	return nil
 }
 
 // sendEnterRoomMessage 发送进入房间消息
-func sendEnterRoomMessage(player *Player, server *GameServer) error {
+func sendEnterRoomMessage(player *Player, room *GameRoom) error {
 	builder := flatbuffers.NewBuilder(1024)
 
 	// 创建 S2CEnterRoom
+	sessionTokenOffset := builder.CreateString(player.sessionToken)
+
 	fb.S2CEnterRoomStart(builder)
 	fb.S2CEnterRoomAddPlayerId(builder, int32(player.id))
-	fb.S2CEnterRoomAddTimeSyncTimes(builder, int32(server.config.TimeSyncTimes))
-	fb.S2CEnterRoomAddHeartbeatInterval(builder, int32(server.config.HeartbeatInterval.Seconds()))
-	fb.S2CEnterRoomAddSendInputInterval(builder, server.config.SendInputInterval)
-	fb.S2CEnterRoomAddExecutionDuration(builder, server.config.ExecutionDuration)
+	fb.S2CEnterRoomAddTimeSyncTimes(builder, int32(room.config.TimeSyncTimes))
+	fb.S2CEnterRoomAddHeartbeatInterval(builder, int32(room.config.HeartbeatInterval.Seconds()))
+	fb.S2CEnterRoomAddSendInputInterval(builder, room.config.SendInputInterval)
+	fb.S2CEnterRoomAddExecutionDuration(builder, room.config.ExecutionDuration)
+	fb.S2CEnterRoomAddRoomId(builder, int32(room.id))
+	fb.S2CEnterRoomAddMaxPlayers(builder, int32(room.maxPlayers))
+	fb.S2CEnterRoomAddSessionToken(builder, sessionTokenOffset)
+	fb.S2CEnterRoomAddTickRate(builder, int32(room.config.TickRate))
 	enterRoomOffset := fb.S2CEnterRoomEnd(builder)
 
 	builder.Finish(enterRoomOffset)
@@ -93,17 +209,40 @@ func sendEnterRoomMessage(player *Player, server *GameServer) error {
 
 	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_ENTERROOM, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
 
-	_, err := player.conn.Write(data)
+	err := player.send(data)
 	if err != nil {
-		log.Printf("Failed to send enter room message to player %d: %v", player.id, err)
+		player.logger.Warn("Failed to send enter room message", logging.Err(err))
 		return err
 	}
 	return nil
 }
 
-func sendStartEnterGame(server *GameServer) error {
+// sendSpectate 发送开始观战消息， 之后的房间广播按观战延迟转发
+func sendSpectate(player *Player, room *GameRoom) error {
+	builder := flatbuffers.NewBuilder(1024)
+
+	fb.S2CSpectateStart(builder)
+	fb.S2CSpectateAddRoomId(builder, int32(room.id))
+	fb.S2CSpectateAddMaxPlayers(builder, int32(room.maxPlayers))
+	fb.S2CSpectateAddDelay(builder, int32(room.config.SpectatorDelay.Milliseconds()))
+	spectateOffset := fb.S2CSpectateEnd(builder)
+
+	builder.Finish(spectateOffset)
+	bodyBytes := builder.FinishedBytes()
+
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_SPECTATE, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+
+	err := player.send(data)
+	if err != nil {
+		player.logger.Warn("Failed to send spectate message", logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func sendStartEnterGame(room *GameRoom) error {
 	serializePlayers := make([]gametypes.SerializePlayer, 0)
-	for _, player := range server.players {
+	for _, player := range room.players {
 		serializePlayers = append(serializePlayers, gametypes.SerializePlayer{
 			ID:       player.id,
 			Position: player.position,
@@ -117,26 +256,31 @@ func sendStartEnterGame(server *GameServer) error {
 	bodyBytes := serialization.SerializeS2CStartEnterGame(&startEnterGame)
 	// 创建 S2CCommand
 	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_STARTENTERGAME, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+	room.publish(data)
 
 	// 广播给所有玩家
-	for _, player := range server.players {
-		_, err := player.conn.Write(data)
+	for _, player := range room.players {
+		// 断线的玩家重连时通过追帧数据获取
+		if !player.isOnline() {
+			continue
+		}
+		err := player.send(data)
 		if err != nil {
-			log.Printf("Failed to send start enter game message to player %d: %v", player.id, err)
-			return err
+			player.logger.Warn("Failed to send start enter game message", logging.Err(err))
+			continue
 		}
 	}
 
-	log.Printf("Sent start enter game message to all players")
+	room.logger.Info("Sent start enter game message to all players")
 	return nil
 }
 
-func sendStartGame(server *GameServer) error {
+func sendStartGame(room *GameRoom) error {
 	builder := flatbuffers.NewBuilder(1024)
 
 	// 创建 S2CStartGame
 	fb.S2CStartGameStart(builder)
-	fb.S2CStartGameAddAppointedServerTime(builder, server.appointedTime)
+	fb.S2CStartGameAddAppointedServerTime(builder, room.appointedTime)
 	startGameOffset := fb.S2CStartGameEnd(builder)
 
 	builder.Finish(startGameOffset)
@@ -146,46 +290,80 @@ func sendStartGame(server *GameServer) error {
 	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_STARTGAME, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
 
 	// 广播给所有玩家
-	for _, player := range server.players {
-		_, err := player.conn.Write(data)
+	for _, player := range room.players {
+		// 断线的玩家重连时通过追帧数据获取
+		if !player.isOnline() {
+			continue
+		}
+		err := player.send(data)
 		if err != nil {
-			log.Printf("Failed to send start game message to player %d: %v", player.id, err)
-			return err
+			player.logger.Warn("Failed to send start game message", logging.Err(err))
+			continue
 		}
 	}
 
-	log.Printf("Sent start game message to all players, game will start at Unix time: %d", server.appointedTime)
+	room.logger.Info("Sent start game message to all players", "appointed_time", room.appointedTime)
 	return nil
 }
 
-func sendPlayerInput(s *GameServer, playerInput *gametypes.PlayerInput) {
+func sendPlayerInput(room *GameRoom, playerInput *gametypes.PlayerInput) {
 	bodyBytes := serialization.SerializePlayerInput(playerInput)
 
 	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+	room.publish(data)
 
-	for _, player := range s.players {
-		_, err := player.conn.Write(data)
+	for _, player := range room.players {
+		// 断线的玩家重连时通过追帧数据获取
+		if !player.isOnline() {
+			continue
+		}
+		err := player.send(data)
 		if err != nil {
-			log.Printf("Failed to send player input to player %d: %v", player.id, err)
+			player.logger.Warn("Failed to send player input", logging.Err(err))
 			continue
 		}
 	}
 }
 
-func sendWorldSync(s *GameServer) {
+func sendWorldSync(room *GameRoom) {
 	bodyBytes := serialization.SerializeWorldSync(gametypes.WorldSync{
-		LogicFrame: int32(s.logicFrame),
-		ServerTime: time.Now().UnixMilli(),
+		LogicFrame: int32(room.logicFrame),
+		ServerTime: room.server.clock.Now().UnixMilli(),
 	})
 	// Create S2CCommand
 	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_WORLDSYNC, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+	room.publishWorldSync(data)
 
 	// Broadcast to all players
-	for _, player := range s.players {
-		_, err := player.conn.Write(data)
+	for _, player := range room.players {
+		// 断线的玩家重连时通过追帧数据获取
+		if !player.isOnline() {
+			continue
+		}
+		err := player.send(data)
 		if err != nil {
-			log.Printf("Failed to send world sync to player %d: %v", player.id, err)
+			player.logger.Warn("Failed to send world sync", logging.Err(err))
 			continue
 		}
 	}
 }
+
+// sendCatchUp 发送断线重连后的追帧数据
+func sendCatchUp(player *Player, room *GameRoom, catchUp gametypes.CatchUp) error {
+	bodyBytes := serialization.SerializeS2CCatchUp(&catchUp)
+
+	data := createS2CCommand(fb.ServerCommandS2C_COMMAND_CATCHUP, fb.S2CStatusS2C_STATUS_SUCCESS, 0, "", bodyBytes)
+
+	err := player.send(data)
+	if err != nil {
+		player.logger.Warn("Failed to send catch up", logging.Err(err))
+		return err
+	}
+	room.logger.Info("Sent catch up",
+		logging.KeyPlayerID, player.id,
+		"snapshot_frame", catchUp.SnapshotFrame,
+		logging.KeyLogicFrame, catchUp.LogicFrame,
+		"pending_inputs", len(catchUp.PendingInputs),
+		"input_log", len(catchUp.InputLog))
+	return nil
+}
@@ -0,0 +1,221 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gameproject/source/framing"
+	"gameproject/source/logging"
+	"gameproject/source/transport"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultServerConfig 返回默认配置， 配置文件中没有出现的字段使用这些值
+// MaxInputFrameLag 与 MaxInputFrameLead 为0时根据TickRate计算
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Port:                     12345,
+		Transport:                transport.KCP,
+		TickRate:                 20,
+		MaxPlayers:               2,
+		HeartbeatInterval:        5 * time.Second,
+		TimeSyncTimes:            3,
+		AppointedServerTimeDelay: 3 * time.Second,
+		SendInputInterval:        2,
+		ExecutionDuration:        1,
+		MatchmakingPolicy:        "firstcome",
+		ReconnectTimeout:         60 * time.Second,
+		ReplayDir:                "replays",
+		MissingInputTimeoutTicks: 30,
+		MaxFrameSize:             framing.DefaultMaxFrameSize,
+		SendQueueSize:            256,
+		SendQueuePolicy:          SendQueueDropOldest,
+		CommandRateLimit:         100,
+		CommandBurst:             200,
+		LatencyProbeInterval:     time.Second,
+		AdaptiveInputDelay:       true,
+		SpectatorDelay:           30 * time.Second,
+		MaxSpectators:            16,
+		LogLevel:                 "info",
+		LogMaxSizeMB:             100,
+		LogMaxBackups:            5,
+	}
+}
+
+// LoadServerConfig 读取配置文件， 根据扩展名选择 TOML、YAML 或 JSON 格式
+// 文件中没有出现的字段使用默认值， 时长使用 "5s"、"1m" 这样的字符串
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultServerConfig()
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		meta, err := toml.Decode(string(data), config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown fields: %v", path, undecoded)
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported config format %q", path, ext)
+	}
+
+	config.applyDerivedDefaults()
+	return config, nil
+}
+
+// applyDerivedDefaults 计算依赖其他字段的默认值
+func (c *ServerConfig) applyDerivedDefaults() {
+	if c.MaxInputFrameLag == 0 {
+		c.MaxInputFrameLag = 3 * c.TickRate
+	}
+	if c.MaxInputFrameLead == 0 {
+		c.MaxInputFrameLead = c.TickRate / 2
+	}
+}
+
+// FieldError 某个配置字段的错误， Field 为配置文件中的字段名
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ConfigErrors 配置校验发现的所有错误
+type ConfigErrors []FieldError
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return "invalid server config:\n  " + strings.Join(messages, "\n  ")
+}
+
+// Validate 校验所有字段， 返回 ConfigErrors， 全部合法时返回nil
+func (c *ServerConfig) Validate() error {
+	var errs ConfigErrors
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check(c.Port > 0 && c.Port <= 65535, "port", "must be in 1..65535, got %d", c.Port)
+	check(c.TickRate >= 2, "tick_rate", "must be at least 2, got %d", c.TickRate)
+	check(c.MaxPlayers >= 1, "max_players", "must be at least 1, got %d", c.MaxPlayers)
+	check(c.HeartbeatInterval > 0, "heartbeat_interval", "must be positive, got %v", c.HeartbeatInterval)
+	check(c.TimeSyncTimes >= 1, "time_sync_times", "must be at least 1, got %d", c.TimeSyncTimes)
+	check(c.AppointedServerTimeDelay >= 0, "appointed_server_time_delay", "must not be negative, got %v", c.AppointedServerTimeDelay)
+	check(c.SendInputInterval > 0, "send_input_interval", "must be positive, got %v", c.SendInputInterval)
+	check(c.ExecutionDuration >= 0, "execution_duration", "must not be negative, got %v", c.ExecutionDuration)
+	if _, err := transport.New(c.Transport); err != nil {
+		check(false, "transport", "must be one of %v, got %q", transport.Names(), c.Transport)
+	}
+	if _, err := NewMatchmakingPolicy(c.MatchmakingPolicy); err != nil {
+		check(false, "matchmaking_policy", "unknown policy %q", c.MatchmakingPolicy)
+	}
+	check(c.ReconnectTimeout >= 0, "reconnect_timeout", "must not be negative, got %v", c.ReconnectTimeout)
+	check(c.MissingInputTimeoutTicks >= 0, "missing_input_timeout_ticks", "must not be negative, got %d", c.MissingInputTimeoutTicks)
+	check(c.MaxInputFrameLag >= 0, "max_input_frame_lag", "must not be negative, got %d", c.MaxInputFrameLag)
+	check(c.MaxInputFrameLead >= 0, "max_input_frame_lead", "must not be negative, got %d", c.MaxInputFrameLead)
+	check(c.MaxFrameSize >= 1024, "max_frame_size", "must be at least 1024, got %d", c.MaxFrameSize)
+	check(c.CommandRateLimit >= 0, "command_rate_limit", "must not be negative, got %v", c.CommandRateLimit)
+	check(c.CommandRateLimit == 0 || c.CommandBurst >= 1, "command_burst", "must be at least 1 when command_rate_limit is set, got %d", c.CommandBurst)
+	check(c.LatencyProbeInterval >= 0, "latency_probe_interval", "must not be negative, got %v", c.LatencyProbeInterval)
+	check(c.SpectatorDelay >= 0, "spectator_delay", "must not be negative, got %v", c.SpectatorDelay)
+	check(c.MaxSpectators >= 0, "max_spectators", "must not be negative, got %d", c.MaxSpectators)
+	if c.AdminAddr != "" {
+		_, _, err := net.SplitHostPort(c.AdminAddr)
+		check(err == nil, "admin_addr", "must be host:port, got %q", c.AdminAddr)
+	}
+	if c.MetricsAddr != "" {
+		_, _, err := net.SplitHostPort(c.MetricsAddr)
+		check(err == nil, "metrics_addr", "must be host:port, got %q", c.MetricsAddr)
+	}
+	_, err := logging.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level", "must be debug, info, warn or error, got %q", c.LogLevel)
+	format := strings.ToLower(c.LogFormat)
+	check(format == "" || format == logging.FormatText || format == logging.FormatJSON, "log_format", "must be %q or %q, got %q", logging.FormatText, logging.FormatJSON, c.LogFormat)
+	check(c.LogMaxSizeMB >= 0, "log_max_size_mb", "must not be negative, got %d", c.LogMaxSizeMB)
+	check(c.LogMaxBackups >= 0, "log_max_backups", "must not be negative, got %d", c.LogMaxBackups)
+	check(c.SendQueueSize >= 1, "send_queue_size", "must be at least 1, got %d", c.SendQueueSize)
+	check(c.SendQueuePolicy == SendQueueDropOldest || c.SendQueuePolicy == SendQueueDisconnect, "send_queue_policy", "must be %q or %q, got %q", SendQueueDropOldest, SendQueueDisconnect, c.SendQueuePolicy)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// UnmarshalJSON 时长字段使用 "5s" 这样的字符串， 与 TOML、YAML 保持一致
+func (c *ServerConfig) UnmarshalJSON(data []byte) error {
+	type plain ServerConfig
+	aux := struct {
+		*plain
+		HeartbeatInterval        jsonDuration `json:"heartbeat_interval"`
+		AppointedServerTimeDelay jsonDuration `json:"appointed_server_time_delay"`
+		ReconnectTimeout         jsonDuration `json:"reconnect_timeout"`
+		LatencyProbeInterval     jsonDuration `json:"latency_probe_interval"`
+		SpectatorDelay           jsonDuration `json:"spectator_delay"`
+	}{
+		plain:                    (*plain)(c),
+		HeartbeatInterval:        jsonDuration(c.HeartbeatInterval),
+		AppointedServerTimeDelay: jsonDuration(c.AppointedServerTimeDelay),
+		ReconnectTimeout:         jsonDuration(c.ReconnectTimeout),
+		LatencyProbeInterval:     jsonDuration(c.LatencyProbeInterval),
+		SpectatorDelay:           jsonDuration(c.SpectatorDelay),
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&aux); err != nil {
+		return err
+	}
+	c.HeartbeatInterval = time.Duration(aux.HeartbeatInterval)
+	c.AppointedServerTimeDelay = time.Duration(aux.AppointedServerTimeDelay)
+	c.ReconnectTimeout = time.Duration(aux.ReconnectTimeout)
+	c.LatencyProbeInterval = time.Duration(aux.LatencyProbeInterval)
+	c.SpectatorDelay = time.Duration(aux.SpectatorDelay)
+	return nil
+}
+
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(duration)
+	return nil
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"gameproject/source/simulation"
+)
+
+// 保留最近多少个world sync帧的状态哈希， 更早的上报会被忽略
+const stateHashHistory = 64
+
+// DesyncEvent 客户端上报的状态哈希与服务端权威状态不一致
+type DesyncEvent struct {
+	RoomID       int
+	LogicFrame   int            // 第一个出现不一致的逻辑帧
+	ServerHash   uint64         // 服务端权威状态的哈希
+	PlayerHashes map[int]uint64 // 目前收到的各个玩家在该帧的哈希
+	StateDump    string         // 服务端在该帧的状态
+}
+
+type frameHashes struct {
+	logicFrame   int
+	serverHash   uint64
+	stateDump    string
+	playerHashes map[int]uint64
+}
+
+// desyncDetector 比较各个客户端在相同逻辑帧的状态哈希
+type desyncDetector struct {
+	frames   []*frameHashes // 按逻辑帧递增
+	desynced bool
+}
+
+func newDesyncDetector() *desyncDetector {
+	return &desyncDetector{}
+}
+
+func (d *desyncDetector) recordServerState(world *simulation.World) {
+	d.frames = append(d.frames, &frameHashes{
+		logicFrame:   world.LogicFrame(),
+		serverHash:   world.Checksum(),
+		stateDump:    world.Dump(),
+		playerHashes: make(map[int]uint64),
+	})
+	if len(d.frames) > stateHashHistory {
+		d.frames = d.frames[len(d.frames)-stateHashHistory:]
+	}
+}
+
+// reportPlayerHash 记录玩家的哈希， 第一次出现不一致时返回不同步事件， 之后的不一致不再重复报告
+func (d *desyncDetector) reportPlayerHash(roomID, playerID, logicFrame int, hash uint64) *DesyncEvent {
+	var frame *frameHashes
+	for _, f := range d.frames {
+		if f.logicFrame == logicFrame {
+			frame = f
+			break
+		}
+	}
+	if frame == nil {
+		return nil
+	}
+
+	frame.playerHashes[playerID] = hash
+	if hash == frame.serverHash || d.desynced {
+		return nil
+	}
+
+	d.desynced = true
+	playerHashes := make(map[int]uint64, len(frame.playerHashes))
+	for id, h := range frame.playerHashes {
+		playerHashes[id] = h
+	}
+	return &DesyncEvent{
+		RoomID:       roomID,
+		LogicFrame:   logicFrame,
+		ServerHash:   frame.serverHash,
+		PlayerHashes: playerHashes,
+		StateDump:    frame.stateDump,
+	}
+}
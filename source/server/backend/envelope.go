@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gameproject/fb"
+)
+
+var errMalformedCommand = errors.New("malformed command")
+
+// decodeC2SCommand 读取客户端消息外层的命令与消息体
+// 客户端的消息不可信， 越界的偏移量会让 flatbuffers 的访问函数panic， 读取之前先检查用到的偏移量都在 frame 之内
+func decodeC2SCommand(frame []byte) (command fb.ClientCommand, body []byte, err error) {
+	if err := checkC2SCommand(frame); err != nil {
+		return 0, nil, err
+	}
+	// 检查遗漏的情况同样只断开这个连接
+	defer func() {
+		if r := recover(); r != nil {
+			command, body, err = 0, nil, fmt.Errorf("%w: %v", errMalformedCommand, r)
+		}
+	}()
+	c2sCommand := fb.GetRootAsC2SCommand(frame, 0)
+	return c2sCommand.Command(), c2sCommand.BodyBytes(), nil
+}
+
+// checkC2SCommand 检查根表、vtable、command 字段与 body 向量都位于 buf 之内
+// 布局见 fb/C2SCommand.go： command 在 vtable 的第4字节， body 在第6字节
+func checkC2SCommand(buf []byte) error {
+	within := func(pos, n int) bool {
+		return pos >= 0 && n >= 0 && pos <= len(buf)-n
+	}
+	if !within(0, 4) {
+		return fmt.Errorf("%w: %d bytes", errMalformedCommand, len(buf))
+	}
+	table := int(binary.LittleEndian.Uint32(buf))
+	if !within(table, 4) {
+		return fmt.Errorf("%w: root table at %d", errMalformedCommand, table)
+	}
+	vtable := table - int(int32(binary.LittleEndian.Uint32(buf[table:])))
+	if !within(vtable, 4) {
+		return fmt.Errorf("%w: vtable at %d", errMalformedCommand, vtable)
+	}
+	vtableSize := int(binary.LittleEndian.Uint16(buf[vtable:]))
+	if vtableSize < 4 || vtableSize%2 != 0 || !within(vtable, vtableSize) {
+		return fmt.Errorf("%w: vtable size %d", errMalformedCommand, vtableSize)
+	}
+	field := func(slot int) int {
+		if slot+2 > vtableSize {
+			return 0
+		}
+		return int(binary.LittleEndian.Uint16(buf[vtable+slot:]))
+	}
+
+	if offset := field(4); offset != 0 && !within(table+offset, 1) {
+		return fmt.Errorf("%w: command at %d", errMalformedCommand, table+offset)
+	}
+	if offset := field(6); offset != 0 {
+		if !within(table+offset, 4) {
+			return fmt.Errorf("%w: body offset at %d", errMalformedCommand, table+offset)
+		}
+		vector := table + offset + int(binary.LittleEndian.Uint32(buf[table+offset:]))
+		if !within(vector, 4) {
+			return fmt.Errorf("%w: body at %d", errMalformedCommand, vector)
+		}
+		length := int(binary.LittleEndian.Uint32(buf[vector:]))
+		if !within(vector+4, length) {
+			return fmt.Errorf("%w: body of %d bytes at %d", errMalformedCommand, length, vector)
+		}
+	}
+	return nil
+}
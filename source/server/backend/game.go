@@ -2,141 +2,266 @@ package backend
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"gameproject/fb"
+	"gameproject/source/clock"
+	"gameproject/source/dispatch"
 	"gameproject/source/gametypes"
-	"gameproject/source/serialization"
-	"log"
-	"math/rand/v2"
+	"gameproject/source/logging"
+	"gameproject/source/transport"
+	"log/slog"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/xtaci/kcp-go/v5"
-)
-
-type GameState int
-
-const (
-	Room GameState = iota
-	WaitPlayersReady
-	GameCountDown // 游戏开始前倒计时阶段
-	Game
-	GameOver
 )
 
-func (s GameState) String() string {
-	return [...]string{"Room", "WaitPlayersReady", "GameCountDown", "Game", "GameOver"}[s]
-}
-
 type GameServer struct {
-	players  map[int]*Player
-	nextID   int
-	listener *kcp.Listener
-	config   *ServerConfig
-	ctx      context.Context
-	cancel   context.CancelFunc
-	wg       sync.WaitGroup
-
-	gameState     GameState
-	appointedTime int64
-
-	gameMap      *gametypes.GameMap
-	frameCounter int
-	logicFrame   int
-	inputQueue   []gametypes.PlayerInput
+	nextID    int
+	listener  transport.Listener
+	transport transport.Transport // 为nil时使用配置中的传输方式
+	config    *ServerConfig
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	// 接收连接与读取连接的goroutine通过events把连接事件与解析后的消息交给主循环
+	events chan connEvent
+	// 管理接口通过calls在主循环中执行查询与操作
+	calls     chan func()
+	running   atomic.Bool
+	startedAt time.Time
+	clock     clock.Clock // 游戏逻辑使用的时间， 测试中替换为可控的时钟
+
+	sendQueueCounters sendQueueCounters
+	commandStats      *dispatch.Stats[fb.ClientCommand]
+	metrics           *serverMetrics
+	dispatcher        *dispatch.Registry[fb.ClientCommand, *Player]
+
+	// 以下状态只在主循环（run）中访问， 不需要加锁
+	sessions   map[transport.Conn]*Player // 连接当前对应的玩家， 重连后指向房间中原有的玩家
+	rooms      map[int]*GameRoom
+	nextRoomID int
+
+	lobby             *Lobby
+	matchmakingPolicy MatchmakingPolicy
+
+	onDesync func(event DesyncEvent)
 }
 
+// ServerConfig 服务器配置， 可以通过 Configure、ApplyConfig 或配置文件（LoadServerConfig）设置
 type ServerConfig struct {
-	Port                     int
-	TickRate                 int
-	MaxPlayers               int
-	HeartbeatInterval        time.Duration
-	TimeSyncTimes            int
-	AppointedServerTimeDelay time.Duration
-	SendInputInterval        float32
-	ExecutionDuration        float32
+	Port                     int           `toml:"port" yaml:"port" json:"port"`
+	Transport                string        `toml:"transport" yaml:"transport" json:"transport"` // kcp、tcp 或 websocket， 客户端需要使用相同的传输方式
+	TickRate                 int           `toml:"tick_rate" yaml:"tick_rate" json:"tick_rate"`
+	MaxPlayers               int           `toml:"max_players" yaml:"max_players" json:"max_players"`
+	HeartbeatInterval        time.Duration `toml:"heartbeat_interval" yaml:"heartbeat_interval" json:"heartbeat_interval"`
+	TimeSyncTimes            int           `toml:"time_sync_times" yaml:"time_sync_times" json:"time_sync_times"`
+	AppointedServerTimeDelay time.Duration `toml:"appointed_server_time_delay" yaml:"appointed_server_time_delay" json:"appointed_server_time_delay"`
+	SendInputInterval        float32       `toml:"send_input_interval" yaml:"send_input_interval" json:"send_input_interval"` // 秒
+	ExecutionDuration        float32       `toml:"execution_duration" yaml:"execution_duration" json:"execution_duration"`    // 秒
+	MatchmakingPolicy        string        `toml:"matchmaking_policy" yaml:"matchmaking_policy" json:"matchmaking_policy"`
+	ReconnectTimeout         time.Duration `toml:"reconnect_timeout" yaml:"reconnect_timeout" json:"reconnect_timeout"`                               // 游戏开始后断线的玩家保留多久， 超时后移出房间
+	ReplayDir                string        `toml:"replay_dir" yaml:"replay_dir" json:"replay_dir"`                                                    // 录像保存目录， 为空时不录制
+	MissingInputTimeoutTicks int           `toml:"missing_input_timeout_ticks" yaml:"missing_input_timeout_ticks" json:"missing_input_timeout_ticks"` // 输入窗口结束后等待多少个Tick， 仍未收到输入则视为该玩家无输入
+	MaxInputFrameLag         int           `toml:"max_input_frame_lag" yaml:"max_input_frame_lag" json:"max_input_frame_lag"`                         // 输入的帧号最多落后服务端当前逻辑帧多少帧
+	MaxInputFrameLead        int           `toml:"max_input_frame_lead" yaml:"max_input_frame_lead" json:"max_input_frame_lead"`                      // 输入的帧号最多超前服务端当前逻辑帧多少帧
+	MaxFrameSize             int           `toml:"max_frame_size" yaml:"max_frame_size" json:"max_frame_size"`                                        // 单条消息的最大字节数
+	SendQueueSize            int           `toml:"send_queue_size" yaml:"send_queue_size" json:"send_queue_size"`                                     // 每个连接最多缓存多少条待发送的消息
+	SendQueuePolicy          string        `toml:"send_queue_policy" yaml:"send_queue_policy" json:"send_queue_policy"`                               // 发送队列满时的处理策略， drop_oldest 或 disconnect
+	CommandRateLimit         float64       `toml:"command_rate_limit" yaml:"command_rate_limit" json:"command_rate_limit"`                            // 每个玩家每秒最多处理多少条消息， 0表示不限制
+	CommandBurst             int           `toml:"command_burst" yaml:"command_burst" json:"command_burst"`                                           // 限流时允许的突发消息数
+	LatencyProbeInterval     time.Duration `toml:"latency_probe_interval" yaml:"latency_probe_interval" json:"latency_probe_interval"`                // 服务端测量RTT的间隔， 0表示不测量
+	AdaptiveInputDelay       bool          `toml:"adaptive_input_delay" yaml:"adaptive_input_delay" json:"adaptive_input_delay"`                      // 根据RTT调整输入提前量与等待输入的时间
+	SpectatorDelay           time.Duration `toml:"spectator_delay" yaml:"spectator_delay" json:"spectator_delay"`                                     // 观战者延迟多久收到房间的广播
+	MaxSpectators            int           `toml:"max_spectators" yaml:"max_spectators" json:"max_spectators"`                                        // 每个房间的观战人数上限， 0表示不允许观战
+	AdminAddr                string        `toml:"admin_addr" yaml:"admin_addr" json:"admin_addr"`                                                    // HTTP管理接口的监听地址， 为空时不启动
+	AdminToken               string        `toml:"admin_token" yaml:"admin_token" json:"admin_token"`                                                 // 管理接口的Bearer token， 为空时不验证
+	MetricsAddr              string        `toml:"metrics_addr" yaml:"metrics_addr" json:"metrics_addr"`                                              // Prometheus指标（/metrics）的监听地址， 为空时不启动
+	LogLevel                 string        `toml:"log_level" yaml:"log_level" json:"log_level"`                                                       // debug、info、warn、error
+	LogFormat                string        `toml:"log_format" yaml:"log_format" json:"log_format"`                                                    // text 或 json， 为空时无GUI运行使用json， 否则使用text
+	LogFile                  string        `toml:"log_file" yaml:"log_file" json:"log_file"`                                                          // 同时写入的日志文件（JSON）， 为空时不写文件
+	LogMaxSizeMB             int           `toml:"log_max_size_mb" yaml:"log_max_size_mb" json:"log_max_size_mb"`                                     // 日志文件超过该大小时轮转， 0表示不轮转
+	LogMaxBackups            int           `toml:"log_max_backups" yaml:"log_max_backups" json:"log_max_backups"`                                     // 轮转后保留的旧日志文件数
+}
+
+// LogOptions 日志配置
+func (c *ServerConfig) LogOptions() logging.Options {
+	return logging.Options{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		File:       c.LogFile,
+		MaxSizeMB:  c.LogMaxSizeMB,
+		MaxBackups: c.LogMaxBackups,
+	}
 }
 
 type Player struct {
 	id              int
-	conn            *kcp.UDPSession
+	conn            transport.Conn
+	sendQueue       *sendQueue            // 与conn对应， 重连时一起替换
+	commandBucket   *dispatch.TokenBucket // 消息限流， 为nil时不限流
 	lastActive      time.Time
 	timeSyncedTimes int
 	isReady         bool
 	position        gametypes.Vector2Int
+	room            *GameRoom
+	nickname        string       // 客户端通过PLAYERINFO上报
+	logger          *slog.Logger // 带有player_id字段
+
+	sessionToken   string    // 断线重连时用于验证身份
+	disconnectedAt time.Time // 游戏中断线的时间， 为零值表示在线
+
+	nextInputWindow int // 下一个等待该玩家输入的窗口， 窗口k在第 k*窗口帧数 帧开始
+	lastInputFrame  int // 最近一次收到的输入的帧号， 用于去重
+
+	// 服务端测得的RTT， 用于计算输入延迟
+	latencyProbes       latencyProbes
+	rtt                 rttStats
+	inputDelay          int // 输入从发送到到达服务端预计需要的逻辑帧数
+	advertisedInputLead int // 最近一次通知客户端的输入提前量， 为-1时需要重新通知
+
+	spectating *GameRoom // 正在观战的房间， 观战者的room为nil
+	feedCursor int       // 下一条要发给观战者的房间广播
+}
+
+func (p *Player) String() string {
+	return fmt.Sprintf("player %d", p.id)
+}
+
+func (p *Player) isOnline() bool {
+	return p.disconnectedAt.IsZero()
+}
+
+// send 将一条消息放入玩家的发送队列
+func (p *Player) send(data []byte) error {
+	return p.sendQueue.push(data, false)
+}
+
+// sendDroppable 发送丢失后不影响游戏的消息， 发送队列满时可以被丢弃
+func (p *Player) sendDroppable(data []byte) error {
+	return p.sendQueue.push(data, true)
+}
+
+func newSessionToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		slog.Error("Failed to generate session token", logging.Err(err))
+	}
+	return hex.EncodeToString(buf)
 }
 
 func NewGameServer() *GameServer {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	server := &GameServer{
-		players:   make(map[int]*Player),
-		nextID:    1,
-		ctx:       ctx,
-		cancel:    cancel,
-		gameState: Room,
-		gameMap:   gametypes.NewGameMap(10, 10),
+		nextID:       1,
+		ctx:          ctx,
+		cancel:       cancel,
+		events:       make(chan connEvent, eventQueueSize),
+		calls:        make(chan func()),
+		sessions:     make(map[transport.Conn]*Player),
+		rooms:        make(map[int]*GameRoom),
+		nextRoomID:   1,
+		commandStats: dispatch.NewStats[fb.ClientCommand](),
+		metrics:      newServerMetrics(),
+		clock:        clock.Real,
 	}
+	server.dispatcher = server.newDispatcher()
 
 	return server
 }
 
+// Configure 使用GUI控制面板中的字符串设置基础配置， 其余字段沿用当前配置（例如配置文件）或默认值
 func (s *GameServer) Configure(port, tickRate, maxPlayers, heartbeat, timeSysncTimes, appointedServerTimeDelay, sendInputInterval, executionDuration string) error {
-	p, err := strconv.Atoi(port)
-	if err != nil {
-		return err
+	config := DefaultServerConfig()
+	if s.config != nil {
+		base := *s.config
+		config = &base
 	}
 
-	t, err := strconv.Atoi(tickRate)
-	if err != nil {
-		return err
+	var errs ConfigErrors
+	parseInt := func(field, value string) int {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("invalid integer %q", value)})
+		}
+		return v
 	}
-
-	m, err := strconv.Atoi(maxPlayers)
-	if err != nil {
-		return err
+	parseFloat := func(field, value string) float32 {
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("invalid number %q", value)})
+		}
+		return float32(v)
 	}
 
-	h, err := strconv.Atoi(heartbeat)
-	if err != nil {
-		return err
+	config.Port = parseInt("port", port)
+	config.TickRate = parseInt("tick_rate", tickRate)
+	config.MaxPlayers = parseInt("max_players", maxPlayers)
+	config.HeartbeatInterval = time.Duration(parseInt("heartbeat_interval", heartbeat)) * time.Second
+	config.TimeSyncTimes = parseInt("time_sync_times", timeSysncTimes)
+	config.AppointedServerTimeDelay = time.Duration(parseInt("appointed_server_time_delay", appointedServerTimeDelay)) * time.Second
+	// 转化为float
+	config.SendInputInterval = parseFloat("send_input_interval", sendInputInterval)
+	config.ExecutionDuration = parseFloat("execution_duration", executionDuration)
+	if len(errs) > 0 {
+		return errs
 	}
 
-	ts, err := strconv.Atoi(timeSysncTimes)
-	if err != nil {
-		return err
-	}
+	// 依赖TickRate的字段按新的TickRate重新计算
+	config.MaxInputFrameLag = 0
+	config.MaxInputFrameLead = 0
+	config.applyDerivedDefaults()
+	return s.ApplyConfig(config)
+}
 
-	a, err := strconv.Atoi(appointedServerTimeDelay)
-	if err != nil {
+// ApplyConfig 校验并使用完整的配置， 需要在Start之前调用
+func (s *GameServer) ApplyConfig(config *ServerConfig) error {
+	config.applyDerivedDefaults()
+	if err := config.Validate(); err != nil {
 		return err
 	}
+	s.config = config
+	return nil
+}
 
-	// 转化为float
-	sf, err := strconv.ParseFloat(sendInputInterval, 64)
-	if err != nil {
-		return err
-	}
+// Config 返回当前配置
+func (s *GameServer) Config() *ServerConfig {
+	return s.config
+}
 
-	// 转化为float
-	execf, err := strconv.ParseFloat(executionDuration, 64)
-	if err != nil {
-		return err
-	}
-	// 根据TickRate计算发送输入间隔帧数
-
-	s.config = &ServerConfig{
-		Port:                     p,
-		TickRate:                 t,
-		MaxPlayers:               m,
-		HeartbeatInterval:        time.Duration(h) * time.Second,
-		TimeSyncTimes:            ts,
-		AppointedServerTimeDelay: time.Duration(a) * time.Second,
-		SendInputInterval:        float32(sf),
-		ExecutionDuration:        float32(execf),
-	}
-	return nil
+// SendQueueStats 返回所有连接发送队列的统计， 可以在任意goroutine中调用
+func (s *GameServer) SendQueueStats() SendQueueStats {
+	return s.sendQueueCounters.snapshot()
+}
+
+// CommandStats 返回各个客户端命令的处理统计， 可以在任意goroutine中调用
+func (s *GameServer) CommandStats() map[fb.ClientCommand]dispatch.CommandStats {
+	return s.commandStats.Snapshot()
+}
+
+// SetOnDesync 设置发现客户端不同步时的回调， 回调在服务器主循环中执行， 不应阻塞
+func (s *GameServer) SetOnDesync(callback func(event DesyncEvent)) {
+	s.onDesync = callback
+}
+
+// SetTransport 使用自定义的传输方式， 需要在Start之前调用， 否则使用配置中的传输方式
+func (s *GameServer) SetTransport(t transport.Transport) {
+	s.transport = t
+}
+
+// SetClock 使用自定义的时钟， 需要在Start之前调用， 否则使用系统时间
+func (s *GameServer) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetMatchmakingPolicy 使用自定义的匹配策略， 需要在Start之前调用， 否则使用配置中的策略
+func (s *GameServer) SetMatchmakingPolicy(policy MatchmakingPolicy) {
+	s.matchmakingPolicy = policy
 }
 
 func (s *GameServer) Start() error {
@@ -144,311 +269,196 @@ func (s *GameServer) Start() error {
 		return fmt.Errorf("server not configured")
 	}
 
+	if s.matchmakingPolicy == nil {
+		policy, err := NewMatchmakingPolicy(s.config.MatchmakingPolicy)
+		if err != nil {
+			return err
+		}
+		s.matchmakingPolicy = policy
+	}
+
+	if s.transport == nil {
+		t, err := transport.New(s.config.Transport)
+		if err != nil {
+			return err
+		}
+		s.transport = t
+	}
+
 	var err error
-	s.listener, err = kcp.ListenWithOptions(fmt.Sprintf(":%d", s.config.Port), nil, 0, 0)
+	s.listener, err = s.transport.Listen(fmt.Sprintf(":%d", s.config.Port))
 	if err != nil {
 		return err
 	}
 
-	// Game tick routine
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		ticker := time.NewTicker(time.Second / time.Duration(s.config.TickRate))
-		defer ticker.Stop()
-
-		for {
-			select {
-			case tickTime := <-ticker.C:
-				s.tick(tickTime)
-			case <-s.ctx.Done():
-				return
-			}
-		}
-	}()
+	s.lobby = newLobby(s, s.matchmakingPolicy)
+	s.startedAt = s.clock.Now()
+	s.running.Store(true)
 
-	// Heartbeat routine
+	// Main loop
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		ticker := time.NewTicker(s.config.HeartbeatInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				s.checkHeartbeats()
-			case <-s.ctx.Done():
-				return
-			}
-		}
+		s.run()
 	}()
 
 	// Accept connections routine
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		for {
-			select {
-			case <-s.ctx.Done():
-				return
-			default:
-				conn, err := s.listener.AcceptKCP()
-				if err != nil {
-					if s.ctx.Err() != nil {
-						return // Server is shutting down
-					}
-					log.Println("Accept error:", err)
-					continue
-				}
-
-				if len(s.players) >= s.config.MaxPlayers {
-					log.Printf("Rejected connection: server full")
-					// Todo: 通知客户端连接失败
-					conn.Close()
-					continue
-				}
-
-				player := &Player{
-					id:              s.nextID,
-					conn:            conn,
-					timeSyncedTimes: 0,
-					isReady:         false,
-				}
-				s.nextID++
-				s.players[player.id] = player
-
-				// 创建进入房间消息，并发送给该玩家
-				sendEnterRoomMessage(player, s)
-				// _, err := player.conn.Write(data)
-				// if err != nil {
-				// 	log.Printf("Failed to send update to player %d: %v", player.id, err)
-				// }
-				s.wg.Add(1)
-				go func() {
-					defer s.wg.Done()
-					s.handlePlayer(player)
-				}()
-			}
-		}
+		s.acceptConnections()
 	}()
 
-	log.Printf("Server started on port %d", s.config.Port)
+	slog.Info("Server started", "port", s.config.Port, "transport", s.transport.Name())
 	return nil
 }
 
 func (s *GameServer) Stop() {
-	log.Println("Stopping server...")
+	slog.Info("Stopping server")
+	s.running.Store(false)
 	s.cancel()
 	if s.listener != nil {
 		s.listener.Close()
 	}
 	s.wg.Wait()
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
 }
 
-func (s *GameServer) checkHeartbeats() {
-	now := time.Now()
-	disconnected := make([]int, 0)
-
-	for id, player := range s.players {
-		if now.Sub(player.lastActive) > 2*s.config.HeartbeatInterval {
-			log.Printf("Player %d timeout", id)
-			disconnected = append(disconnected, id)
-		}
+// createRoom 创建一个新房间并放入指定的玩家， 玩家需已离开大厅
+func (s *GameServer) createRoom(maxPlayers int, players []*Player) *GameRoom {
+	room := newGameRoom(s.nextRoomID, maxPlayers, s)
+	s.nextRoomID++
+	s.rooms[room.id] = room
+	for _, player := range players {
+		room.addPlayer(player)
 	}
+	room.logger.Info("Room started", "players", len(room.players))
 
-	for _, id := range disconnected {
-		if player, ok := s.players[id]; ok {
-			player.conn.Close()
-			delete(s.players, id)
-		}
+	for _, player := range players {
+		sendEnterRoomMessage(player, room)
 	}
+	return room
 }
 
-func (s *GameServer) tick(tickTime time.Time) {
-	// 打印tickTime time.Time, 通道中拿取的时间跟timeNow可能存在1s的误差
-	// log.Printf("Tick at %v, TimeNow: %v", tickTime.UnixMilli(), time.Now().UnixMilli())
-	switch s.gameState {
-	case Room:
-		// 如果房间人数满了，则开始游戏
-		if len(s.players) == s.config.MaxPlayers {
-			// 检查是否全部完成了校时
-			allSynced := true
-			for _, player := range s.players {
-				if player.timeSyncedTimes < s.config.TimeSyncTimes {
-					allSynced = false
-				}
-			}
-			if allSynced {
-				// 给每个玩家随机一个不重复的出生位置
-				s.assignPlayerPositions()
-				sendStartEnterGame(s)
-				s.gameState = WaitPlayersReady
-			}
-		}
-	case WaitPlayersReady:
-		// 检查所有玩家是否准备好
-		allReady := true
-		for _, player := range s.players {
-			if !player.isReady {
-				allReady = false
-				break
-			}
-		}
-		if allReady {
-			// 计算约定的游戏开始时间（当前时间 + 延迟时间）
-			s.appointedTime = time.Now().Add(s.config.AppointedServerTimeDelay).UnixMilli()
-			sendStartGame(s)
-			s.gameState = GameCountDown
-		}
-	case GameCountDown:
-		// 检查是否到达约定的游戏开始时间
-		if tickTime.UnixMilli() >= s.appointedTime {
-			log.Printf("Game start At:%v, AppointedTime:%v", tickTime.UnixMilli(), s.appointedTime)
-			s.gameState = Game
-			s.frameCounter = 0
-			s.logicFrame = 0
-		}
-	case Game:
-		// 游戏逻辑， 服务端目前只做指令转发
-		s.frameCounter++
-		logicFrameUpdated := false
-		s.logicFrame++
-
-		// 目前配置下，相当于每0.5秒进行一次world sync
-		if s.frameCounter == s.config.TickRate/2 {
-			s.frameCounter = 0
-			logicFrameUpdated = true
-		}
-
-		// 筛选当前需要执行的命令， 服务端执行简单逻辑， 目前只计算位置， Todo: 可以考虑同步玩家位置状态做客户端校验
-		var validInputs []gametypes.PlayerInput
-		var remainingInputs []gametypes.PlayerInput
-
-		for _, input := range s.inputQueue {
-			if input.LogicFrame <= s.logicFrame {
-				validInputs = append(validInputs, input)
-			} else {
-				remainingInputs = append(remainingInputs, input)
-			}
-		}
+// joinRoom 玩家从大厅加入指定房间， 失败时返回错误码
+func (s *GameServer) joinRoom(player *Player, roomID int) int64 {
+	room, ok := s.rooms[roomID]
+	if !ok {
+		return gametypes.ErrCodeRoomNotFound
+	}
+	if !room.isJoinable() {
+		return gametypes.ErrCodeRoomNotJoinable
+	}
+	s.lobby.removePlayer(player)
+	room.addPlayer(player)
 
-		s.inputQueue = remainingInputs
-		if len(remainingInputs) != 0 {
-			// 打印剩余输入
-			log.Printf("[%d] 异常剩余玩家输入 remaining inputs: %v", s.logicFrame, remainingInputs)
-		}
+	sendEnterRoomMessage(player, room)
+	return gametypes.ErrCodeNone
+}
 
-		// 服务端更新玩家位置
-		if len(validInputs) != 0 {
-			// Todo: 服务端更新玩家位置, 以后实现
-		}
+// leaveRoom 玩家离开房间回到大厅， 游戏开始后不能离开
+func (s *GameServer) leaveRoom(player *Player) int64 {
+	if player.spectating != nil {
+		s.stopSpectating(player)
+		return gametypes.ErrCodeNone
+	}
+	room := player.room
+	if room == nil {
+		return gametypes.ErrCodeNotInRoom
+	}
+	if room.gameState != Room {
+		return gametypes.ErrCodeRoomStarted
+	}
+	room.removePlayer(player)
+	s.lobby.addPlayer(player)
 
-		if logicFrameUpdated {
-			sendWorldSync(s)
-		}
+	sendLeaveRoom(player)
+	return gametypes.ErrCodeNone
+}
 
-	default:
-		return
+// listRooms 返回当前所有房间的概要信息， 按房间ID排序
+func (s *GameServer) listRooms() gametypes.RoomList {
+	rooms := make([]gametypes.RoomInfo, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, gametypes.RoomInfo{
+			RoomID:      room.id,
+			PlayerCount: len(room.players),
+			MaxPlayers:  room.maxPlayers,
+			State:       int(room.gameState),
+		})
 	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].RoomID < rooms[j].RoomID
+	})
+	return gametypes.RoomList{Rooms: rooms}
 }
 
-func (s *GameServer) handlePlayer(player *Player) {
-	// 初始化最后活动时间
-	player.lastActive = time.Now()
-
-	// 在函数开始时记录客户端连接
-	log.Printf("Player %d (%s) connected", player.id, player.conn.RemoteAddr())
-
-	// 确保在函数返回时清理玩家
-	defer func() {
-		log.Printf("Player %d (%s) disconnected", player.id, player.conn.RemoteAddr())
-		delete(s.players, player.id)
-		player.conn.Close()
-	}()
-
-	buffer := make([]byte, 1024)
-	for {
-		n, err := player.conn.Read(buffer)
-		if err != nil {
-			log.Printf("Player %d connection error: %v", player.id, err)
-			return
-		}
-		// 更新最后活动时间
-		player.lastActive = time.Now()
-
-		// 解析接收到的消息
-		c2sCommand := fb.GetRootAsC2SCommand(buffer[:n], 0)
-
-		// 根据消息类型处理
-		switch c2sCommand.Command() {
-		case fb.ClientCommandC2S_COMMAND_PING:
-			// 返回Pong
-			sendPong(player)
-		case fb.ClientCommandC2S_COMMAND_REQUESTTIME:
-			sendResponseTime(player)
-			player.timeSyncedTimes++
-		case fb.ClientCommandC2S_COMMAND_PLAYERINFO:
-			// Todo: 更新玩家信息
-		case fb.ClientCommandC2S_COMMAND_GAMELOADED:
-			// 更新玩家准备状态
-			player.isReady = true
-		case fb.ClientCommandC2S_COMMAND_PLAYERINPUT:
-			// 玩家输入存入缓存队列
-			playerInput := serialization.DeserializePlayerInput(c2sCommand.BodyBytes())
-			log.Printf("Player %d input: %v", player.id, playerInput)
-			s.inputQueue = append(s.inputQueue, playerInput)
-			// Todo: 目前直接转发, 以后考虑是否增加跟当前逻辑帧的校验关系
-			sendPlayerInput(s, &playerInput)
-		default:
-			log.Printf("Unknown command from player %d: %d", player.id, c2sCommand.Command())
-		}
+// disconnectPlayer 玩家的连接断开， 游戏开始后保留玩家等待重连， 否则直接移除
+func (s *GameServer) disconnectPlayer(player *Player) {
+	if player.spectating != nil {
+		player.spectating.removeSpectator(player)
+		return
+	}
+	room := player.room
+	if room == nil {
+		s.lobby.removePlayer(player)
+		return
 	}
+	if room.gameState == Room {
+		room.removePlayer(player)
+		return
+	}
+	player.disconnectedAt = s.clock.Now()
+	room.logger.Info("Player disconnected, waiting for reconnect", logging.KeyPlayerID, player.id, "reconnect_timeout", s.config.ReconnectTimeout)
 }
 
-func (s *GameServer) assignPlayerPositions() {
-	positions := make(map[int]*gametypes.Vector2Int)
-	availablePositions := make([]gametypes.Vector2Int, 0)
-
-	// Calculate center offsets
-	centerX := s.gameMap.MapData.Width / 2
-	centerY := s.gameMap.MapData.Height / 2
-
-	// Create list of all possible positions
-	// Excluding extreme edges for better gameplay
-	for x := 1; x < s.gameMap.MapData.Width-1; x++ {
-		for y := 1; y < s.gameMap.MapData.Height-1; y++ {
-			// Convert to centered coordinate system where (0,0) is the center
-			centeredX := x - centerX
-			centeredY := y - centerY
-			availablePositions = append(availablePositions, gametypes.Vector2Int{X: centeredX, Y: centeredY})
+// reconnectPlayer 将大厅中新连接的玩家重新绑定到房间中原有的玩家， 成功时返回原有的玩家
+func (s *GameServer) reconnectPlayer(player *Player, playerID int, sessionToken string) (*Player, int64) {
+	var target *Player
+	for _, room := range s.rooms {
+		if p, ok := room.players[playerID]; ok {
+			target = p
+			break
 		}
 	}
+	if target == nil || sessionToken == "" || target.sessionToken != sessionToken {
+		return nil, gametypes.ErrCodeSessionNotFound
+	}
 
-	// Randomly assign positions to players
-	for playerID := range s.players {
-		if len(availablePositions) == 0 {
-			log.Printf("Warning: No more positions available for player %d", playerID)
-			continue
-		}
-
-		// Pick random position from available positions
-		idx := rand.IntN(len(availablePositions))
-		pos := availablePositions[idx]
-
-		// Remove used position by swapping with last element and shrinking slice
-		availablePositions[idx] = availablePositions[len(availablePositions)-1]
-		availablePositions = availablePositions[:len(availablePositions)-1]
+	// 旧的连接可能还没有超时， 解除绑定后它的断线事件会被忽略
+	oldConn := target.conn
+	delete(s.sessions, oldConn)
+	target.sendQueue.close()
+	oldConn.Close()
+
+	s.lobby.removePlayer(player)
+	s.sessions[player.conn] = target
+	target.conn = player.conn
+	target.sendQueue = player.sendQueue
+	target.lastActive = s.clock.Now()
+	target.disconnectedAt = time.Time{}
+	// 继续使用之前测得的RTT， 由之后的探测修正， 并重新通知客户端输入提前量
+	target.latencyProbes = player.latencyProbes
+	target.advertisedInputLead = -1
+	return target, gametypes.ErrCodeNone
+}
 
-		positions[playerID] = &gametypes.Vector2Int{X: pos.X, Y: pos.Y}
-		log.Printf("Assigned position (%v, %v) to player %d", pos.X, pos.Y, playerID)
+// removeRoom 房间结束后从服务器中移除
+func (s *GameServer) removeRoom(room *GameRoom) {
+	room.recorder.close()
+	delete(s.rooms, room.id)
+	room.logger.Info("Room removed", "rooms", len(s.rooms))
+
+	// 结束前刚加入的玩家回到大厅
+	for _, player := range room.players {
+		room.removePlayer(player)
+		s.lobby.addPlayer(player)
+		sendLeaveRoom(player)
 	}
 
-	// 更新玩家位置
-	for playerID, position := range positions {
-		if player, ok := s.players[playerID]; ok {
-			player.position = *position
-		}
+	// 观战者收到剩余的广播后回到大厅
+	room.flushSpectators()
+	for _, spectator := range room.spectators {
+		s.stopSpectating(spectator)
 	}
 }
@@ -0,0 +1,271 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"gameproject/fb"
+	"gameproject/source/dispatch"
+	"gameproject/source/gametypes"
+	"gameproject/source/logging"
+	"gameproject/source/serialization"
+	"log/slog"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// commandError 处理失败时回复给客户端的错误， reply 为回复使用的命令
+type commandError struct {
+	reply   fb.ServerCommand
+	code    int64
+	message string
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.message, e.code)
+}
+
+func failWith(reply fb.ServerCommand, code int64, message string) error {
+	return &commandError{reply: reply, code: code, message: message}
+}
+
+var errNotInRoom = errors.New("player is not in a room")
+
+// notInRoom 只能在大厅中执行的命令， 观战者需要先离开房间
+func notInRoom(reply fb.ServerCommand, code int64, message string) dispatch.Precondition[*Player] {
+	return func(player *Player) error {
+		if player.room != nil || player.spectating != nil {
+			return failWith(reply, code, message)
+		}
+		return nil
+	}
+}
+
+// inRoom 只能在房间中执行的命令， 不在房间时不回复
+func inRoom(player *Player) error {
+	if player.room == nil {
+		return errNotInRoom
+	}
+	return nil
+}
+
+// gameRunning 只能在游戏进行中执行的命令， 需要在 inRoom 之后检查
+func gameRunning(reply fb.ServerCommand) dispatch.Precondition[*Player] {
+	return func(player *Player) error {
+		if player.room.gameState != Game {
+			return failWith(reply, gametypes.ErrCodeGameNotStarted, "game not started")
+		}
+		return nil
+	}
+}
+
+// optional 消息体可以为空， 为空时返回nil
+func optional[T any](getRoot func(buf []byte, offset flatbuffers.UOffsetT) *T) dispatch.Decoder[*T] {
+	return func(body []byte) (*T, error) {
+		if len(body) == 0 {
+			return nil, nil
+		}
+		return getRoot(body, 0), nil
+	}
+}
+
+// required 消息体不能为空， 为空时回复错误
+func required[T any](decode dispatch.Decoder[T], reply fb.ServerCommand, code int64, message string) dispatch.Decoder[T] {
+	return func(body []byte) (T, error) {
+		if len(body) == 0 {
+			var zero T
+			return zero, failWith(reply, code, message)
+		}
+		return decode(body)
+	}
+}
+
+// newDispatcher 注册所有客户端命令的处理函数
+func (s *GameServer) newDispatcher() *dispatch.Registry[fb.ClientCommand, *Player] {
+	r := dispatch.NewRegistry[fb.ClientCommand, *Player]()
+	r.Use(
+		dispatch.StatsMiddleware[fb.ClientCommand, *Player](s.commandStats),
+		dispatch.Logging[fb.ClientCommand](func(player *Player) *slog.Logger {
+			return player.logger
+		}),
+		dispatch.Recover[fb.ClientCommand, *Player](),
+		dispatch.RateLimit[fb.ClientCommand](func(player *Player) *dispatch.TokenBucket {
+			return player.commandBucket
+		}),
+	)
+
+	dispatch.RegisterEmpty(r, fb.ClientCommandC2S_COMMAND_PING, s.handlePing)
+	dispatch.RegisterEmpty(r, fb.ClientCommandC2S_COMMAND_REQUESTTIME, s.handleRequestTime)
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_LATENCYPROBE, dispatch.Table(fb.GetRootAsC2SLatencyProbe), s.handleLatencyProbe)
+	dispatch.RegisterEmpty(r, fb.ClientCommandC2S_COMMAND_LISTROOMS, s.handleListRooms)
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_CREATEROOM,
+		optional(fb.GetRootAsC2SCreateRoom),
+		s.handleCreateRoom,
+		notInRoom(fb.ServerCommandS2C_COMMAND_ENTERROOM, gametypes.ErrCodeAlreadyInRoom, "already in room"))
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_JOINROOM,
+		required(dispatch.Table(fb.GetRootAsC2SJoinRoom), fb.ServerCommandS2C_COMMAND_ENTERROOM, gametypes.ErrCodeRoomNotFound, "missing room id"),
+		s.handleJoinRoom,
+		notInRoom(fb.ServerCommandS2C_COMMAND_ENTERROOM, gametypes.ErrCodeAlreadyInRoom, "already in room"))
+	dispatch.RegisterEmpty(r, fb.ClientCommandC2S_COMMAND_LEAVEROOM, s.handleLeaveRoom)
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_QUICKMATCH,
+		optional(fb.GetRootAsC2SQuickMatch),
+		s.handleQuickMatch,
+		notInRoom(fb.ServerCommandS2C_COMMAND_QUICKMATCH, gametypes.ErrCodeAlreadyInRoom, "already in room"))
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_SPECTATE,
+		optional(fb.GetRootAsC2SSpectate),
+		s.handleSpectate,
+		notInRoom(fb.ServerCommandS2C_COMMAND_SPECTATE, gametypes.ErrCodeAlreadyInRoom, "already in room"))
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_PLAYERINFO,
+		optional(fb.GetRootAsC2SPlayerInfo),
+		s.handlePlayerInfo)
+	dispatch.RegisterEmpty(r, fb.ClientCommandC2S_COMMAND_GAMELOADED, s.handleGameLoaded)
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_PLAYERINPUT,
+		required(dispatch.Decode(serialization.DeserializePlayerInput), fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC, gametypes.ErrCodeInvalidCommand, "missing input"),
+		s.handlePlayerInput,
+		inRoom, gameRunning(fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC))
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_STATEHASH,
+		dispatch.Table(fb.GetRootAsC2SStateHash),
+		s.handleStateHash,
+		inRoom)
+	dispatch.Register(r, fb.ClientCommandC2S_COMMAND_RECONNECT,
+		required(dispatch.Table(fb.GetRootAsC2SReconnect), fb.ServerCommandS2C_COMMAND_CATCHUP, gametypes.ErrCodeSessionNotFound, "invalid reconnect request"),
+		s.handleReconnect,
+		notInRoom(fb.ServerCommandS2C_COMMAND_CATCHUP, gametypes.ErrCodeSessionNotFound, "invalid reconnect request"))
+	return r
+}
+
+// handleCommand 处理玩家的一条消息， 在主循环中执行
+func (s *GameServer) handleCommand(player *Player, command fb.ClientCommand, body []byte) {
+	err := s.dispatcher.Dispatch(player, command, body)
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		s.metrics.commandFailed(cmdErr.code)
+		sendFail(player, cmdErr.reply, cmdErr.code, cmdErr.message)
+	}
+}
+
+func (s *GameServer) handlePing(player *Player) error {
+	// 返回Pong
+	sendPong(player)
+	return nil
+}
+
+func (s *GameServer) handleRequestTime(player *Player) error {
+	player.timeSyncedTimes++
+	sendResponseTime(player, s.clock.Now())
+	return nil
+}
+
+func (s *GameServer) handleLatencyProbe(player *Player, probe *fb.C2SLatencyProbe) error {
+	s.recordLatency(player, int(probe.Id()))
+	return nil
+}
+
+func (s *GameServer) handleListRooms(player *Player) error {
+	roomList := s.listRooms()
+	sendRoomList(player, &roomList)
+	return nil
+}
+
+func (s *GameServer) handleCreateRoom(player *Player, createRoom *fb.C2SCreateRoom) error {
+	maxPlayers := 0
+	if createRoom != nil {
+		maxPlayers = int(createRoom.MaxPlayers())
+	}
+	if maxPlayers <= 0 || maxPlayers > s.config.MaxPlayers {
+		maxPlayers = s.config.MaxPlayers
+	}
+	s.lobby.removePlayer(player)
+	s.createRoom(maxPlayers, []*Player{player})
+	return nil
+}
+
+func (s *GameServer) handleJoinRoom(player *Player, joinRoom *fb.C2SJoinRoom) error {
+	roomID := int(joinRoom.RoomId())
+	if code := s.joinRoom(player, roomID); code != gametypes.ErrCodeNone {
+		return failWith(fb.ServerCommandS2C_COMMAND_ENTERROOM, code, fmt.Sprintf("can not join room %d", roomID))
+	}
+	return nil
+}
+
+func (s *GameServer) handleLeaveRoom(player *Player) error {
+	if code := s.leaveRoom(player); code != gametypes.ErrCodeNone {
+		return failWith(fb.ServerCommandS2C_COMMAND_LEAVEROOM, code, "can not leave room")
+	}
+	return nil
+}
+
+func (s *GameServer) handleSpectate(player *Player, spectate *fb.C2SSpectate) error {
+	roomID := 0
+	if spectate != nil {
+		roomID = int(spectate.RoomId())
+	}
+	if code := s.spectate(player, roomID); code != gametypes.ErrCodeNone {
+		return failWith(fb.ServerCommandS2C_COMMAND_SPECTATE, code, fmt.Sprintf("can not spectate room %d", roomID))
+	}
+	return nil
+}
+
+func (s *GameServer) handleQuickMatch(player *Player, quickMatch *fb.C2SQuickMatch) error {
+	skillRating, partyID := 0, 0
+	if quickMatch != nil {
+		skillRating, partyID = int(quickMatch.SkillRating()), int(quickMatch.PartyId())
+	}
+	queueSize := s.lobby.enqueue(player, skillRating, partyID)
+	sendQuickMatch(player, queueSize)
+	return nil
+}
+
+func (s *GameServer) handlePlayerInfo(player *Player, playerInfo *fb.C2SPlayerInfo) error {
+	if playerInfo == nil {
+		return nil
+	}
+	player.nickname = string(playerInfo.Nickname())
+	player.logger.Info("Player nickname", "nickname", player.nickname)
+	return nil
+}
+
+func (s *GameServer) handleGameLoaded(player *Player) error {
+	// 更新玩家准备状态
+	player.isReady = true
+	return nil
+}
+
+func (s *GameServer) handlePlayerInput(player *Player, playerInput gametypes.PlayerInput) error {
+	room := player.room
+	s.metrics.inputsReceived.Add(1)
+	room.logger.Debug("Player input", logging.KeyPlayerID, player.id, logging.KeyLogicFrame, playerInput.LogicFrame, "input", playerInput)
+	// 校验身份、帧号范围与重复输入， 不可信的输入不计入输入窗口
+	if code, err := room.verifyInput(player, playerInput); err != nil {
+		return failWith(fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC, code, err.Error())
+	}
+	player.lastInputFrame = playerInput.LogicFrame
+	// 空输入只能由服务端生成
+	playerInput.Substituted = false
+	// 命令未通过校验的输入同样说明玩家按时发送了本窗口的输入
+	room.markInputReceived(player)
+	// 基于执行完已缓存的输入之后的状态校验命令， 不合法的输入不转发
+	if err := room.validateInput(playerInput); err != nil {
+		return failWith(fb.ServerCommandS2C_COMMAND_PLAYERINPUTSYNC, gametypes.ErrCodeInvalidCommand, err.Error())
+	}
+	// 玩家输入存入缓存队列
+	room.queueInput(playerInput)
+	sendPlayerInput(room, &playerInput)
+	return nil
+}
+
+func (s *GameServer) handleStateHash(player *Player, stateHash *fb.C2SStateHash) error {
+	player.room.reportStateHash(player, int(stateHash.LogicFrame()), stateHash.Hash())
+	return nil
+}
+
+func (s *GameServer) handleReconnect(player *Player, reconnect *fb.C2SReconnect) error {
+	target, code := s.reconnectPlayer(player, int(reconnect.PlayerId()), string(reconnect.SessionToken()))
+	if code != gametypes.ErrCodeNone {
+		return failWith(fb.ServerCommandS2C_COMMAND_CATCHUP, code, "reconnect failed")
+	}
+	target.room.logger.Info("Player reconnected", logging.KeyPlayerID, target.id, "connection_player_id", player.id)
+	// 之后该连接上的消息都属于原有的玩家
+	sendEnterRoomMessage(target, target.room)
+	sendCatchUp(target, target.room, target.room.catchUp())
+	return nil
+}
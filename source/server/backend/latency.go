@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"gameproject/source/logging"
+	"math"
+	"time"
+)
+
+// rttStats 平滑的RTT， 计算方式与TCP的SRTT、RTTVAR相同
+type rttStats struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	samples int
+}
+
+func (r *rttStats) add(sample time.Duration) {
+	if r.samples == 0 {
+		r.srtt = sample
+		r.rttvar = sample / 2
+	} else {
+		diff := r.srtt - sample
+		if diff < 0 {
+			diff = -diff
+		}
+		r.rttvar = (3*r.rttvar + diff) / 4
+		r.srtt = (7*r.srtt + sample) / 8
+	}
+	r.samples++
+}
+
+// 同时等待回复的探测数， RTT大于 探测间隔*该值 时无法测量
+const maxPendingProbes = 8
+
+// latencyProbes 等待回复的RTT探测， 按id循环使用
+type latencyProbes struct {
+	nextID int
+	sentAt [maxPendingProbes]time.Time
+}
+
+// probeLatency 向所有连接发送RTT探测
+func (s *GameServer) probeLatency(now time.Time) {
+	for _, player := range s.sessions {
+		probes := &player.latencyProbes
+		probes.nextID++
+		probes.sentAt[probes.nextID%maxPendingProbes] = now
+		sendLatencyProbe(player, probes.nextID)
+	}
+}
+
+// recordLatency 收到探测的回复， 过期或重复的回复不计入
+func (s *GameServer) recordLatency(player *Player, probeID int) {
+	probes := &player.latencyProbes
+	if probeID <= 0 || probeID > probes.nextID || probes.nextID-probeID >= maxPendingProbes {
+		return
+	}
+	slot := probeID % maxPendingProbes
+	if probes.sentAt[slot].IsZero() {
+		return
+	}
+	player.rtt.add(s.clock.Since(probes.sentAt[slot]))
+	probes.sentAt[slot] = time.Time{}
+
+	if player.room != nil && s.config.AdaptiveInputDelay {
+		player.room.updateInputDelay(player)
+	}
+}
+
+// inputDelayFrames 玩家的输入从发送到到达服务端预计需要的逻辑帧数
+// 单程延迟按 SRTT/2 加上两倍的RTT波动估计， 再加一帧抵消客户端Tick的误差
+func (r *GameRoom) inputDelayFrames(player *Player) int {
+	if player.rtt.samples == 0 {
+		return 0
+	}
+	oneWay := player.rtt.srtt/2 + 2*player.rtt.rttvar
+	return int(math.Ceil(oneWay.Seconds()*float64(r.config.TickRate))) + 1
+}
+
+// maxInputLead 输入最多提前的帧数， 提前超过半个窗口的输入会计入上一个窗口
+func (r *GameRoom) maxInputLead() int {
+	lead := r.inputWindowFrames()/2 - 1
+	if lead < 0 {
+		lead = 0
+	}
+	return lead
+}
+
+// inputLead 客户端提前发送输入的帧数， 超出的部分由服务端延长等待输入的时间
+func (r *GameRoom) inputLead(player *Player) int {
+	return min(player.inputDelay, r.maxInputLead())
+}
+
+// updateInputDelay 根据最新的RTT调整玩家的输入延迟， 输入提前量变化时通知客户端
+// 增大立即生效， 减小超过1帧时才调整， 避免在相邻的两个值之间反复
+func (r *GameRoom) updateInputDelay(player *Player) {
+	delay := r.inputDelayFrames(player)
+	if delay < player.inputDelay && player.inputDelay-delay < 2 {
+		delay = player.inputDelay
+	}
+	player.inputDelay = delay
+
+	lead := r.inputLead(player)
+	if lead == player.advertisedInputLead {
+		return
+	}
+	player.advertisedInputLead = lead
+	r.logger.Info("Input lead changed",
+		logging.KeyPlayerID, player.id,
+		"rtt", player.rtt.srtt,
+		"rtt_var", player.rtt.rttvar,
+		"input_delay", player.inputDelay,
+		"input_lead", lead)
+	sendInputDelay(player, lead, player.rtt.srtt)
+}
@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"time"
+)
+
+// 匹配队列的处理间隔
+const matchmakingInterval = 500 * time.Millisecond
+
+// Lobby 大厅， 保存尚未进入房间的玩家与匹配队列
+type Lobby struct {
+	server *GameServer
+	policy MatchmakingPolicy
+
+	players map[int]*Player
+	queue   []*MatchTicket
+}
+
+func newLobby(server *GameServer, policy MatchmakingPolicy) *Lobby {
+	return &Lobby{
+		server:  server,
+		policy:  policy,
+		players: make(map[int]*Player),
+	}
+}
+
+func (l *Lobby) addPlayer(player *Player) {
+	player.room = nil
+	player.timeSyncedTimes = 0
+	player.isReady = false
+	l.players[player.id] = player
+	player.logger.Info("Player entered lobby", "lobby_players", len(l.players))
+}
+
+// removePlayer 玩家离开大厅（进入房间或断开连接）， 同时移出匹配队列
+func (l *Lobby) removePlayer(player *Player) {
+	delete(l.players, player.id)
+	for i, ticket := range l.queue {
+		if ticket.player == player {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// enqueue 玩家进入匹配队列， 返回当前队列人数
+func (l *Lobby) enqueue(player *Player, skillRating, partyID int) int {
+	for _, ticket := range l.queue {
+		if ticket.player == player {
+			// 重复请求时更新匹配信息
+			ticket.SkillRating = skillRating
+			ticket.PartyID = partyID
+			return len(l.queue)
+		}
+	}
+
+	l.queue = append(l.queue, &MatchTicket{
+		player:      player,
+		SkillRating: skillRating,
+		PartyID:     partyID,
+		EnqueuedAt:  l.server.clock.Now(),
+	})
+	player.logger.Info("Player queued for quick match", "rating", skillRating, "party", partyID, "queue_size", len(l.queue))
+	return len(l.queue)
+}
+
+// matchmake 执行一次匹配， 匹配成功的玩家离开大厅进入新房间
+func (l *Lobby) matchmake(now time.Time) {
+	roomSize := l.server.config.MaxPlayers
+	var groups [][]*Player
+	if len(l.queue) >= roomSize {
+		// 先取出玩家再修改队列， 策略返回的分组可能与队列共用底层数组
+		for _, group := range l.policy.Match(l.queue, roomSize, now) {
+			players := make([]*Player, 0, len(group))
+			for _, ticket := range group {
+				players = append(players, ticket.player)
+			}
+			groups = append(groups, players)
+		}
+	}
+	for _, players := range groups {
+		for _, player := range players {
+			l.removePlayer(player)
+		}
+	}
+
+	for _, players := range groups {
+		room := l.server.createRoom(roomSize, players)
+		room.logger.Info("Matched players into room", "players", len(players), "policy", l.policy.Name())
+	}
+}
+
+// checkHeartbeats 关闭超时玩家的连接， 玩家在随后的断线事件中离开大厅
+func (l *Lobby) checkHeartbeats() {
+	now := l.server.clock.Now()
+	for _, player := range l.players {
+		if now.Sub(player.lastActive) > 2*l.server.config.HeartbeatInterval {
+			player.logger.Warn("Player heartbeat timeout in lobby")
+			l.server.metrics.heartbeatTimeout("lobby")
+			player.conn.Close()
+		}
+	}
+}
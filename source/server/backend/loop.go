@@ -0,0 +1,231 @@
+package backend
+
+import (
+	"gameproject/fb"
+	"gameproject/source/dispatch"
+	"gameproject/source/framing"
+	"gameproject/source/logging"
+	"gameproject/source/transport"
+	"log/slog"
+	"time"
+)
+
+// 事件队列的长度， 主循环处理不过来时读取连接的goroutine会阻塞， 由KCP的窗口向客户端施加背压
+const eventQueueSize = 1024
+
+type connEventKind int
+
+const (
+	connConnected connEventKind = iota
+	connMessage
+	connDisconnected
+)
+
+// connEvent 连接上发生的事件， 由接收连接与读取连接的goroutine发送给主循环
+// 同一个连接的事件按发生的顺序进入同一个channel， 主循环处理时不会乱序
+type connEvent struct {
+	kind    connEventKind
+	conn    transport.Conn
+	command fb.ClientCommand // connMessage， 外层已经在读取连接的goroutine中检查并解析
+	body    []byte           // connMessage
+	size    int              // connMessage， 整条消息的字节数
+	err     error            // connDisconnected
+}
+
+// postEvent 把事件交给主循环， 服务器停止后返回false
+func (s *GameServer) postEvent(event connEvent) bool {
+	select {
+	case s.events <- event:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// acceptConnections 接收新连接， 每个连接启动一个goroutine读取消息
+func (s *GameServer) acceptConnections() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return // Server is shutting down
+			}
+			slog.Error("Accept error", logging.Err(err))
+			continue
+		}
+		if !s.postEvent(connEvent{kind: connConnected, conn: conn}) {
+			conn.Close()
+			return
+		}
+		// 停止时已经放入队列的新连接可能不会再被主循环处理， 由这里关闭， 读取的goroutine随之退出
+		if s.ctx.Err() != nil {
+			conn.Close()
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.readConnection(conn)
+		}()
+	}
+}
+
+// readConnection 读取并解析连接上的消息， 只访问连接本身， 玩家状态由主循环处理
+func (s *GameServer) readConnection(conn transport.Conn) {
+	reader := framing.NewReader(conn, s.config.MaxFrameSize)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			s.postEvent(connEvent{kind: connDisconnected, conn: conn, err: err})
+			return
+		}
+		// 外层无法解析的消息来自有问题或恶意的客户端， 断开连接， 不交给主循环
+		// frame 由每次读取新分配， 消息体可以直接交给主循环
+		command, body, err := decodeC2SCommand(frame)
+		if err != nil {
+			s.metrics.invalidMessages.Add(1)
+			slog.Warn("Connection sent invalid message", logging.KeyAddr, conn.RemoteAddr().String(), "size", len(frame), logging.Err(err))
+			s.postEvent(connEvent{kind: connDisconnected, conn: conn, err: err})
+			return
+		}
+		if !s.postEvent(connEvent{kind: connMessage, conn: conn, command: command, body: body, size: len(frame)}) {
+			return
+		}
+	}
+}
+
+// run 服务器主循环， 大厅、房间与玩家的所有状态只在这个goroutine中访问
+func (s *GameServer) run() {
+	tickTicker := s.clock.NewTicker(time.Second / time.Duration(s.config.TickRate))
+	defer tickTicker.Stop()
+
+	matchTicker := s.clock.NewTicker(matchmakingInterval)
+	defer matchTicker.Stop()
+
+	heartbeatTicker := s.clock.NewTicker(s.config.HeartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	// 不测量RTT时使用永远不会触发的channel
+	var probeC <-chan time.Time
+	if s.config.LatencyProbeInterval > 0 {
+		probeTicker := s.clock.NewTicker(s.config.LatencyProbeInterval)
+		defer probeTicker.Stop()
+		probeC = probeTicker.C()
+	}
+
+	defer s.shutdown()
+
+	for {
+		select {
+		case event := <-s.events:
+			s.handleEvent(event)
+		case call := <-s.calls:
+			call()
+		case tickTime := <-tickTicker.C():
+			s.tickRooms(tickTime)
+		case now := <-matchTicker.C():
+			s.lobby.matchmake(now)
+		case now := <-probeC:
+			s.probeLatency(now)
+		case <-heartbeatTicker.C():
+			s.lobby.checkHeartbeats()
+			for _, room := range s.rooms {
+				room.checkHeartbeats()
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *GameServer) handleEvent(event connEvent) {
+	switch event.kind {
+	case connConnected:
+		queue := newSendQueue(event.conn, s.config.SendQueueSize, s.config.SendQueuePolicy, &s.sendQueueCounters, s.metrics.recordSent)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			queue.run()
+		}()
+
+		player := &Player{
+			id:              s.nextID,
+			conn:            event.conn,
+			sendQueue:       queue,
+			lastActive:      s.clock.Now(),
+			timeSyncedTimes: 0,
+			isReady:         false,
+			sessionToken:    newSessionToken(),
+			logger:          slog.Default().With(logging.KeyPlayerID, s.nextID),
+		}
+		if s.config.CommandRateLimit > 0 {
+			player.commandBucket = dispatch.NewTokenBucket(s.config.CommandRateLimit, s.config.CommandBurst)
+		}
+		s.nextID++
+		s.sessions[event.conn] = player
+		player.logger.Info("Player connected", logging.KeyAddr, event.conn.RemoteAddr().String())
+
+		// 新连接的玩家先进入大厅， 由玩家选择房间或进入匹配队列
+		s.lobby.addPlayer(player)
+		sendEnterLobby(player)
+	case connMessage:
+		player, ok := s.sessions[event.conn]
+		if !ok {
+			// 连接已被重连替换或玩家已被移除
+			return
+		}
+		// 更新最后活动时间
+		player.lastActive = s.clock.Now()
+		// 外层已经在读取连接时检查并解析， 这里只使用解析出的命令与长度， 不再访问消息本身
+		s.metrics.received.add(event.command.String(), event.size)
+		s.handleCommand(player, event.command, event.body)
+	case connDisconnected:
+		player, ok := s.sessions[event.conn]
+		if !ok {
+			return
+		}
+		delete(s.sessions, event.conn)
+		player.logger.Info("Player disconnected", logging.KeyAddr, event.conn.RemoteAddr().String(), logging.Err(event.err))
+		s.disconnectPlayer(player)
+		player.sendQueue.close()
+		event.conn.Close()
+	}
+}
+
+// tickRooms 推进所有房间， 游戏结束或没有玩家的房间在Tick后移除
+func (s *GameServer) tickRooms(tickTime time.Time) {
+	// Tick的耗时是服务器自身的性能， 使用系统时间
+	start := time.Now()
+	defer func() {
+		s.metrics.observeTick(tickTime, time.Since(start), time.Second/time.Duration(s.config.TickRate))
+	}()
+	for _, room := range s.rooms {
+		room.tick(tickTime)
+		room.deliverToSpectators(tickTime)
+		if room.gameState == GameOver {
+			room.logger.Info("Game over")
+			s.removeRoom(room)
+		}
+	}
+}
+
+// shutdown 主循环退出时关闭所有连接与录像， 读取连接的goroutine随之退出
+func (s *GameServer) shutdown() {
+	for _, room := range s.rooms {
+		room.recorder.close()
+	}
+	for conn, player := range s.sessions {
+		player.sendQueue.close()
+		conn.Close()
+	}
+	// 停止前放入队列、尚未成为玩家的新连接也要关闭， 否则读取它的goroutine不会退出， Stop 一直等待
+	for {
+		select {
+		case event := <-s.events:
+			if event.kind == connConnected {
+				event.conn.Close()
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MatchTicket 一个玩家的匹配请求
+type MatchTicket struct {
+	player      *Player
+	SkillRating int
+	PartyID     int // 0表示单排
+	EnqueuedAt  time.Time
+}
+
+func (t *MatchTicket) PlayerID() int {
+	return t.player.id
+}
+
+// MatchmakingPolicy 匹配策略， 决定匹配队列中的哪些玩家组成一局
+type MatchmakingPolicy interface {
+	Name() string
+	// Match 从队列中挑选人数恰好为roomSize的若干组， 每一组会被放入同一个新房间
+	// queue 按进入队列的先后排序， 返回的票据会从队列中移除
+	Match(queue []*MatchTicket, roomSize int, now time.Time) [][]*MatchTicket
+}
+
+// NewMatchmakingPolicy 根据配置中的名字创建匹配策略
+func NewMatchmakingPolicy(name string) (MatchmakingPolicy, error) {
+	switch name {
+	case "", "firstcome":
+		return &FirstComePolicy{}, nil
+	case "skill":
+		return &SkillRatingPolicy{MaxSpread: 100, SpreadPerSecond: 20}, nil
+	case "party":
+		return &PartyPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown matchmaking policy: %s", name)
+	}
+}
+
+// FirstComePolicy 先到先得， 按排队顺序每满roomSize人组成一局
+type FirstComePolicy struct{}
+
+func (p *FirstComePolicy) Name() string {
+	return "firstcome"
+}
+
+func (p *FirstComePolicy) Match(queue []*MatchTicket, roomSize int, now time.Time) [][]*MatchTicket {
+	var groups [][]*MatchTicket
+	for len(queue) >= roomSize {
+		groups = append(groups, queue[:roomSize])
+		queue = queue[roomSize:]
+	}
+	return groups
+}
+
+// SkillRatingPolicy 按技能分匹配， 一组内最高分与最低分之差不超过允许范围
+// 允许范围随组内等待最久的玩家的等待时间放宽， 避免高分或低分玩家一直匹配不到
+type SkillRatingPolicy struct {
+	MaxSpread       int // 初始允许的分差
+	SpreadPerSecond int // 每等待一秒放宽的分差
+}
+
+func (p *SkillRatingPolicy) Name() string {
+	return "skill"
+}
+
+func (p *SkillRatingPolicy) Match(queue []*MatchTicket, roomSize int, now time.Time) [][]*MatchTicket {
+	sorted := make([]*MatchTicket, len(queue))
+	copy(sorted, queue)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].SkillRating < sorted[j].SkillRating
+	})
+
+	var groups [][]*MatchTicket
+	for i := 0; i+roomSize <= len(sorted); {
+		window := sorted[i : i+roomSize]
+		if window[roomSize-1].SkillRating-window[0].SkillRating <= p.allowedSpread(window, now) {
+			groups = append(groups, window)
+			i += roomSize
+		} else {
+			i++
+		}
+	}
+	return groups
+}
+
+func (p *SkillRatingPolicy) allowedSpread(group []*MatchTicket, now time.Time) int {
+	oldest := group[0].EnqueuedAt
+	for _, ticket := range group[1:] {
+		if ticket.EnqueuedAt.Before(oldest) {
+			oldest = ticket.EnqueuedAt
+		}
+	}
+	return p.MaxSpread + p.SpreadPerSecond*int(now.Sub(oldest).Seconds())
+}
+
+// PartyPolicy 组队匹配， 同一个PartyID的玩家总是被分到同一局
+// 按排队顺序把各个队伍装入房间， 人数超过roomSize的队伍会被拆成单排
+type PartyPolicy struct{}
+
+func (p *PartyPolicy) Name() string {
+	return "party"
+}
+
+func (p *PartyPolicy) Match(queue []*MatchTicket, roomSize int, now time.Time) [][]*MatchTicket {
+	// 按第一个成员的排队顺序整理出各个队伍
+	var units [][]*MatchTicket
+	partyIndex := make(map[int]int)
+	for _, ticket := range queue {
+		if ticket.PartyID == 0 {
+			units = append(units, []*MatchTicket{ticket})
+			continue
+		}
+		if idx, ok := partyIndex[ticket.PartyID]; ok {
+			units[idx] = append(units[idx], ticket)
+			continue
+		}
+		partyIndex[ticket.PartyID] = len(units)
+		units = append(units, []*MatchTicket{ticket})
+	}
+
+	var splitUnits [][]*MatchTicket
+	for _, unit := range units {
+		if len(unit) > roomSize {
+			for _, ticket := range unit {
+				splitUnits = append(splitUnits, []*MatchTicket{ticket})
+			}
+			continue
+		}
+		splitUnits = append(splitUnits, unit)
+	}
+
+	// First-fit 装箱， 只返回刚好装满的组
+	var bins [][]*MatchTicket
+	for _, unit := range splitUnits {
+		placed := false
+		for i := range bins {
+			if len(bins[i])+len(unit) <= roomSize {
+				bins[i] = append(bins[i], unit...)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			bins = append(bins, append([]*MatchTicket{}, unit...))
+		}
+	}
+
+	var groups [][]*MatchTicket
+	for _, bin := range bins {
+		if len(bin) == roomSize {
+			groups = append(groups, bin)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,299 @@
+package backend
+
+import (
+	"gameproject/fb"
+	"gameproject/source/metrics"
+	"gameproject/source/transport"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// 指标名的前缀
+const metricsNamespace = "gameserver_"
+
+// serverMetrics 服务器运行时的计数， 主循环与发送队列写入， 抓取指标时在任意goroutine中读取
+type serverMetrics struct {
+	tickDuration *metrics.Histogram // 一次Tick处理所有房间的耗时， 单位秒
+	tickJitter   *metrics.Histogram // 相邻两次Tick的间隔与配置的间隔之差的绝对值， 单位秒
+	lastTick     time.Time          // 只在主循环中访问
+	tickOverruns atomic.Int64       // 耗时超过配置的间隔、推迟了下一次Tick的次数
+
+	inputsReceived    atomic.Int64 // 收到的玩家输入， 包括被拒绝的输入
+	inputsRelayed     atomic.Int64 // 转发的玩家输入
+	inputsSubstituted atomic.Int64 // 超时未收到、由服务端代替的空输入
+	remainingInputs   atomic.Int64 // world sync时帧号仍大于当前逻辑帧、留到下一次执行的输入
+
+	heartbeatTimeouts sync.Map // 心跳超时断开的连接数， 按所在位置（lobby、room、spectator）区分， 值为*atomic.Int64
+	commandFailures   sync.Map // 回复失败的命令数， 按错误码区分， 值为*atomic.Int64
+
+	received        trafficCounters // 按客户端命令统计收到的消息， 只统计外层通过检查的消息
+	invalidMessages atomic.Int64    // 外层无法解析、断开了连接的消息， 在读取连接的goroutine中写入
+	sent            trafficCounters // 按服务端命令统计实际写出的消息
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		tickDuration: metrics.NewHistogram(0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1),
+		tickJitter:   metrics.NewHistogram(0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25),
+	}
+}
+
+// observeTick 记录一次Tick的耗时与间隔的抖动
+func (m *serverMetrics) observeTick(tickTime time.Time, duration, interval time.Duration) {
+	m.tickDuration.Observe(duration.Seconds())
+	if duration > interval {
+		m.tickOverruns.Add(1)
+	}
+	if !m.lastTick.IsZero() {
+		jitter := tickTime.Sub(m.lastTick) - interval
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		m.tickJitter.Observe(jitter.Seconds())
+	}
+	m.lastTick = tickTime
+}
+
+func (m *serverMetrics) heartbeatTimeout(where string) {
+	increment(&m.heartbeatTimeouts, where)
+}
+
+func (m *serverMetrics) commandFailed(code int64) {
+	increment(&m.commandFailures, strconv.FormatInt(code, 10))
+}
+
+func increment(counters *sync.Map, key string) {
+	counter, _ := counters.LoadOrStore(key, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(1)
+}
+
+func writeCounterMap(w *metrics.Writer, name, help, label string, counters *sync.Map) {
+	values := make(map[string]int64)
+	counters.Range(func(key, value any) bool {
+		values[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	w.Family(name, metrics.Counter, help)
+	for _, key := range sortedKeys(values) {
+		w.Sample(float64(values[key]), metrics.Labels{label: key})
+	}
+}
+
+// sortedKeys 按顺序输出样本， 每次抓取的结果便于比较
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// trafficCounters 按命令统计消息数与字节数
+type trafficCounters struct {
+	mu       sync.Mutex
+	commands map[string]*traffic
+}
+
+type traffic struct {
+	messages int64
+	bytes    int64
+}
+
+func (t *trafficCounters) add(command string, bytes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.commands == nil {
+		t.commands = make(map[string]*traffic)
+	}
+	counter, ok := t.commands[command]
+	if !ok {
+		counter = &traffic{}
+		t.commands[command] = counter
+	}
+	counter.messages++
+	counter.bytes += int64(bytes)
+}
+
+func (t *trafficCounters) write(w *metrics.Writer, direction string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w.Family(metricsNamespace+direction+"_messages_total", metrics.Counter, "Messages "+direction+" by command type.")
+	commands := sortedKeys(t.commands)
+	for _, command := range commands {
+		w.Sample(float64(t.commands[command].messages), metrics.Labels{"command": command})
+	}
+	w.Family(metricsNamespace+direction+"_bytes_total", metrics.Counter, "Message bytes "+direction+" by command type, excluding framing.")
+	for _, command := range commands {
+		w.Sample(float64(t.commands[command].bytes), metrics.Labels{"command": command})
+	}
+}
+
+// recordSent 记录写出的消息， 由发送队列的goroutine调用
+func (m *serverMetrics) recordSent(data []byte) {
+	m.sent.add(fb.GetRootAsS2CCommand(data, 0).Command().String(), len(data))
+}
+
+// loopGauges 只能在主循环中读取的状态， 抓取时通过 inLoop 一次取得
+type loopGauges struct {
+	playersByState map[string]int
+	roomsByState   map[string]int
+	sessions       int
+	rtt            []time.Duration // 服务端测得的各连接的RTT
+	kcpSRTT        []time.Duration // KCP估计的各连接的RTT， 其他传输方式没有
+}
+
+func (s *GameServer) collectLoopGauges() loopGauges {
+	gauges := loopGauges{
+		playersByState: map[string]int{"lobby": 0, "matchmaking": 0, "spectating": 0, "disconnected": 0},
+		roomsByState:   make(map[string]int),
+		sessions:       len(s.sessions),
+	}
+	for state := Room; state <= GameOver; state++ {
+		gauges.playersByState[state.String()] = 0
+		gauges.roomsByState[state.String()] = 0
+	}
+
+	gauges.playersByState["matchmaking"] = len(s.lobby.queue)
+	gauges.playersByState["lobby"] = len(s.lobby.players) - len(s.lobby.queue)
+	for _, room := range s.rooms {
+		gauges.roomsByState[room.gameState.String()]++
+		gauges.playersByState["spectating"] += len(room.spectators)
+		for _, player := range room.players {
+			if player.isOnline() {
+				gauges.playersByState[room.gameState.String()]++
+			} else {
+				gauges.playersByState["disconnected"]++
+			}
+		}
+	}
+	for conn, player := range s.sessions {
+		if player.rtt.samples > 0 {
+			gauges.rtt = append(gauges.rtt, player.rtt.srtt)
+		}
+		if estimator, ok := conn.(transport.RTTEstimator); ok {
+			gauges.kcpSRTT = append(gauges.kcpSRTT, estimator.SmoothedRTT())
+		}
+	}
+	return gauges
+}
+
+// WriteMetrics 写出服务器的所有指标， 可以在任意goroutine中调用
+func (s *GameServer) WriteMetrics(w *metrics.Writer) error {
+	var gauges loopGauges
+	if err := s.inLoop(func() {
+		gauges = s.collectLoopGauges()
+	}); err != nil {
+		return err
+	}
+	m := s.metrics
+
+	w.Gauge(metricsNamespace+"uptime_seconds", "Seconds since the server started.", s.clock.Since(s.startedAt).Seconds())
+	w.Gauge(metricsNamespace+"sessions", "Connected sessions.", float64(gauges.sessions))
+	w.Family(metricsNamespace+"players", metrics.Gauge, "Players by state: lobby, matchmaking, spectating, disconnected or the state of their room.")
+	for _, state := range sortedKeys(gauges.playersByState) {
+		w.Sample(float64(gauges.playersByState[state]), metrics.Labels{"state": state})
+	}
+	w.Family(metricsNamespace+"rooms", metrics.Gauge, "Rooms by game state.")
+	for _, state := range sortedKeys(gauges.roomsByState) {
+		w.Sample(float64(gauges.roomsByState[state]), metrics.Labels{"state": state})
+	}
+
+	w.Histogram(metricsNamespace+"tick_duration_seconds", "Time spent ticking all rooms.", m.tickDuration.Snapshot())
+	w.Histogram(metricsNamespace+"tick_jitter_seconds", "Absolute difference between the actual and the configured tick interval.", m.tickJitter.Snapshot())
+	w.Counter(metricsNamespace+"tick_overruns_total", "Ticks that took longer than the configured tick interval.", float64(m.tickOverruns.Load()))
+
+	w.Counter(metricsNamespace+"inputs_received_total", "Player inputs received, including rejected ones.", float64(m.inputsReceived.Load()))
+	w.Counter(metricsNamespace+"inputs_relayed_total", "Player inputs relayed to rooms, including substituted ones.", float64(m.inputsRelayed.Load()))
+	w.Counter(metricsNamespace+"inputs_substituted_total", "Empty inputs substituted for players whose input was late.", float64(m.inputsSubstituted.Load()))
+	w.Counter(metricsNamespace+"inputs_remaining_total", "Inputs still ahead of the logic frame at a world sync and deferred to the next one.", float64(m.remainingInputs.Load()))
+	writeCounterMap(w, metricsNamespace+"command_failures_total", "Commands answered with a failure, by error code.", "code", &m.commandFailures)
+	writeCounterMap(w, metricsNamespace+"heartbeat_timeouts_total", "Connections closed after missing heartbeats, by where the player was.", "where", &m.heartbeatTimeouts)
+
+	m.received.write(w, "received")
+	w.Counter(metricsNamespace+"invalid_messages_total", "Messages whose command envelope could not be decoded; the connection is closed.", float64(m.invalidMessages.Load()))
+	m.sent.write(w, "sent")
+
+	queue := s.SendQueueStats()
+	w.Gauge(metricsNamespace+"send_queue_messages", "Messages waiting in all send queues.", float64(queue.Queued))
+	w.Gauge(metricsNamespace+"send_queue_max_depth", "Largest depth reached by a single send queue.", float64(queue.MaxDepth))
+	w.Counter(metricsNamespace+"send_queue_dropped_total", "Droppable messages discarded because a send queue was full.", float64(queue.Dropped))
+	w.Counter(metricsNamespace+"send_queue_overflow_disconnects_total", "Connections closed because their send queue was full.", float64(queue.OverflowDisconnects))
+
+	w.Family(metricsNamespace+"command_handled_total", metrics.Counter, "Client commands handled, by command type.")
+	commandStats := s.CommandStats()
+	commands := make([]fb.ClientCommand, 0, len(commandStats))
+	for command := range commandStats {
+		commands = append(commands, command)
+	}
+	slices.Sort(commands)
+	for _, command := range commands {
+		w.Sample(float64(commandStats[command].Count), metrics.Labels{"command": command.String()})
+	}
+	w.Family(metricsNamespace+"command_errors_total", metrics.Counter, "Client commands whose handler returned an error, by command type.")
+	for _, command := range commands {
+		w.Sample(float64(commandStats[command].Errors), metrics.Labels{"command": command.String()})
+	}
+	w.Family(metricsNamespace+"command_duration_seconds_total", metrics.Counter, "Time spent handling client commands, by command type.")
+	for _, command := range commands {
+		w.Sample(commandStats[command].Duration.Seconds(), metrics.Labels{"command": command.String()})
+	}
+
+	writeDurationSummary(w, metricsNamespace+"rtt_seconds", "Smoothed round trip time measured by the server's latency probes.", gauges.rtt)
+	writeDurationSummary(w, metricsNamespace+"kcp_srtt_seconds", "Smoothed round trip time estimated by KCP.", gauges.kcpSRTT)
+	writeKCPStats(w, kcp.DefaultSnmp.Copy())
+	return nil
+}
+
+// writeDurationSummary 写出所有连接的平均值与最大值， 不按连接输出以控制指标的数量
+func writeDurationSummary(w *metrics.Writer, name, help string, durations []time.Duration) {
+	var sum, max time.Duration
+	for _, d := range durations {
+		sum += d
+		if d > max {
+			max = d
+		}
+	}
+	average := time.Duration(0)
+	if len(durations) > 0 {
+		average = sum / time.Duration(len(durations))
+	}
+	w.Family(name, metrics.Gauge, help+" Average and maximum over connections.")
+	w.Sample(average.Seconds(), metrics.Labels{"stat": "avg"})
+	w.Sample(max.Seconds(), metrics.Labels{"stat": "max"})
+}
+
+// writeKCPStats 写出kcp-go的全局SNMP统计， 包括本进程所有的KCP连接
+func writeKCPStats(w *metrics.Writer, snmp *kcp.Snmp) {
+	counters := []struct {
+		name  string
+		help  string
+		value uint64
+	}{
+		{"bytes_sent_total", "Bytes sent from the upper level.", snmp.BytesSent},
+		{"bytes_received_total", "Bytes received by the upper level.", snmp.BytesReceived},
+		{"passive_opens_total", "Accepted KCP sessions.", snmp.PassiveOpens},
+		{"in_packets_total", "UDP packets received.", snmp.InPkts},
+		{"out_packets_total", "UDP packets sent.", snmp.OutPkts},
+		{"in_segments_total", "KCP segments received.", snmp.InSegs},
+		{"out_segments_total", "KCP segments sent.", snmp.OutSegs},
+		{"in_udp_bytes_total", "UDP bytes received.", snmp.InBytes},
+		{"out_udp_bytes_total", "UDP bytes sent.", snmp.OutBytes},
+		{"retransmitted_segments_total", "Segments retransmitted after a timeout.", snmp.RetransSegs},
+		{"fast_retransmitted_segments_total", "Segments fast retransmitted.", snmp.FastRetransSegs},
+		{"early_retransmitted_segments_total", "Segments early retransmitted.", snmp.EarlyRetransSegs},
+		{"lost_segments_total", "Segments inferred as lost.", snmp.LostSegs},
+		{"repeated_segments_total", "Duplicate segments received.", snmp.RepeatSegs},
+		{"in_errors_total", "UDP read errors.", snmp.InErrs},
+		{"input_errors_total", "Packets rejected by KCP input.", snmp.KCPInErrors},
+	}
+	for _, counter := range counters {
+		w.Counter(metricsNamespace+"kcp_"+counter.name, counter.help, float64(counter.value))
+	}
+	w.Gauge(metricsNamespace+"kcp_established_sessions", "Currently established KCP sessions.", float64(snmp.CurrEstab))
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"fmt"
+	"gameproject/source/logging"
+	"gameproject/source/replay"
+	"log/slog"
+	"path/filepath"
+	"time"
+)
+
+// roomRecorder 将房间广播的消息写入录像文件， 为nil时不录制
+type roomRecorder struct {
+	recorder *replay.Recorder
+	path     string
+	failed   bool
+}
+
+func newRoomRecorder(dir string, roomID int) (*roomRecorder, error) {
+	path := filepath.Join(dir, fmt.Sprintf("room%d_%s.gsrp", roomID, time.Now().Format("20060102_150405")))
+	recorder, err := replay.CreateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &roomRecorder{
+		recorder: recorder,
+		path:     path,
+	}, nil
+}
+
+func (r *roomRecorder) recordMessage(data []byte) {
+	if r == nil {
+		return
+	}
+	r.check(r.recorder.RecordMessage(data))
+}
+
+func (r *roomRecorder) recordChecksum(logicFrame int, hash uint64) {
+	if r == nil {
+		return
+	}
+	r.check(r.recorder.RecordChecksum(logicFrame, hash))
+}
+
+// check 写入失败时只打印一次日志， 录像失败不影响游戏
+func (r *roomRecorder) check(err error) {
+	if err != nil && !r.failed {
+		r.failed = true
+		slog.Error("Failed to write replay", "path", r.path, logging.Err(err))
+	}
+}
+
+func (r *roomRecorder) close() {
+	if r == nil {
+		return
+	}
+	if err := r.recorder.Close(); err != nil {
+		slog.Error("Failed to close replay", "path", r.path, logging.Err(err))
+		return
+	}
+	slog.Info("Replay saved", "path", r.path)
+}
@@ -0,0 +1,414 @@
+package backend
+
+import (
+	"fmt"
+	"gameproject/source/gametypes"
+	"gameproject/source/logging"
+	"gameproject/source/simulation"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// GameState 的取值与 gametypes.RoomState* 一致
+type GameState int
+
+const (
+	Room GameState = iota
+	WaitPlayersReady
+	GameCountDown // 游戏开始前倒计时阶段
+	Game
+	GameOver
+)
+
+func (s GameState) String() string {
+	return [...]string{"Room", "WaitPlayersReady", "GameCountDown", "Game", "GameOver"}[s]
+}
+
+// GameRoom 一局比赛， 拥有独立的玩家集合与状态机， 由服务器主循环驱动Tick
+type GameRoom struct {
+	id     int
+	server *GameServer
+	config *ServerConfig
+	logger *slog.Logger // 带有room_id字段
+
+	players    map[int]*Player
+	maxPlayers int
+
+	gameState     GameState
+	appointedTime int64
+	forceStart    bool // 管理接口要求不等待满员与加载完成， 立即开始游戏
+	paused        bool // 管理接口暂停了游戏， 逻辑帧不再推进
+
+	gameMap      *gametypes.GameMap
+	world        *simulation.World // 权威的世界状态， 进入游戏时创建
+	desync       *desyncDetector
+	recorder     *roomRecorder // 进入游戏时开始录像
+	frameCounter int
+	logicFrame   int
+	inputQueue   []gametypes.PlayerInput
+
+	// 最近一次world sync时的快照与之后转发的输入， 用于断线重连后追帧
+	snapshotFrame   int
+	snapshotPlayers []gametypes.SerializePlayer
+	snapshotPending []gametypes.PlayerInput
+	inputLog        []gametypes.PlayerInput
+
+	// 观战者不占用玩家位置， 延迟 SpectatorDelay 收到房间的广播
+	spectators  map[int]*Player
+	feed        []feedMessage
+	feedCatchUp *gametypes.CatchUp // 执行完已从 feed 中移除的消息之后的快照， 没有移除过消息时为nil
+}
+
+func newGameRoom(id, maxPlayers int, server *GameServer) *GameRoom {
+	return &GameRoom{
+		id:         id,
+		server:     server,
+		config:     server.config,
+		logger:     slog.Default().With(logging.KeyRoomID, id),
+		players:    make(map[int]*Player),
+		maxPlayers: maxPlayers,
+		spectators: make(map[int]*Player),
+		gameState:  Room,
+		gameMap:    gametypes.NewGameMap(10, 10),
+		desync:     newDesyncDetector(),
+	}
+}
+
+// isJoinable 房间是否还能接收新玩家， 只有在Room状态且未满员时可以加入
+func (r *GameRoom) isJoinable() bool {
+	return r.gameState == Room && len(r.players) < r.maxPlayers
+}
+
+func (r *GameRoom) addPlayer(player *Player) {
+	player.room = r
+	player.inputDelay = 0
+	player.advertisedInputLead = -1
+	r.players[player.id] = player
+	r.logger.Info("Player joined room", logging.KeyPlayerID, player.id, "players", len(r.players), "max_players", r.maxPlayers)
+}
+
+func (r *GameRoom) removePlayer(player *Player) {
+	player.room = nil
+	delete(r.players, player.id)
+	r.logger.Info("Player left room", logging.KeyPlayerID, player.id, "players", len(r.players), "max_players", r.maxPlayers)
+}
+
+// checkHeartbeats 关闭超时玩家的连接， 由玩家的goroutine处理断线
+// 断线后超过重连时限仍未重连的玩家移出房间
+func (r *GameRoom) checkHeartbeats() {
+	now := r.server.clock.Now()
+	for id, player := range r.players {
+		if !player.isOnline() {
+			if now.Sub(player.disconnectedAt) > r.config.ReconnectTimeout {
+				r.logger.Warn("Player reconnect timeout", logging.KeyPlayerID, id)
+				r.removePlayer(player)
+			}
+			continue
+		}
+		if now.Sub(player.lastActive) > 2*r.config.HeartbeatInterval {
+			r.logger.Warn("Player heartbeat timeout", logging.KeyPlayerID, id)
+			r.server.metrics.heartbeatTimeout("room")
+			player.conn.Close()
+		}
+	}
+	r.checkSpectatorHeartbeats()
+}
+
+func (r *GameRoom) tick(tickTime time.Time) {
+	// 打印tickTime time.Time, 通道中拿取的时间跟timeNow可能存在1s的误差
+	// r.logger.Debug("Tick", "tick_time", tickTime.UnixMilli(), "now", time.Now().UnixMilli())
+
+	// 所有玩家都离开了， 结束该房间
+	if len(r.players) == 0 {
+		r.gameState = GameOver
+	}
+
+	switch r.gameState {
+	case Room:
+		// 如果房间人数满了，则开始游戏
+		if len(r.players) == r.maxPlayers || r.forceStart {
+			// 检查是否全部完成了校时
+			allSynced := true
+			for _, player := range r.players {
+				if player.timeSyncedTimes < r.config.TimeSyncTimes {
+					allSynced = false
+				}
+			}
+			if allSynced {
+				// 给每个玩家随机一个不重复的出生位置
+				r.assignPlayerPositions()
+				r.world = simulation.NewWorld(r.gameMap)
+				for _, player := range r.players {
+					r.world.AddPlayer(player.id, player.position)
+				}
+				r.takeSnapshot()
+				r.startRecording()
+				sendStartEnterGame(r)
+				r.gameState = WaitPlayersReady
+			}
+		}
+	case WaitPlayersReady:
+		// 检查所有玩家是否准备好
+		allReady := true
+		for _, player := range r.players {
+			if !player.isReady {
+				allReady = false
+				break
+			}
+		}
+		if allReady || r.forceStart {
+			// 计算约定的游戏开始时间（当前时间 + 延迟时间）
+			r.appointedTime = r.server.clock.Now().Add(r.config.AppointedServerTimeDelay).UnixMilli()
+			sendStartGame(r)
+			r.gameState = GameCountDown
+		}
+	case GameCountDown:
+		// 检查是否到达约定的游戏开始时间
+		if tickTime.UnixMilli() >= r.appointedTime {
+			r.logger.Info("Game started", "tick_time", tickTime.UnixMilli(), "appointed_time", r.appointedTime)
+			r.gameState = Game
+			r.frameCounter = 0
+			r.logicFrame = 0
+			for _, player := range r.players {
+				player.nextInputWindow = 1
+				player.lastInputFrame = -1
+			}
+		}
+	case Game:
+		if r.paused {
+			return
+		}
+		// 游戏逻辑， 服务端目前只做指令转发
+		r.frameCounter++
+		logicFrameUpdated := false
+		r.logicFrame++
+
+		// 超时未发送输入的玩家由服务端代替发送空输入， 保证游戏继续推进
+		r.substituteMissingInputs()
+
+		// 目前配置下，相当于每0.5秒进行一次world sync
+		if r.frameCounter == r.config.TickRate/2 {
+			r.frameCounter = 0
+			logicFrameUpdated = true
+		}
+
+		// 每次world sync时执行一次世界逻辑， 与客户端收到world sync时执行的输入完全相同
+		if logicFrameUpdated {
+			// 筛选当前需要执行的命令， 按转发顺序执行， 与客户端的执行结果保持一致
+			validInputs, remainingInputs := simulation.SplitDueInputs(r.inputQueue, r.logicFrame)
+
+			r.inputQueue = remainingInputs
+			if len(remainingInputs) != 0 {
+				r.server.metrics.remainingInputs.Add(int64(len(remainingInputs)))
+				// 打印剩余输入
+				r.logger.Warn("异常剩余玩家输入", logging.KeyLogicFrame, r.logicFrame, "remaining_inputs", remainingInputs)
+			}
+
+			// 服务端更新玩家位置
+			for _, err := range r.world.Step(r.logicFrame, validInputs) {
+				r.logger.Warn("Skipped command", logging.KeyLogicFrame, r.logicFrame, logging.Err(err))
+			}
+			for _, player := range r.players {
+				if state, ok := r.world.Player(player.id); ok {
+					player.position = state.Position
+				}
+			}
+
+			// 记录权威状态的哈希， 用于与客户端上报的哈希比较
+			r.desync.recordServerState(r.world)
+			r.recorder.recordChecksum(r.logicFrame, r.world.Checksum())
+			r.takeSnapshot()
+			sendWorldSync(r)
+		}
+
+	default:
+		return
+	}
+}
+
+// startRecording 开始录制本局的录像， 失败时不录制
+func (r *GameRoom) startRecording() {
+	if r.config.ReplayDir == "" {
+		return
+	}
+	recorder, err := newRoomRecorder(r.config.ReplayDir, r.id)
+	if err != nil {
+		r.logger.Error("Failed to create replay", logging.Err(err))
+		return
+	}
+	r.recorder = recorder
+}
+
+// inputWindowFrames 每个输入窗口的逻辑帧数， 客户端每个窗口发送一次输入
+func (r *GameRoom) inputWindowFrames() int {
+	frames := int(r.config.SendInputInterval * float32(r.config.TickRate))
+	if frames < 1 {
+		frames = 1
+	}
+	return frames
+}
+
+// verifyInput 校验输入是否属于该玩家、帧号是否在允许的范围内以及是否重复， 失败时返回错误码
+func (r *GameRoom) verifyInput(player *Player, input gametypes.PlayerInput) (int64, error) {
+	if input.ID != player.id {
+		return gametypes.ErrCodePlayerMismatch, fmt.Errorf("input player id %d does not match player %d", input.ID, player.id)
+	}
+
+	minFrame := r.logicFrame - r.config.MaxInputFrameLag
+	maxFrame := r.logicFrame + r.config.MaxInputFrameLead
+	if input.LogicFrame < minFrame || input.LogicFrame > maxFrame {
+		return gametypes.ErrCodeFrameOutOfRange, fmt.Errorf("input frame %d out of range [%d, %d]", input.LogicFrame, minFrame, maxFrame)
+	}
+
+	if input.LogicFrame <= player.lastInputFrame {
+		return gametypes.ErrCodeDuplicateInput, fmt.Errorf("input frame %d not after last input frame %d", input.LogicFrame, player.lastInputFrame)
+	}
+	return gametypes.ErrCodeNone, nil
+}
+
+// markInputReceived 记录玩家已经发送了当前窗口的输入
+// 提前半个窗口以内到达的输入也计入该窗口， 更早的输入只转发不计数
+func (r *GameRoom) markInputReceived(player *Player) {
+	windowFrames := r.inputWindowFrames()
+	if r.logicFrame >= player.nextInputWindow*windowFrames-windowFrames/2 {
+		player.nextInputWindow++
+	}
+}
+
+// substituteMissingInputs 窗口开始后超过 MissingInputTimeoutTicks 仍未收到输入的玩家视为本窗口无输入
+// 服务端生成空输入并广播， 客户端据此得知自己的输入已超时
+func (r *GameRoom) substituteMissingInputs() {
+	windowFrames := r.inputWindowFrames()
+	for _, player := range r.players {
+		// 输入提前量不足以抵消的延迟由服务端多等待
+		deadline := player.nextInputWindow*windowFrames + r.config.MissingInputTimeoutTicks + player.inputDelay - r.inputLead(player)
+		if r.logicFrame < deadline {
+			continue
+		}
+
+		input := gametypes.PlayerInput{
+			ID:          player.id,
+			LogicFrame:  r.logicFrame,
+			Substituted: true,
+		}
+		r.logger.Warn("Missed input window, substituted empty input",
+			logging.KeyLogicFrame, r.logicFrame,
+			logging.KeyPlayerID, player.id,
+			"input_window", player.nextInputWindow)
+		player.nextInputWindow++
+		// 代替之后到达的本窗口的输入已经过期， 按重复输入拒绝
+		player.lastInputFrame = max(player.lastInputFrame, input.LogicFrame)
+		r.server.metrics.inputsSubstituted.Add(1)
+		r.queueInput(input)
+		sendPlayerInput(r, &input)
+	}
+}
+
+// validateInput 在权威状态上依次执行尚未执行的输入之后校验命令，
+// 同一次world sync之前的多个输入合起来也不能越过单个输入的限制
+func (r *GameRoom) validateInput(input gametypes.PlayerInput) error {
+	if len(r.inputQueue) == 0 {
+		return r.world.ValidateInput(input)
+	}
+	predicted := r.world.Clone()
+	predicted.Step(r.world.LogicFrame(), r.inputQueue)
+	return predicted.ValidateInput(input)
+}
+
+// queueInput 缓存通过校验的玩家输入， 在下一次world sync时执行
+func (r *GameRoom) queueInput(input gametypes.PlayerInput) {
+	r.server.metrics.inputsRelayed.Add(1)
+	r.inputQueue = append(r.inputQueue, input)
+	r.inputLog = append(r.inputLog, input)
+}
+
+// takeSnapshot 记录当前的世界状态与尚未执行的输入， 并清空输入日志
+func (r *GameRoom) takeSnapshot() {
+	r.snapshotFrame = r.world.LogicFrame()
+	r.snapshotPlayers = nil
+	for _, state := range r.world.Players() {
+		r.snapshotPlayers = append(r.snapshotPlayers, gametypes.SerializePlayer{
+			ID:       state.ID,
+			Position: state.Position,
+		})
+	}
+	r.snapshotPending = append([]gametypes.PlayerInput(nil), r.inputQueue...)
+	r.inputLog = nil
+}
+
+// catchUp 重连的玩家从最近的快照开始， 依次执行快照时未执行的输入与之后转发的输入即可追上当前的逻辑帧
+func (r *GameRoom) catchUp() gametypes.CatchUp {
+	return gametypes.CatchUp{
+		GameState:           int(r.gameState),
+		LogicFrame:          r.logicFrame,
+		SnapshotFrame:       r.snapshotFrame,
+		Players:             append([]gametypes.SerializePlayer(nil), r.snapshotPlayers...),
+		PendingInputs:       append([]gametypes.PlayerInput(nil), r.snapshotPending...),
+		InputLog:            append([]gametypes.PlayerInput(nil), r.inputLog...),
+		AppointedServerTime: r.appointedTime,
+	}
+}
+
+// reportStateHash 记录玩家上报的状态哈希， 首次发现不同步时通知服务器
+func (r *GameRoom) reportStateHash(player *Player, logicFrame int, hash uint64) {
+	event := r.desync.reportPlayerHash(r.id, player.id, logicFrame, hash)
+	if event == nil {
+		return
+	}
+
+	r.logger.Error("Desync detected",
+		logging.KeyLogicFrame, event.LogicFrame,
+		"server_hash", fmt.Sprintf("%x", event.ServerHash),
+		"player_hashes", event.PlayerHashes,
+		"state_dump", event.StateDump)
+	if r.server.onDesync != nil {
+		r.server.onDesync(*event)
+	}
+}
+
+func (r *GameRoom) assignPlayerPositions() {
+	positions := make(map[int]*gametypes.Vector2Int)
+	availablePositions := make([]gametypes.Vector2Int, 0)
+
+	// Calculate center offsets
+	centerX := r.gameMap.MapData.Width / 2
+	centerY := r.gameMap.MapData.Height / 2
+
+	// Create list of all possible positions
+	// Excluding extreme edges for better gameplay
+	for x := 1; x < r.gameMap.MapData.Width-1; x++ {
+		for y := 1; y < r.gameMap.MapData.Height-1; y++ {
+			// Convert to centered coordinate system where (0,0) is the center
+			centeredX := x - centerX
+			centeredY := y - centerY
+			availablePositions = append(availablePositions, gametypes.Vector2Int{X: centeredX, Y: centeredY})
+		}
+	}
+
+	// Randomly assign positions to players
+	for playerID := range r.players {
+		if len(availablePositions) == 0 {
+			r.logger.Warn("No more positions available", logging.KeyPlayerID, playerID)
+			continue
+		}
+
+		// Pick random position from available positions
+		idx := rand.IntN(len(availablePositions))
+		pos := availablePositions[idx]
+
+		// Remove used position by swapping with last element and shrinking slice
+		availablePositions[idx] = availablePositions[len(availablePositions)-1]
+		availablePositions = availablePositions[:len(availablePositions)-1]
+
+		positions[playerID] = &gametypes.Vector2Int{X: pos.X, Y: pos.Y}
+		r.logger.Debug("Assigned position", logging.KeyPlayerID, playerID, "x", pos.X, "y", pos.Y)
+	}
+
+	// 更新玩家位置
+	for playerID, position := range positions {
+		if player, ok := r.players[playerID]; ok {
+			player.position = *position
+		}
+	}
+}
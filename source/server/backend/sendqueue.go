@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"errors"
+	"gameproject/source/framing"
+	"gameproject/source/logging"
+	"gameproject/source/transport"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// 发送队列满时的处理策略
+const (
+	SendQueueDropOldest = "drop_oldest" // 丢弃最早的可丢弃消息， 没有可丢弃的消息时断开连接
+	SendQueueDisconnect = "disconnect"  // 直接断开连接， 玩家可以重连后追帧
+)
+
+var (
+	errSendQueueClosed = errors.New("send queue closed")
+	errSendQueueFull   = errors.New("send queue full")
+)
+
+// SendQueueStats 所有连接发送队列的统计， 可以在任意goroutine中读取
+type SendQueueStats struct {
+	Queued              int64 `json:"queued"`               // 当前所有队列中等待发送的消息数
+	MaxDepth            int64 `json:"max_depth"`            // 单个队列出现过的最大长度
+	Dropped             int64 `json:"dropped"`              // 因队列已满被丢弃的消息数
+	OverflowDisconnects int64 `json:"overflow_disconnects"` // 因队列已满被断开的连接数
+}
+
+type sendQueueCounters struct {
+	queued              atomic.Int64
+	maxDepth            atomic.Int64
+	dropped             atomic.Int64
+	overflowDisconnects atomic.Int64
+}
+
+func (c *sendQueueCounters) snapshot() SendQueueStats {
+	return SendQueueStats{
+		Queued:              c.queued.Load(),
+		MaxDepth:            c.maxDepth.Load(),
+		Dropped:             c.dropped.Load(),
+		OverflowDisconnects: c.overflowDisconnects.Load(),
+	}
+}
+
+func (c *sendQueueCounters) observeDepth(depth int64) {
+	for {
+		max := c.maxDepth.Load()
+		if depth <= max || c.maxDepth.CompareAndSwap(max, depth) {
+			return
+		}
+	}
+}
+
+type outboundMessage struct {
+	data      []byte
+	droppable bool // 丢失后不影响帧同步的消息， 例如Pong、房间列表
+}
+
+// sendQueue 一个连接的有界发送队列， 由独立的goroutine写入连接
+// 主循环入队时不会被慢速或卡住的客户端阻塞
+type sendQueue struct {
+	conn     transport.Conn
+	capacity int
+	policy   string
+	counters *sendQueueCounters
+	onSent   func(data []byte) // 消息写出后调用， 用于统计流量， 可以为nil
+
+	mu       sync.Mutex
+	messages []outboundMessage
+	closed   bool
+	ready    chan struct{} // 有新消息或队列关闭时通知写入的goroutine
+}
+
+func newSendQueue(conn transport.Conn, capacity int, policy string, counters *sendQueueCounters, onSent func(data []byte)) *sendQueue {
+	return &sendQueue{
+		conn:     conn,
+		capacity: capacity,
+		policy:   policy,
+		counters: counters,
+		onSent:   onSent,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// push 消息入队， 队列已满时按策略丢弃消息或断开连接
+func (q *sendQueue) push(data []byte, droppable bool) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return errSendQueueClosed
+	}
+
+	if len(q.messages) >= q.capacity {
+		dropOldest := q.policy == SendQueueDropOldest
+		switch {
+		case dropOldest && q.dropOldestLocked():
+		case dropOldest && droppable:
+			// 队列中没有可丢弃的消息， 丢弃新消息
+			q.counters.dropped.Add(1)
+			q.mu.Unlock()
+			return nil
+		default:
+			q.mu.Unlock()
+			slog.Warn("Send queue is full, disconnecting", logging.KeyAddr, q.conn.RemoteAddr().String(), "capacity", q.capacity)
+			q.counters.overflowDisconnects.Add(1)
+			q.close()
+			q.conn.Close()
+			return errSendQueueFull
+		}
+	}
+
+	q.messages = append(q.messages, outboundMessage{data: data, droppable: droppable})
+	q.counters.queued.Add(1)
+	q.counters.observeDepth(int64(len(q.messages)))
+	q.mu.Unlock()
+
+	q.notify()
+	return nil
+}
+
+// len 返回队列中等待发送的消息数
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+// dropOldestLocked 丢弃最早的可丢弃消息， 没有可丢弃的消息时返回false
+func (q *sendQueue) dropOldestLocked() bool {
+	for i, message := range q.messages {
+		if message.droppable {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			q.counters.queued.Add(-1)
+			q.counters.dropped.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *sendQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close 关闭队列， 尚未发送的消息被丢弃， 写入的goroutine随之退出
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		q.counters.queued.Add(-int64(len(q.messages)))
+		q.messages = nil
+	}
+	q.mu.Unlock()
+	q.notify()
+}
+
+// run 按顺序写出队列中的消息， 写入失败时关闭连接， 由读取的goroutine上报断线
+func (q *sendQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.messages) == 0 && !q.closed {
+			q.mu.Unlock()
+			<-q.ready
+			q.mu.Lock()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		message := q.messages[0]
+		q.messages[0] = outboundMessage{}
+		q.messages = q.messages[1:]
+		q.counters.queued.Add(-1)
+		q.mu.Unlock()
+
+		if err := framing.WriteFrame(q.conn, message.data); err != nil {
+			slog.Warn("Failed to write to connection", logging.KeyAddr, q.conn.RemoteAddr().String(), logging.Err(err))
+			q.close()
+			q.conn.Close()
+			return
+		}
+		if q.onSent != nil {
+			q.onSent(message.data)
+		}
+	}
+}
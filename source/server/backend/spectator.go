@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"gameproject/source/gametypes"
+	"gameproject/source/logging"
+	"slices"
+	"time"
+)
+
+// 每个Tick最多向一个观战者补发的消息数， 中途加入的观战者分批追上， 避免发送队列溢出
+const spectatorBatchSize = 64
+
+// feedMessage 房间广播的一条消息， 观战者在 at + SpectatorDelay 之后收到
+type feedMessage struct {
+	at      time.Time
+	data    []byte
+	catchUp *gametypes.CatchUp // world sync 之后的快照， 只有world sync的消息有
+}
+
+// publish 记录房间广播的消息： 写入录像， 并放入观战的延迟队列
+// 观战者收到的消息与录像相同（进入游戏、玩家输入、world sync）， 可以用同样的方式重建世界
+func (r *GameRoom) publish(data []byte) {
+	r.recorder.recordMessage(data)
+	if r.config.MaxSpectators > 0 {
+		r.feed = append(r.feed, feedMessage{at: r.server.clock.Now(), data: data})
+	}
+}
+
+// publishWorldSync 与 publish 相同， 同时记录本次world sync之后的快照， 移除之前的消息后观战者从快照开始
+func (r *GameRoom) publishWorldSync(data []byte) {
+	r.recorder.recordMessage(data)
+	if r.config.MaxSpectators > 0 {
+		catchUp := r.catchUp()
+		r.feed = append(r.feed, feedMessage{at: r.server.clock.Now(), data: data, catchUp: &catchUp})
+	}
+}
+
+func (r *GameRoom) addSpectator(player *Player) {
+	player.spectating = r
+	player.feedCursor = 0
+	r.spectators[player.id] = player
+	r.logger.Info("Player is spectating", logging.KeyPlayerID, player.id, "spectators", len(r.spectators))
+}
+
+func (r *GameRoom) removeSpectator(player *Player) {
+	player.spectating = nil
+	delete(r.spectators, player.id)
+	r.logger.Info("Player stopped spectating", logging.KeyPlayerID, player.id, "spectators", len(r.spectators))
+}
+
+// deliverToSpectators 把已经超过观战延迟的消息发给观战者
+// 中途加入的观战者从快照之后的第一条消息开始接收， 与重连追帧一样追上当前的进度
+func (r *GameRoom) deliverToSpectators(now time.Time) {
+	deadline := now.Add(-r.config.SpectatorDelay)
+	for _, spectator := range r.spectators {
+		for sent := 0; sent < spectatorBatchSize && spectator.feedCursor < len(r.feed); sent++ {
+			message := r.feed[spectator.feedCursor]
+			if message.at.After(deadline) {
+				break
+			}
+			if err := spectator.send(message.data); err != nil {
+				r.logger.Warn("Failed to send spectator feed", logging.KeyPlayerID, spectator.id, logging.Err(err))
+				break
+			}
+			spectator.feedCursor++
+		}
+	}
+	r.trimFeed(deadline)
+}
+
+// trimFeed 移除早于 deadline 且所有观战者都已收到的消息， 只在world sync处截断， 保留最后移除的world sync之后的快照
+func (r *GameRoom) trimFeed(deadline time.Time) {
+	limit := len(r.feed)
+	for _, spectator := range r.spectators {
+		limit = min(limit, spectator.feedCursor)
+	}
+	remove := 0
+	for i := 0; i < limit && !r.feed[i].at.After(deadline); i++ {
+		if r.feed[i].catchUp != nil {
+			remove = i + 1
+		}
+	}
+	if remove == 0 {
+		return
+	}
+
+	r.feedCatchUp = r.feed[remove-1].catchUp
+	r.feed = slices.Delete(r.feed, 0, remove)
+	for _, spectator := range r.spectators {
+		spectator.feedCursor -= remove
+	}
+}
+
+// flushSpectators 比赛结束后不再需要延迟， 发送剩余的所有消息
+func (r *GameRoom) flushSpectators() {
+	for _, spectator := range r.spectators {
+		for ; spectator.feedCursor < len(r.feed); spectator.feedCursor++ {
+			if err := spectator.send(r.feed[spectator.feedCursor].data); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// checkSpectatorHeartbeats 关闭超时观战者的连接， 观战者断线后直接移除
+func (r *GameRoom) checkSpectatorHeartbeats() {
+	now := r.server.clock.Now()
+	for id, spectator := range r.spectators {
+		if now.Sub(spectator.lastActive) > 2*r.config.HeartbeatInterval {
+			r.logger.Warn("Spectator heartbeat timeout", logging.KeyPlayerID, id)
+			r.server.metrics.heartbeatTimeout("spectator")
+			spectator.conn.Close()
+		}
+	}
+}
+
+// spectate 大厅中的玩家观战指定的房间， roomID 为0时选择ID最小的房间
+func (s *GameServer) spectate(player *Player, roomID int) int64 {
+	room, ok := s.rooms[roomID]
+	if roomID == 0 {
+		room, ok = nil, false
+		for _, candidate := range s.rooms {
+			if candidate.gameState != GameOver && (room == nil || candidate.id < room.id) {
+				room, ok = candidate, true
+			}
+		}
+	}
+	if !ok || room.gameState == GameOver {
+		return gametypes.ErrCodeRoomNotFound
+	}
+	if len(room.spectators) >= s.config.MaxSpectators {
+		return gametypes.ErrCodeSpectatorsFull
+	}
+
+	s.lobby.removePlayer(player)
+	room.addSpectator(player)
+	sendSpectate(player, room)
+	// 早期的消息已经移除， 从快照开始追上观战的进度
+	if room.feedCatchUp != nil {
+		sendCatchUp(player, room, *room.feedCatchUp)
+	}
+	return gametypes.ErrCodeNone
+}
+
+// stopSpectating 观战者回到大厅
+func (s *GameServer) stopSpectating(player *Player) {
+	player.spectating.removeSpectator(player)
+	s.lobby.addPlayer(player)
+	sendLeaveRoom(player)
+}
@@ -2,21 +2,35 @@ package gui
 
 import (
 	"fmt"
+	"gameproject/source/logging"
 	"image/color"
-	"log"
-	"strings"
+	"log/slog"
+	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
+// 日志面板保留的日志条数与刷新间隔， 超出的旧日志被丢弃， 长时间运行也不会变慢
+const (
+	logCapacity        = 2000
+	logRefreshInterval = 200 * time.Millisecond
+)
+
+// logBuffer 接收所有级别的日志， 在 CreateWindow 之前创建， 启动时的日志也能显示
+var logBuffer = logging.NewBuffer(logCapacity)
+
 var (
 	mainWindow       fyne.Window
-	logEntry         *widget.Entry
+	logList          *widget.List
+	logLevel         slog.LevelVar // 日志面板显示的最低级别， 零值为info
+	logEntriesMu     sync.Mutex
+	logEntries       []logging.Entry // 当前显示的日志， 只在刷新时替换
 	startButton      *widget.Button
 	stopButton       *widget.Button
 	OnConfigure      func(port, tickRate, maxPlayers, heartbeat, timeSyncTimes, appointedServerTimeDelay, sendInputInterval, executionDuration string) error
@@ -39,27 +53,77 @@ type ServerConfig struct {
 	ExecutionDuration        string
 }
 
-// 创建可滚动到底部的多行文本框
-func newScrollableLabel(text string) *widget.Entry {
-	entry := widget.NewMultiLineEntry()
-	entry.SetText(text)
-	entry.Disable()
-	entry.TextStyle = fyne.TextStyle{}
-	entry.Wrapping = fyne.TextWrapWord
-
-	// 使用 OnChanged 回调确保文本更新时滚动到底部
-	entry.OnChanged = func(string) {
-		// 使用 goroutine 确保在渲染完成后滚动
-		go func() {
-			time.Sleep(100 * time.Millisecond)
-			entry.CursorRow = len(strings.Split(entry.Text, "\n")) - 1
-			entry.Refresh()
-		}()
+// LogHandler 返回日志面板的 slog.Handler， 由 logging.Setup 加入默认日志
+func LogHandler() slog.Handler {
+	return logBuffer.Handler()
+}
+
+// newLogList 创建日志列表， 只渲染可见的行
+func newLogList() *widget.List {
+	return widget.NewList(
+		func() int {
+			logEntriesMu.Lock()
+			defer logEntriesMu.Unlock()
+			return len(logEntries)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.ListItemID, object fyne.CanvasObject) {
+			logEntriesMu.Lock()
+			var text string
+			if id < len(logEntries) {
+				text = logEntries[id].String()
+			}
+			logEntriesMu.Unlock()
+			object.(*widget.Label).SetText(text)
+		},
+	)
+}
+
+// newLogLevelSelect 选择日志面板显示的最低级别
+func newLogLevelSelect() *widget.Select {
+	levels := []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+	options := make([]string, len(levels))
+	for i, level := range levels {
+		options[i] = level.String()
 	}
+	selectLevel := widget.NewSelect(options, func(selected string) {
+		for _, level := range levels {
+			if level.String() == selected {
+				logLevel.Set(level)
+			}
+		}
+		refreshLogs()
+	})
+	selectLevel.SetSelected(logLevel.Level().String())
+	return selectLevel
+}
 
-	// 触发一次 OnChanged 以执行初始滚动
-	entry.OnChanged(entry.Text)
-	return entry
+// refreshLogs 按当前级别重新读取日志并滚动到底部
+func refreshLogs() {
+	if logList == nil {
+		return
+	}
+	entries := logBuffer.Entries(logLevel.Level())
+	logEntriesMu.Lock()
+	logEntries = entries
+	logEntriesMu.Unlock()
+	logList.Refresh()
+	logList.ScrollToBottom()
+}
+
+// watchLogs 定期刷新日志面板， 日志再多每个间隔也只刷新一次
+func watchLogs() {
+	ticker := time.NewTicker(logRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if logBuffer.Dirty() {
+			refreshLogs()
+		}
+	}
 }
 
 // SetServerCallbacks sets the callback functions for server control
@@ -144,14 +208,14 @@ func CreateWindow() {
 		if OnConfigure != nil {
 			err := OnConfigure(portEntry.Text, tickRateEntry.Text, maxPlayersEntry.Text, heartbeatEntry.Text, timeSyncTimesEntry.Text, appointedServerTimeDelayEntry.Text, sendInputIntervalEntry.Text, executionDurationEntry.Text)
 			if err != nil {
-				writeLog("Configuration error: " + err.Error())
+				slog.Error("Configuration error", logging.Err(err))
 				return
 			}
 		}
 
 		if OnStart != nil {
 			if err := OnStart(); err != nil {
-				writeLog("Start error: " + err.Error())
+				slog.Error("Start error", logging.Err(err))
 				return
 			}
 		}
@@ -192,20 +256,22 @@ func CreateWindow() {
 		statsBox2,
 	)
 
-	// Log output
-	logEntry = newScrollableLabel("")
-
-	// Redirect standard logger to our GUI
-	log.SetOutput(&logWriter{})
-
-	logScroll := container.NewScroll(logEntry)
-	logScroll.SetMinSize(fyne.NewSize(0, 300)) // 设置最小高度为200
+	// Log output， 与标准错误使用同一个日志流， 按级别过滤显示
+	logList = newLogList()
+	logHeight := canvas.NewRectangle(color.Transparent)
+	logHeight.SetMinSize(fyne.NewSize(0, 300)) // 列表没有最小高度， 用占位的矩形撑开
+	logPane := container.NewStack(logHeight, logList)
+	logCard := container.NewBorder(
+		container.NewHBox(widget.NewLabel("Level:"), newLogLevelSelect()), nil, nil, nil,
+		logPane,
+	)
+	go watchLogs()
 
 	// Main layout
 	mainContainer := container.NewVBox(
 		widget.NewCard("Server Configuration", "", configBox),
 		widget.NewCard("Controls", "", controlsContainer),
-		widget.NewCard("Server Logs", "", logScroll),
+		widget.NewCard("Server Logs", "", logCard),
 	)
 
 	mainWindow.SetContent(mainContainer)
@@ -220,34 +286,6 @@ func RunWindow() {
 	}
 }
 
-// logWriter implements io.Writer to redirect logs to the GUI
-type logWriter struct{}
-
-func (w *logWriter) Write(bytes []byte) (int, error) {
-	writeLog(string(bytes))
-	return len(bytes), nil
-}
-
-// writeLog adds a new log entry to the log window
-func writeLog(msg string) {
-	if logEntry == nil {
-		return
-	}
-
-	timestamp := time.Now().Format("15:04:05")
-	logMsg := fmt.Sprintf("[%s] %s", timestamp, msg)
-
-	current := logEntry.Text
-	if current != "" {
-		current += "\n"
-	}
-	logEntry.SetText(current + strings.TrimSpace(logMsg))
-
-	// Scroll to bottom
-	logEntry.CursorRow = len(strings.Split(logEntry.Text, "\n")) - 1
-	logEntry.Refresh()
-}
-
 // 自定义主题来覆盖禁用状态的文本颜色
 type customTheme struct {
 	fyne.Theme
@@ -1,12 +1,134 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"gameproject/source/clock"
+	"gameproject/source/logging"
+	"gameproject/source/metrics"
+	"gameproject/source/netsim"
+	"gameproject/source/server/admin"
 	"gameproject/source/server/backend"
 	"gameproject/source/server/gui"
+	"gameproject/source/transport"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	headless := flag.Bool("headless", false, "不启动GUI， 直接使用配置文件与命令行参数运行服务器")
+	configPath := flag.String("config", "", "配置文件路径（.toml / .yaml / .yml / .json）")
+	port := flag.Int("port", 0, "监听端口， 覆盖配置文件")
+	transportName := flag.String("transport", "", "传输方式： kcp、tcp 或 websocket， 覆盖配置文件")
+	tickRate := flag.Int("tick-rate", 0, "每秒逻辑帧数， 覆盖配置文件")
+	maxPlayers := flag.Int("max-players", 0, "每个房间的玩家数， 覆盖配置文件")
+	matchmaking := flag.String("matchmaking", "", "匹配策略， 覆盖配置文件")
+	replayDir := flag.String("replay-dir", "", "录像保存目录， 覆盖配置文件")
+	adminAddr := flag.String("admin", "", "HTTP管理接口的监听地址， 例如 127.0.0.1:8080， 覆盖配置文件")
+	metricsAddr := flag.String("metrics", "", "Prometheus指标的监听地址， 例如 :9100， 覆盖配置文件")
+	logLevel := flag.String("log-level", "", "日志级别： debug、info、warn、error， 覆盖配置文件")
+	logFormat := flag.String("log-format", "", "日志格式： text 或 json， 覆盖配置文件")
+	logFile := flag.String("log-file", "", "同时写入的日志文件， 覆盖配置文件")
+	netsimSpec := flag.String("netsim", "", "对所有连接模拟的网络条件： 内置场景名、场景文件路径或 \"latency=100ms loss=0.01\" 这样的条件")
+	netsimSeed := flag.Uint64("netsim-seed", 0, "网络模拟的随机种子， 0表示随机选择")
+	flag.Parse()
+
+	config := backend.DefaultServerConfig()
+	if *configPath != "" {
+		var err error
+		config, err = backend.LoadServerConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+	}
+
+	// 只覆盖命令行中显式给出的参数
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			config.Port = *port
+		case "transport":
+			config.Transport = *transportName
+		case "tick-rate":
+			config.TickRate = *tickRate
+			// 依赖TickRate的字段按新的TickRate重新计算
+			config.MaxInputFrameLag = 0
+			config.MaxInputFrameLead = 0
+		case "max-players":
+			config.MaxPlayers = *maxPlayers
+		case "matchmaking":
+			config.MatchmakingPolicy = *matchmaking
+		case "replay-dir":
+			config.ReplayDir = *replayDir
+		case "admin":
+			config.AdminAddr = *adminAddr
+		case "metrics":
+			config.MetricsAddr = *metricsAddr
+		case "log-level":
+			config.LogLevel = *logLevel
+		case "log-format":
+			config.LogFormat = *logFormat
+		case "log-file":
+			config.LogFile = *logFile
+		}
+	})
+
 	server := backend.NewGameServer()
+	if err := server.ApplyConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// 无GUI运行时默认输出JSON， 便于日志收集； GUI同时显示同一个日志流
+	logOptions := config.LogOptions()
+	var extraHandlers []slog.Handler
+	if *headless {
+		if logOptions.Format == "" {
+			logOptions.Format = logging.FormatJSON
+		}
+	} else {
+		extraHandlers = append(extraHandlers, gui.LogHandler())
+	}
+	logCloser, err := logging.Setup(logOptions, extraHandlers...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer logCloser.Close()
+
+	// 管理接口先于服务器启动， 服务器启动前的请求返回503
+	if config.AdminAddr != "" {
+		adminServer := admin.NewServer(server, config.AdminAddr, config.AdminToken)
+		if err := adminServer.Start(); err != nil {
+			slog.Error("Failed to start admin API", logging.Err(err))
+			os.Exit(1)
+		}
+		defer adminServer.Stop()
+	}
+	if config.MetricsAddr != "" {
+		metricsServer := metrics.NewServer(config.MetricsAddr, server.WriteMetrics)
+		if err := metricsServer.Start(); err != nil {
+			slog.Error("Failed to start metrics server", logging.Err(err))
+			os.Exit(1)
+		}
+		defer metricsServer.Stop()
+	}
+
+	if *netsimSpec != "" {
+		if err := simulateNetwork(server, config.Transport, *netsimSpec, *netsimSeed); err != nil {
+			slog.Error("Failed to set up network simulation", logging.Err(err))
+			os.Exit(2)
+		}
+	}
+
+	if *headless {
+		runHeadless(server)
+		return
+	}
 
 	// Setup GUI callbacks
 	gui.SetServerCallbacks(
@@ -25,3 +147,35 @@ func main() {
 	gui.CreateWindow()
 	gui.RunWindow() // 使用新的 RunWindow 函数
 }
+
+// simulateNetwork 让服务器的所有连接经过网络模拟， 日志中记录随机种子， 用同样的种子可以复现
+func simulateNetwork(server *backend.GameServer, transportName, spec string, seed uint64) error {
+	inner, err := transport.New(transportName)
+	if err != nil {
+		return err
+	}
+	scenario, err := netsim.Load(spec)
+	if err != nil {
+		return fmt.Errorf("failed to load network scenario: %w", err)
+	}
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	slog.Info("Simulating network conditions", "scenario", scenario.Name(), "seed", seed)
+	server.SetTransport(netsim.Wrap(inner, scenario, clock.Real, seed))
+	return nil
+}
+
+// runHeadless 启动服务器并在收到 SIGINT / SIGTERM 后停止
+func runHeadless(server *backend.GameServer) {
+	if err := server.Start(); err != nil {
+		slog.Error("Failed to start server", logging.Err(err))
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	slog.Info("Received signal", "signal", sig.String())
+	server.Stop()
+}
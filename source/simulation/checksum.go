@@ -0,0 +1,36 @@
+package simulation
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Checksum 计算世界状态的确定性哈希（FNV-1a）， 用于各端之间的不同步检测
+func (w *World) Checksum() uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	write := func(v int) {
+		binary.LittleEndian.PutUint64(buf, uint64(int64(v)))
+		h.Write(buf)
+	}
+
+	write(w.logicFrame)
+	for _, player := range w.Players() {
+		write(player.ID)
+		write(player.Position.X)
+		write(player.Position.Y)
+	}
+	return h.Sum64()
+}
+
+// Dump 输出可读的世界状态， 用于不同步时排查
+func (w *World) Dump() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "logic frame: %d\n", w.logicFrame)
+	for _, player := range w.Players() {
+		fmt.Fprintf(&sb, "player %d: (%d, %d)\n", player.ID, player.Position.X, player.Position.Y)
+	}
+	return sb.String()
+}
@@ -0,0 +1,95 @@
+package simulation
+
+// WorldState 世界状态的副本， 用于保存与回滚， 玩家按ID排序
+type WorldState struct {
+	LogicFrame int
+	Players    []PlayerState
+}
+
+// SaveState 返回当前状态的副本， 之后修改世界不会影响该副本
+func (w *World) SaveState() WorldState {
+	return WorldState{
+		LogicFrame: w.logicFrame,
+		Players:    w.Players(),
+	}
+}
+
+// RestoreState 把世界恢复为保存时的状态， 之后执行相同的输入得到相同的结果
+func (w *World) RestoreState(state WorldState) {
+	w.players = make(map[int]*PlayerState, len(state.Players))
+	for _, player := range state.Players {
+		player := player
+		w.players[player.ID] = &player
+	}
+	w.logicFrame = state.LogicFrame
+}
+
+// Clone 返回使用同一张地图的独立副本
+func (w *World) Clone() *World {
+	clone := NewWorld(w.gameMap)
+	clone.RestoreState(w.SaveState())
+	return clone
+}
+
+// StateBuffer 保存最近若干个逻辑帧的世界状态， 超出容量时覆盖最早的状态
+type StateBuffer struct {
+	states []WorldState
+	next   int
+	count  int
+}
+
+func NewStateBuffer(size int) *StateBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &StateBuffer{states: make([]WorldState, size)}
+}
+
+// Save 保存一个状态， 同一逻辑帧再次保存时覆盖之前的状态
+func (b *StateBuffer) Save(state WorldState) {
+	for i := 0; i < b.count; i++ {
+		index := (b.next - 1 - i + len(b.states)) % len(b.states)
+		if b.states[index].LogicFrame == state.LogicFrame {
+			b.states[index] = state
+			return
+		}
+	}
+	b.states[b.next] = state
+	b.next = (b.next + 1) % len(b.states)
+	if b.count < len(b.states) {
+		b.count++
+	}
+}
+
+// Load 返回指定逻辑帧的状态， 没有保存或已被覆盖时返回false
+func (b *StateBuffer) Load(logicFrame int) (WorldState, bool) {
+	for i := 0; i < b.count; i++ {
+		index := (b.next - 1 - i + len(b.states)) % len(b.states)
+		if b.states[index].LogicFrame == logicFrame {
+			return b.states[index], true
+		}
+	}
+	return WorldState{}, false
+}
+
+// Latest 返回不晚于指定逻辑帧的最近一个状态
+func (b *StateBuffer) Latest(logicFrame int) (WorldState, bool) {
+	var latest WorldState
+	found := false
+	for i := 0; i < b.count; i++ {
+		index := (b.next - 1 - i + len(b.states)) % len(b.states)
+		state := b.states[index]
+		if state.LogicFrame <= logicFrame && (!found || state.LogicFrame > latest.LogicFrame) {
+			latest = state
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// Reset 清空所有状态
+func (b *StateBuffer) Reset() {
+	clear(b.states)
+	b.next = 0
+	b.count = 0
+}
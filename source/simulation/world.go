@@ -0,0 +1,163 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"gameproject/source/gametypes"
+	"sort"
+)
+
+// 技能ID
+const (
+	AbilityMove = 1 // 移动到相邻的格子
+)
+
+var (
+	ErrUnknownPlayer  = errors.New("unknown player")
+	ErrInvalidCommand = errors.New("invalid command type")
+	ErrUnknownAbility = errors.New("unknown ability")
+	ErrOutOfMap       = errors.New("target position out of map")
+	ErrOutOfRange     = errors.New("target position out of range")
+	ErrOccupied       = errors.New("target position occupied")
+)
+
+type PlayerState struct {
+	ID       int
+	Position gametypes.Vector2Int
+}
+
+// World 确定性的世界模型， 服务端与客户端使用相同的输入序列驱动， 得到相同的状态
+// 注意： 执行结果只依赖输入的顺序， 不能依赖map遍历顺序、系统时间或随机数
+type World struct {
+	gameMap    *gametypes.GameMap
+	players    map[int]*PlayerState
+	logicFrame int // 最近一次执行的逻辑帧
+}
+
+func NewWorld(gameMap *gametypes.GameMap) *World {
+	return &World{
+		gameMap: gameMap,
+		players: make(map[int]*PlayerState),
+	}
+}
+
+func (w *World) AddPlayer(id int, position gametypes.Vector2Int) {
+	w.players[id] = &PlayerState{
+		ID:       id,
+		Position: position,
+	}
+}
+
+func (w *World) Player(id int) (PlayerState, bool) {
+	player, ok := w.players[id]
+	if !ok {
+		return PlayerState{}, false
+	}
+	return *player, true
+}
+
+// Players 返回按ID排序的所有玩家状态
+func (w *World) Players() []PlayerState {
+	players := make([]PlayerState, 0, len(w.players))
+	for _, player := range w.players {
+		players = append(players, *player)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].ID < players[j].ID
+	})
+	return players
+}
+
+func (w *World) LogicFrame() int {
+	return w.logicFrame
+}
+
+// ValidateInput 按顺序校验输入中的所有命令， 后面的命令基于前面命令执行后的位置校验
+func (w *World) ValidateInput(input gametypes.PlayerInput) error {
+	player, ok := w.players[input.ID]
+	if !ok {
+		return ErrUnknownPlayer
+	}
+
+	position := player.Position
+	for i, cmd := range input.Commands {
+		target, err := w.resolveCommand(input.ID, position, cmd)
+		if err != nil {
+			return fmt.Errorf("command %d: %w", i, err)
+		}
+		position = target
+	}
+	return nil
+}
+
+// Step 执行某个逻辑帧的输入， inputs 需按服务端转发的顺序排列
+// 执行时不合法的命令（例如目标格子在此期间被占用）会被跳过， 各端跳过的结果相同
+func (w *World) Step(logicFrame int, inputs []gametypes.PlayerInput) []error {
+	var errs []error
+	for _, input := range inputs {
+		if err := w.ApplyInput(input); err != nil {
+			errs = append(errs, fmt.Errorf("player %d frame %d: %w", input.ID, input.LogicFrame, err))
+		}
+	}
+	w.logicFrame = logicFrame
+	return errs
+}
+
+// ApplyInput 执行单个玩家输入， 返回第一个被跳过的命令的错误
+func (w *World) ApplyInput(input gametypes.PlayerInput) error {
+	player, ok := w.players[input.ID]
+	if !ok {
+		return ErrUnknownPlayer
+	}
+
+	var firstErr error
+	for _, cmd := range input.Commands {
+		target, err := w.resolveCommand(input.ID, player.Position, cmd)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		player.Position = target
+	}
+	return firstErr
+}
+
+// resolveCommand 计算命令执行后的玩家位置
+func (w *World) resolveCommand(playerID int, position gametypes.Vector2Int, cmd gametypes.PlayerCommand) (gametypes.Vector2Int, error) {
+	if cmd.CommandType != gametypes.UseAbility {
+		return position, ErrInvalidCommand
+	}
+
+	switch cmd.AbilityID {
+	case AbilityMove:
+		target := cmd.Position
+		if !w.gameMap.Contains(target) {
+			return position, ErrOutOfMap
+		}
+		if position.ManhattanDistance(&target) != 1 {
+			return position, ErrOutOfRange
+		}
+		for id, other := range w.players {
+			if id != playerID && other.Position.Equal(&target) {
+				return position, ErrOccupied
+			}
+		}
+		return target, nil
+	default:
+		return position, ErrUnknownAbility
+	}
+}
+
+// SplitDueInputs 将输入队列分为帧号小于等于logicFrame、本帧需要执行的输入与剩余的输入， 保持原有顺序
+func SplitDueInputs(queue []gametypes.PlayerInput, logicFrame int) (due, remaining []gametypes.PlayerInput) {
+	for _, input := range queue {
+		if input.LogicFrame <= logicFrame {
+			due = append(due, input)
+		} else {
+			remaining = append(remaining, input)
+		}
+	}
+	return due, remaining
+}
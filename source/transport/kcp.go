@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"time"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// kcpTransport 基于UDP的KCP， 丢包时重传比TCP快， 是默认的传输方式
+type kcpTransport struct{}
+
+func (kcpTransport) Name() string {
+	return KCP
+}
+
+func (kcpTransport) Listen(addr string) (Listener, error) {
+	listener, err := kcp.ListenWithOptions(addr, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &kcpListener{listener}, nil
+}
+
+func (kcpTransport) Dial(addr string) (Conn, error) {
+	session, err := kcp.DialWithOptions(addr, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newKCPConn(session), nil
+}
+
+type kcpListener struct {
+	*kcp.Listener
+}
+
+func (l *kcpListener) Accept() (Conn, error) {
+	session, err := l.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	return newKCPConn(session), nil
+}
+
+type kcpConn struct {
+	*kcp.UDPSession
+}
+
+func newKCPConn(session *kcp.UDPSession) *kcpConn {
+	// 消息由framing划分， 使用流模式以支持任意大小的消息
+	session.SetStreamMode(true)
+	return &kcpConn{session}
+}
+
+// SmoothedRTT KCP根据ACK估计的RTT
+func (c *kcpConn) SmoothedRTT() time.Duration {
+	return time.Duration(c.GetSRTT()) * time.Millisecond
+}
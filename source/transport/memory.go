@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Memory 进程内的传输方式名
+const Memory = "memory"
+
+// MemoryNetwork 进程内的传输方式， 用于测试， 不经过操作系统的网络
+// 连接的两端通过内存中的缓冲区通信， 写入不会阻塞， 关闭后对端读完剩余的数据再收到EOF
+// 监听的地址只看端口， ":12345" 与 "127.0.0.1:12345" 是同一个地址
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+	nextPort  int
+
+	pending atomic.Int64 // 已写入、尚未被读取的字节数
+	written atomic.Int64 // 累计写入的字节数
+}
+
+// NewMemoryNetwork 创建一个独立的进程内网络， 不同的网络之间互不相通
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{listeners: make(map[string]*memoryListener), nextPort: 1}
+}
+
+func (m *MemoryNetwork) Name() string {
+	return Memory
+}
+
+func (m *MemoryNetwork) Listen(addr string) (Listener, error) {
+	port, err := memoryPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.listeners[port]; ok {
+		return nil, fmt.Errorf("memory transport: address %s already in use", addr)
+	}
+	l := &memoryListener{
+		network: m,
+		port:    port,
+		conns:   make(chan Conn, 64),
+		done:    make(chan struct{}),
+	}
+	m.listeners[port] = l
+	return l, nil
+}
+
+func (m *MemoryNetwork) Dial(addr string) (Conn, error) {
+	port, err := memoryPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	l, ok := m.listeners[port]
+	clientAddr := memoryAddr(fmt.Sprintf("client:%d", m.nextPort))
+	m.nextPort++
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("memory transport: dial %s: connection refused", addr)
+	}
+
+	toServer := newMemoryPipe(m)
+	toClient := newMemoryPipe(m)
+	serverAddr := memoryAddr("server:" + port)
+	client := &memoryConn{in: toClient, out: toServer, remote: serverAddr}
+	server := &memoryConn{in: toServer, out: toClient, remote: clientAddr}
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, fmt.Errorf("memory transport: dial %s: connection refused", addr)
+	}
+}
+
+// Pending 已写入、尚未被对端读取的字节数， 为0时所有连接上都没有在途的数据
+func (m *MemoryNetwork) Pending() int64 {
+	return m.pending.Load()
+}
+
+// Written 所有连接累计写入的字节数
+func (m *MemoryNetwork) Written() int64 {
+	return m.written.Load()
+}
+
+func memoryPort(addr string) (string, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("memory transport: %w", err)
+	}
+	return port, nil
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return Memory }
+func (a memoryAddr) String() string  { return string(a) }
+
+type memoryListener struct {
+	network   *MemoryNetwork
+	port      string
+	conns     chan Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.network.mu.Lock()
+		delete(l.network.listeners, l.port)
+		l.network.mu.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr("server:" + l.port)
+}
+
+// memoryPipe 单向的字节流
+type memoryPipe struct {
+	network *MemoryNetwork
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	closed   bool
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newMemoryPipe(network *MemoryNetwork) *memoryPipe {
+	p := &memoryPipe{network: network}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *memoryPipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 && !p.closed {
+		// 读超时使用真实时间， 与网络连接一致
+		if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.cond.Wait()
+	}
+	if p.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	n, _ := p.buf.Read(b)
+	p.network.pending.Add(-int64(n))
+	return n, nil
+}
+
+func (p *memoryPipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, net.ErrClosed
+	}
+	p.buf.Write(b)
+	p.network.pending.Add(int64(len(b)))
+	p.network.written.Add(int64(len(b)))
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *memoryPipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.cond.Broadcast()
+}
+
+func (p *memoryPipe) setDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !t.IsZero() {
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+	}
+	p.cond.Broadcast()
+}
+
+type memoryConn struct {
+	in     *memoryPipe
+	out    *memoryPipe
+	remote net.Addr
+}
+
+func (c *memoryConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+func (c *memoryConn) Write(b []byte) (int, error) {
+	return c.out.write(b)
+}
+
+// Close 关闭两个方向， 对端读完剩余的数据后收到EOF
+func (c *memoryConn) Close() error {
+	c.in.close()
+	c.out.close()
+	return nil
+}
+
+func (c *memoryConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+var _ Transport = (*MemoryNetwork)(nil)
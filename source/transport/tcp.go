@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"net"
+)
+
+// tcpTransport 用于屏蔽了UDP的网络， 丢包时延迟比KCP高
+type tcpTransport struct{}
+
+func (tcpTransport) Name() string {
+	return TCP
+}
+
+func (tcpTransport) Listen(addr string) (Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpListener{listener}, nil
+}
+
+// Dial 建立的连接默认关闭了Nagle算法， 输入等小消息立即发送
+func (tcpTransport) Dial(addr string) (Conn, error) {
+	return net.Dial("tcp", addr)
+}
+
+type tcpListener struct {
+	net.Listener
+}
+
+func (l *tcpListener) Accept() (Conn, error) {
+	return l.Listener.Accept()
+}
@@ -0,0 +1,64 @@
+// Package transport 客户端与服务器之间的连接， 屏蔽KCP、TCP、WebSocket等具体协议
+//
+// 所有实现都提供可靠、有序的字节流， 消息由 framing 划分， 上层不需要关心使用的是哪一种协议。
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// 传输方式的名字， 用于配置与命令行参数
+const (
+	KCP       = "kcp"
+	TCP       = "tcp"
+	WebSocket = "websocket"
+)
+
+// Conn 一条可靠、有序的字节流连接， 读和写可以在不同的goroutine中进行
+type Conn interface {
+	io.ReadWriteCloser
+	RemoteAddr() net.Addr
+	SetReadDeadline(t time.Time) error
+}
+
+// Listener 接收客户端的连接
+type Listener interface {
+	// Accept 等待下一个连接， Close 之后返回错误
+	Accept() (Conn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// Transport 创建连接的方式， 服务器使用 Listen， 客户端使用 Dial
+type Transport interface {
+	Name() string
+	Listen(addr string) (Listener, error)
+	Dial(addr string) (Conn, error)
+}
+
+// RTTEstimator 由协议自己估计RTT的连接， 例如KCP
+type RTTEstimator interface {
+	SmoothedRTT() time.Duration
+}
+
+// Names 返回所有内置的传输方式
+func Names() []string {
+	return []string{KCP, TCP, WebSocket}
+}
+
+// New 根据名字创建传输方式， 名字为空时使用KCP
+func New(name string) (Transport, error) {
+	switch name {
+	case "", KCP:
+		return kcpTransport{}, nil
+	case TCP:
+		return tcpTransport{}, nil
+	case WebSocket:
+		return webSocketTransport{}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport: %s", name)
+	}
+}
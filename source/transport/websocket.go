@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketPath 服务器接受WebSocket连接的路径
+const WebSocketPath = "/ws"
+
+// webSocketTransport 供浏览器中的工具连接， 每条消息作为一个二进制帧发送
+type webSocketTransport struct{}
+
+func (webSocketTransport) Name() string {
+	return WebSocket
+}
+
+func (webSocketTransport) Listen(addr string) (Listener, error) {
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &webSocketListener{
+		tcp:   tcp,
+		conns: make(chan *webSocketConn),
+		done:  make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	// 不检查Origin， 浏览器以外的客户端通常不带Origin
+	mux.Handle(WebSocketPath, websocket.Server{Handler: l.serve})
+	l.http = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go l.http.Serve(tcp)
+	return l, nil
+}
+
+func (webSocketTransport) Dial(addr string) (Conn, error) {
+	config, err := websocket.NewConfig("ws://"+addr+WebSocketPath, "http://"+addr+"/")
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, tcp)
+	if err != nil {
+		tcp.Close()
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return &webSocketConn{Conn: ws, remote: tcp.RemoteAddr(), closed: make(chan struct{})}, nil
+}
+
+type webSocketListener struct {
+	tcp   net.Listener
+	http  *http.Server
+	conns chan *webSocketConn
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// serve 处理一个WebSocket连接， 返回后连接被关闭， 所以要等到上层关闭连接后才返回
+func (l *webSocketListener) serve(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+	conn := &webSocketConn{Conn: ws, remote: requestAddr(ws.Request()), closed: make(chan struct{})}
+	if conn.remote == nil {
+		conn.remote = ws.RemoteAddr()
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		return
+	}
+	<-conn.closed
+}
+
+func (l *webSocketListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 停止接受新的连接， 已经建立的连接不受影响， 与TCP一致
+func (l *webSocketListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		// 升级为WebSocket的连接已经脱离 http.Server， 不会被关闭
+		err = l.http.Close()
+	})
+	return err
+}
+
+func (l *webSocketListener) Addr() net.Addr {
+	return l.tcp.Addr()
+}
+
+// webSocketConn 服务端的 websocket.Conn.RemoteAddr 返回的是Origin， 这里改为对方的TCP地址
+type webSocketConn struct {
+	*websocket.Conn
+	remote net.Addr
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *webSocketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// requestAddr 解析HTTP请求的对方地址， 解析失败时返回nil
+func requestAddr(r *http.Request) net.Addr {
+	if r == nil {
+		return nil
+	}
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return addr
+}